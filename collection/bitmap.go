@@ -0,0 +1,523 @@
+package collection
+
+import (
+	"math/bits"
+	"slices"
+)
+
+// Maximal number of values held by an array container, denser containers are converted to bitmaps.
+const arrayContainerMax = 4096
+
+// Number of words of a bitmap container, covering all 2^16 low bits.
+const bitmapContainerWords = 1 << 16 / 64
+
+/*
+Container of the low 16 bits of the CIds sharing the same high bits.
+Sparse containers hold a sorted array, dense ones a plain bitmap.
+*/
+type bitmapContainer struct {
+	array []uint16 // nil if the container is a bitmap
+	words []uint64 // nil if the container is an array
+	card  int
+}
+
+/*
+Compressed set of CIds in the roaring style. The CIds are split into
+the high 48 bits selecting a container and the low 16 bits stored in it.
+Set operations work container by container, so they are cheap for
+large sets of dense CIds, e.g. those of low-cardinality columns.
+The zero value is an empty set.
+*/
+type CIdBitmap struct {
+	keys       []uint64 // sorted high bits
+	containers []*bitmapContainer
+}
+
+/*
+Creates a CId bitmap from the CId slice.
+
+Parameters:
+  - s - CId slice.
+
+Returns:
+  - New CId bitmap.
+*/
+func CIdBitmapFromSlice(s []CId) *CIdBitmap {
+	ego := new(CIdBitmap)
+	for _, cid := range s {
+		ego.Add(cid)
+	}
+	return ego
+}
+
+/*
+Creates a CId bitmap from the CId set.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego CIdSet) ToBitmap() *CIdBitmap {
+	return CIdBitmapFromSlice(ego.ToSlice())
+}
+
+/*
+Finds the container holding the given high bits.
+
+Parameters:
+  - key - high bits of the CId.
+
+Returns:
+  - position of the container, or the position where it would be inserted,
+  - true if the container exists, false otherwise.
+*/
+func (ego *CIdBitmap) find(key uint64) (int, bool) {
+	return slices.BinarySearch(ego.keys, key)
+}
+
+/*
+Adds the CId to the bitmap.
+
+Parameters:
+  - cid - CId to add.
+*/
+func (ego *CIdBitmap) Add(cid CId) {
+	key, low := uint64(cid)>>16, uint16(cid)
+
+	i, found := ego.find(key)
+	if !found {
+		ego.keys = slices.Insert(ego.keys, i, key)
+		ego.containers = slices.Insert(ego.containers, i, &bitmapContainer{array: []uint16{}})
+	}
+	ego.containers[i].add(low)
+}
+
+/*
+Removes the CId from the bitmap.
+
+Parameters:
+  - cid - CId to remove.
+*/
+func (ego *CIdBitmap) Remove(cid CId) {
+	key, low := uint64(cid)>>16, uint16(cid)
+
+	i, found := ego.find(key)
+	if !found {
+		return
+	}
+	c := ego.containers[i]
+	c.remove(low)
+	if c.card == 0 {
+		ego.keys = slices.Delete(ego.keys, i, i+1)
+		ego.containers = slices.Delete(ego.containers, i, i+1)
+	}
+}
+
+/*
+Checks if the CId is in the bitmap.
+
+Parameters:
+  - cid - searched CId.
+
+Returns:
+  - true if the CId is present, false otherwise.
+*/
+func (ego *CIdBitmap) Contains(cid CId) bool {
+	i, found := ego.find(uint64(cid) >> 16)
+	return found && ego.containers[i].contains(uint16(cid))
+}
+
+/*
+Returns:
+  - number of CIds in the bitmap.
+*/
+func (ego *CIdBitmap) Len() int {
+	n := 0
+	for _, c := range ego.containers {
+		n += c.card
+	}
+	return n
+}
+
+/*
+Creates a CId slice from the bitmap.
+
+Returns:
+  - New CId slice, sorted.
+*/
+func (ego *CIdBitmap) ToSlice() []CId {
+	out := make([]CId, 0, ego.Len())
+	ego.ForEach(func(cid CId) {
+		out = append(out, cid)
+	})
+	return out
+}
+
+/*
+Creates a CId set from the bitmap.
+
+Returns:
+  - New CId set.
+*/
+func (ego *CIdBitmap) ToSet() CIdSet {
+	out := make(CIdSet, ego.Len())
+	ego.ForEach(func(cid CId) {
+		out[cid] = true
+	})
+	return out
+}
+
+/*
+Calls the function for every CId of the bitmap, in ascending order.
+
+Parameters:
+  - f - the function.
+*/
+func (ego *CIdBitmap) ForEach(f func(CId)) {
+	for i, c := range ego.containers {
+		high := CId(ego.keys[i] << 16)
+		c.forEach(func(low uint16) {
+			f(high | CId(low))
+		})
+	}
+}
+
+/*
+Creates a bitmap of the CIds within the interval.
+
+Parameters:
+  - lower - lower bound, inclusive,
+  - higher - upper bound, inclusive.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego *CIdBitmap) Range(lower CId, higher CId) *CIdBitmap {
+	out := new(CIdBitmap)
+	if lower > higher {
+		return out
+	}
+
+	lowKey, highKey := uint64(lower)>>16, uint64(higher)>>16
+	start, _ := ego.find(lowKey)
+
+	for i := start; i < len(ego.keys) && ego.keys[i] <= highKey; i++ {
+		key, c := ego.keys[i], ego.containers[i]
+		if key != lowKey && key != highKey {
+			out.keys = append(out.keys, key)
+			out.containers = append(out.containers, c.clone())
+			continue
+		}
+
+		part := &bitmapContainer{array: []uint16{}}
+		c.forEach(func(low uint16) {
+			if cid := CId(key<<16) | CId(low); cid >= lower && cid <= higher {
+				part.add(low)
+			}
+		})
+		if part.card > 0 {
+			out.keys = append(out.keys, key)
+			out.containers = append(out.containers, part)
+		}
+	}
+
+	return out
+}
+
+/*
+Creates a deep copy of the bitmap.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego *CIdBitmap) Clone() *CIdBitmap {
+	out := &CIdBitmap{
+		keys:       slices.Clone(ego.keys),
+		containers: make([]*bitmapContainer, len(ego.containers)),
+	}
+	for i, c := range ego.containers {
+		out.containers[i] = c.clone()
+	}
+	return out
+}
+
+/*
+Intersects two bitmaps.
+
+Parameters:
+  - b - second bitmap.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego *CIdBitmap) Intersect(b *CIdBitmap) *CIdBitmap {
+	out := new(CIdBitmap)
+
+	for i, j := 0, 0; i < len(ego.keys) && j < len(b.keys); {
+		switch {
+		case ego.keys[i] < b.keys[j]:
+			i++
+		case ego.keys[i] > b.keys[j]:
+			j++
+		default:
+			if c := ego.containers[i].and(b.containers[j]); c.card > 0 {
+				out.keys = append(out.keys, ego.keys[i])
+				out.containers = append(out.containers, c)
+			}
+			i++
+			j++
+		}
+	}
+
+	return out
+}
+
+/*
+Unites two bitmaps.
+
+Parameters:
+  - b - second bitmap.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego *CIdBitmap) Merge(b *CIdBitmap) *CIdBitmap {
+	out := new(CIdBitmap)
+
+	i, j := 0, 0
+	for i < len(ego.keys) || j < len(b.keys) {
+		switch {
+		case j == len(b.keys) || i < len(ego.keys) && ego.keys[i] < b.keys[j]:
+			out.keys = append(out.keys, ego.keys[i])
+			out.containers = append(out.containers, ego.containers[i].clone())
+			i++
+		case i == len(ego.keys) || ego.keys[i] > b.keys[j]:
+			out.keys = append(out.keys, b.keys[j])
+			out.containers = append(out.containers, b.containers[j].clone())
+			j++
+		default:
+			out.keys = append(out.keys, ego.keys[i])
+			out.containers = append(out.containers, ego.containers[i].or(b.containers[j]))
+			i++
+			j++
+		}
+	}
+
+	return out
+}
+
+/*
+Removes the CIds of the second bitmap from the first one.
+
+Parameters:
+  - b - second bitmap.
+
+Returns:
+  - New CId bitmap.
+*/
+func (ego *CIdBitmap) Subtract(b *CIdBitmap) *CIdBitmap {
+	out := new(CIdBitmap)
+
+	for i := range ego.keys {
+		c := ego.containers[i]
+		if j, found := b.find(ego.keys[i]); found {
+			c = c.andNot(b.containers[j])
+		} else {
+			c = c.clone()
+		}
+		if c.card > 0 {
+			out.keys = append(out.keys, ego.keys[i])
+			out.containers = append(out.containers, c)
+		}
+	}
+
+	return out
+}
+
+// CONTAINER
+
+func (ego *bitmapContainer) contains(low uint16) bool {
+	if ego.words != nil {
+		return ego.words[low/64]&(1<<(low%64)) != 0
+	}
+	_, found := slices.BinarySearch(ego.array, low)
+	return found
+}
+
+func (ego *bitmapContainer) add(low uint16) {
+	if ego.words != nil {
+		if ego.words[low/64]&(1<<(low%64)) == 0 {
+			ego.words[low/64] |= 1 << (low % 64)
+			ego.card++
+		}
+		return
+	}
+
+	i, found := slices.BinarySearch(ego.array, low)
+	if found {
+		return
+	}
+	ego.array = slices.Insert(ego.array, i, low)
+	ego.card++
+	if ego.card > arrayContainerMax {
+		ego.toWords()
+	}
+}
+
+func (ego *bitmapContainer) remove(low uint16) {
+	if ego.words != nil {
+		if ego.words[low/64]&(1<<(low%64)) != 0 {
+			ego.words[low/64] &^= 1 << (low % 64)
+			ego.card--
+		}
+		if ego.card <= arrayContainerMax {
+			ego.toArray()
+		}
+		return
+	}
+
+	if i, found := slices.BinarySearch(ego.array, low); found {
+		ego.array = slices.Delete(ego.array, i, i+1)
+		ego.card--
+	}
+}
+
+func (ego *bitmapContainer) forEach(f func(uint16)) {
+	if ego.words == nil {
+		for _, low := range ego.array {
+			f(low)
+		}
+		return
+	}
+	for i, w := range ego.words {
+		for w != 0 {
+			t := bits.TrailingZeros64(w)
+			f(uint16(i*64 + t))
+			w &= w - 1
+		}
+	}
+}
+
+func (ego *bitmapContainer) clone() *bitmapContainer {
+	return &bitmapContainer{array: slices.Clone(ego.array), words: slices.Clone(ego.words), card: ego.card}
+}
+
+// Converts the array container to the bitmap one.
+func (ego *bitmapContainer) toWords() {
+	ego.words = make([]uint64, bitmapContainerWords)
+	for _, low := range ego.array {
+		ego.words[low/64] |= 1 << (low % 64)
+	}
+	ego.array = nil
+}
+
+// Converts the bitmap container to the array one.
+func (ego *bitmapContainer) toArray() {
+	array := make([]uint16, 0, ego.card)
+	ego.forEach(func(low uint16) {
+		array = append(array, low)
+	})
+	ego.array = array
+	ego.words = nil
+}
+
+// Recomputes the cardinality of the bitmap container and converts it to an array if it is sparse.
+func (ego *bitmapContainer) normalize() *bitmapContainer {
+	if ego.words == nil {
+		ego.card = len(ego.array)
+		return ego
+	}
+	ego.card = 0
+	for _, w := range ego.words {
+		ego.card += bits.OnesCount64(w)
+	}
+	if ego.card <= arrayContainerMax {
+		ego.toArray()
+	}
+	return ego
+}
+
+func (ego *bitmapContainer) and(c *bitmapContainer) *bitmapContainer {
+	if ego.words != nil && c.words != nil {
+		out := &bitmapContainer{words: make([]uint64, bitmapContainerWords)}
+		for i := range out.words {
+			out.words[i] = ego.words[i] & c.words[i]
+		}
+		return out.normalize()
+	}
+
+	small, large := ego, c
+	if small.words != nil {
+		small, large = c, ego
+	}
+	out := &bitmapContainer{array: make([]uint16, 0, small.card)}
+	for _, low := range small.array {
+		if large.contains(low) {
+			out.array = append(out.array, low)
+		}
+	}
+	return out.normalize()
+}
+
+func (ego *bitmapContainer) or(c *bitmapContainer) *bitmapContainer {
+	if ego.words == nil && c.words == nil && ego.card+c.card <= arrayContainerMax {
+		out := &bitmapContainer{array: make([]uint16, 0, ego.card+c.card)}
+		i, j := 0, 0
+		for i < len(ego.array) && j < len(c.array) {
+			switch {
+			case ego.array[i] < c.array[j]:
+				out.array = append(out.array, ego.array[i])
+				i++
+			case ego.array[i] > c.array[j]:
+				out.array = append(out.array, c.array[j])
+				j++
+			default:
+				out.array = append(out.array, ego.array[i])
+				i++
+				j++
+			}
+		}
+		out.array = append(out.array, ego.array[i:]...)
+		out.array = append(out.array, c.array[j:]...)
+		return out.normalize()
+	}
+
+	out := &bitmapContainer{words: make([]uint64, bitmapContainerWords)}
+	for _, src := range []*bitmapContainer{ego, c} {
+		if src.words != nil {
+			for i, w := range src.words {
+				out.words[i] |= w
+			}
+		} else {
+			for _, low := range src.array {
+				out.words[low/64] |= 1 << (low % 64)
+			}
+		}
+	}
+	return out.normalize()
+}
+
+func (ego *bitmapContainer) andNot(c *bitmapContainer) *bitmapContainer {
+	if ego.words != nil && c.words != nil {
+		out := &bitmapContainer{words: make([]uint64, bitmapContainerWords)}
+		for i := range out.words {
+			out.words[i] = ego.words[i] &^ c.words[i]
+		}
+		return out.normalize()
+	}
+
+	if ego.words != nil {
+		out := ego.clone()
+		for _, low := range c.array {
+			out.words[low/64] &^= 1 << (low % 64)
+		}
+		return out.normalize()
+	}
+
+	out := &bitmapContainer{array: make([]uint16, 0, ego.card)}
+	for _, low := range ego.array {
+		if !c.contains(low) {
+			out.array = append(out.array, low)
+		}
+	}
+	return out.normalize()
+}
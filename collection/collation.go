@@ -0,0 +1,166 @@
+package collection
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/DanielSvub/gonatus/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+/*
+Applies the collation to the string.
+Accents are stripped first, then the string is normalized and case folded.
+
+Parameters:
+  - s - the string.
+
+Returns:
+  - collation key of the string.
+*/
+func (ego CollationConf) apply(s string) string {
+	if ego.StripAccents {
+		t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+		s, _, _ = transform.String(t, s)
+	}
+
+	switch ego.Normalization {
+	case NORM_NFC:
+		s = norm.NFC.String(s)
+	case NORM_NFKC:
+		s = norm.NFKC.String(s)
+	}
+
+	if ego.CaseFold {
+		s = cases.Fold().String(s)
+	}
+
+	return s
+}
+
+/*
+Applies the collation to the value of a string or []string column.
+Values of other types are returned as they are.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - collated value.
+*/
+func (ego CollationConf) applyValue(v any) any {
+	switch val := v.(type) {
+	case string:
+		return ego.apply(val)
+	case []string:
+		out := make([]string, len(val))
+		for i, s := range val {
+			out[i] = ego.apply(s)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// COLLATED INDEX
+type collatedIndexer struct {
+	ramCollectionIndexer
+	collation CollationConf
+}
+
+/*
+Searches for rows matching the collated value.
+
+Parameters:
+  - v - Searched value.
+
+Returns:
+  - CIds of rows that match,
+  - error, if any.
+*/
+func (ego *collatedIndexer) Get(v any) ([]CId, error) {
+	return ego.ramCollectionIndexer.Get(ego.collation.applyValue(v))
+}
+
+/*
+Adds the collated value to the index.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *collatedIndexer) Add(v any, id CId) error {
+	return ego.ramCollectionIndexer.Add(ego.collation.applyValue(v), id)
+}
+
+/*
+Removes the collated value from the index.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *collatedIndexer) Del(v any, id CId) error {
+	return ego.ramCollectionIndexer.Del(ego.collation.applyValue(v), id)
+}
+
+/*
+Returns:
+  - collation of the indexer, the zero collation if the indexer is not collated.
+*/
+func indexerCollation(idx ramCollectionIndexer) CollationConf {
+	if ci, ok := idx.(*collatedIndexer); ok {
+		return ci.collation
+	}
+	return CollationConf{}
+}
+
+/*
+Searches for rows matching the value under the collation, without any index.
+Has to be called under the lock.
+
+Parameters:
+  - col - position of the searched column,
+  - v - searched value,
+  - collation - the collation,
+  - prefix - true for the prefix match, false for the full match.
+
+Returns:
+  - CIds of rows that match,
+  - error, if any.
+*/
+func (ego *RamCollection) collatedScan(col int, v any, collation CollationConf, prefix bool) ([]CId, error) {
+	ret := make([]CId, 0)
+
+	switch val := collation.applyValue(v).(type) {
+	case string:
+		for id, row := range ego.rows {
+			s := collation.apply(row[col].(string))
+			if s == val || prefix && strings.HasPrefix(s, val) {
+				ret = append(ret, id)
+			}
+		}
+	case []string:
+		for id, row := range ego.rows {
+			s := collation.applyValue(row[col]).([]string)
+			if slices.Equal(s, val) || prefix && len(s) >= len(val) && slices.Equal(s[:len(val)], val) {
+				ret = append(ret, id)
+			}
+		}
+	default:
+		return nil, errors.NewValueError(ego, errors.LevelError, "Collation applies to string columns only.")
+	}
+
+	return ret, nil
+}
@@ -1,6 +1,8 @@
 package collection
 
 import (
+	"time"
+
 	"github.com/DanielSvub/gonatus"
 	"github.com/DanielSvub/stream"
 )
@@ -24,27 +26,141 @@ type IndexerConf interface {
 type SpatialIndexerConf interface {
 }
 
+const (
+	NORM_NONE = iota // Strings are kept as they are.
+	NORM_NFC         // Canonical composition, e.g. "e" followed by a combining acute equals "é".
+	NORM_NFKC        // Compatibility composition, e.g. "ﬁ" equals "fi".
+)
+
+/*
+Rules for comparing strings, applied both to the indexed and the searched values.
+The zero value compares the raw bytes.
+*/
+type CollationConf struct {
+	Normalization int
+	CaseFold      bool
+	StripAccents  bool
+}
+
 type PrefixIndexConf[T any] struct {
 	IndexerConf
 	Name      string
 	MinPrefix uint
+	Collation CollationConf // Only for string and []string columns.
 }
 
 type FullmatchIndexConf[T any] struct {
+	IndexerConf
+	Name      string
+	Collation CollationConf // Only for string and []string columns.
+}
+
+// Fullmatch index keeping a compressed bitmap of CIds per value, suitable for low-cardinality columns.
+type BitmapIndexConf[T any] struct {
 	IndexerConf
 	Name string
 }
 
+// Index of the n-grams of a string column, serves the suffix, contains and glob queries.
+type NgramIndexConf struct {
+	IndexerConf
+	Name string
+	N    uint // Length of the n-grams, zero means 3.
+}
+
+// Query match types without own index confs.
+type SuffixMatchConf struct {
+	IndexerConf
+}
+
+type ContainsMatchConf struct {
+	IndexerConf
+}
+
+// Glob pattern, "*" matches any sequence of characters, "?" any single character.
+type GlobMatchConf struct {
+	IndexerConf
+}
+
+// Values within the edit distance from the searched string, ordered by the distance unless sorted otherwise.
+// Within conjunctions and disjunctions, the records are ordered by the smallest distance of any fuzzy atom.
+type FuzzyMatchConf struct {
+	IndexerConf
+	MaxDistance    int
+	Transpositions bool // Counts a swap of adjacent characters as a single edit (Damerau distance).
+}
+
+// Regular expression in the syntax of the regexp package, matching anywhere in the value unless anchored.
+type RegexMatchConf struct {
+	IndexerConf
+}
+
+/*
+Matches the records by their CIds, the name of the atom is ignored.
+The value is a CId, a []CId set or a QueryRange[CId] with inclusive bounds, zero Higher meaning no upper bound.
+*/
+type IdMatchConf struct {
+	IndexerConf
+}
+
+/*
+Column derived from other columns of the record by a function registered by RegisterFunction.
+Computed columns follow the stored columns in the records, they are kept in sync on every change
+and can be indexed like the stored ones. Values given for them in the records are ignored.
+Built-in functions are "lower" and "upper" of a string, "last" element of a []string and "year" of a time.Time.
+*/
+type ComputedConf struct {
+	Name      string
+	Field     FielderConf // Type of the computed values, e.g. FieldConf[string]{}.
+	Function  string
+	Arguments []string // Columns passed to the function, only the stored and the preceding computed ones.
+}
+
+// Actions on the deletion of a referenced record.
+const (
+	ON_DELETE_RESTRICT = iota // The deletion fails.
+	ON_DELETE_CASCADE         // The referencing records are deleted too.
+	ON_DELETE_SET_NULL        // The referencing columns are set to their zero values.
+)
+
+// Check of the values of a column, all given conditions have to hold.
+type CheckConf struct {
+	Column   string
+	Min      FielderConf // Inclusive lower bound, nil means none.
+	Max      FielderConf // Inclusive upper bound, nil means none.
+	Pattern  string      // Regular expression the string values have to match, empty means any.
+	NonEmpty bool        // Strings and slices must not be empty.
+}
+
+/*
+Reference of a column to the records of a collection, the zero value of the column means no reference.
+References to other collections are bound by RamCollection.Link.
+The changes of a collection lock the referenced and the referencing collections too, all in the order of their creation.
+The references are enforced on the changes made through the collections, expired records are reaped without the on-delete actions.
+*/
+type ReferenceConf struct {
+	Column     string
+	Collection string // Name of the referenced collection, empty means this collection.
+	Target     string // Referenced column, empty means the CId of the record (the referencing column has to be uint64 then).
+	OnDelete   int
+}
+
 type SchemaConf struct {
 	Name         string
 	FieldsNaming []string
 	Fields       []FielderConf
 	Indexes      [][]IndexerConf
+	Computed     []ComputedConf
+	Checks       []CheckConf
+	References   []ReferenceConf
+	ExpiryColumn string        // Time column determining the expiry of the records, empty means the records never expire.
+	TTL          time.Duration // Lifetime of the records since the time in the expiry column, zero means the column holds the expiry time itself.
 }
 
 type RecordConf struct {
-	Id   CId
-	Cols []FielderConf
+	Id      CId
+	Cols    []FielderConf
+	Version uint64 // Version of the record as read, zero means the version is not checked on edits.
 }
 
 type QueryConf interface {
@@ -63,6 +179,9 @@ type FilterArgument struct {
 	SortOrder int
 	Skip      int
 	Limit     int
+	AsOf      time.Time     // Queries the past state of a versioned collection, zero means the current state.
+	Collation CollationConf // Collation of the string sort column.
+	After     Cursor        // Continues after the row the cursor points to, empty means from the beginning.
 }
 
 type QueryAtomConf struct {
@@ -107,11 +226,28 @@ type QueryRange[T any] struct {
 
 type Collection interface {
 	gonatus.Gobjecter
+	Schema() SchemaConf
+	Get(...CId) ([]RecordConf, error)
 	Filter(FilterArgument) (stream.Producer[RecordConf], error)
+	FilterPage(FilterArgument) (PageConf, error)
+	Count(QueryConf) (int, error)
+	Exists(QueryConf) (bool, error)
+	Stats() (StatsConf, error)
+	Sample(FilterArgument, int) ([]RecordConf, error)
+	StratifiedSample(FilterArgument, string, int) ([]RecordConf, error)
+	Distinct(string, QueryConf) ([]DistinctConf, error)
 	// Group(QueryConf, GroupQueryConf) (streams.ReadableOutputStreamer[GroupRecordConf], error) // TODO: define grouping
 	AddRecord(RecordConf) (CId, error)
 	DeleteRecord(RecordConf) error
+	// Atomic for a single RamCollection, a ShardedCollection keeps the deletions done before a failure.
 	DeleteByFilter(FilterArgument) error
 	EditRecord(RecordConf) error
+	PatchRecord(CId, PatchConf) error
+	Upsert(RecordConf, []string) (CId, error)
+	// All-or-nothing for a single RamCollection. A ShardedCollection is not atomic across its shards,
+	// on a failure it keeps the records already updated and returns their number together with the error.
+	UpdateWhere(FilterArgument, PatchConf) (int, error)
+	AddHook(int, MutationHook) (HookId, error)
+	RemoveHook(HookId) error
 	Commit() error
 }
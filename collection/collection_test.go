@@ -2,10 +2,19 @@ package collection_test
 
 import (
 	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	. "github.com/DanielSvub/gonatus/collection"
+	"github.com/DanielSvub/gonatus/errors"
 )
 
 func TestCollection(t *testing.T) {
@@ -1081,6 +1090,32 @@ func TestCollection(t *testing.T) {
 			t.Error(err)
 		}
 	})
+	t.Run("andEvaluatesAllSubqueries", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Pets",
+			FieldsNaming: []string{"kind", "name"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "kind"}}, {FullmatchIndexConf[string]{Name: "name"}}},
+		}})
+		for _, pet := range [][2]string{{"cat", "Tom"}, {"cat", "Kitty"}, {"dog", "Tom"}} {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: pet[0]}, FieldConf[string]{Value: pet[1]}}})
+		}
+
+		// the second subquery used to be ignored, the first one was evaluated repeatedly
+		out, err := filterCollect(rmc, FilterArgument{
+			QueryConf: QueryAndConf{QueryContextConf{Context: []QueryConf{
+				QueryAtomConf{Name: "kind", Value: "cat", MatchType: FullmatchIndexConf[string]{}},
+				QueryAtomConf{Name: "name", Value: "Tom", MatchType: FullmatchIndexConf[string]{}},
+			}}},
+			Limit: NO_LIMIT,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != 1 || out[0].Cols[1].(FieldConf[string]).Value != "Tom" || out[0].Cols[0].(FieldConf[string]).Value != "cat" {
+			t.Errorf("Conjunction should match the single cat named Tom, got %v.", out)
+		}
+	})
 	t.Run("or", func(t *testing.T) {
 		output := testLogical(t, "or")
 
@@ -1297,107 +1332,2914 @@ func TestCollection(t *testing.T) {
 
 	})
 
-	// TODO
-	t.Run("commit", func(t *testing.T) {
-		// TODO: ...
-		rmc := prepareTable(true, true, false)
-		err := rmc.Commit()
-		if err != nil {
-			t.Error(err.Error())
+	// JOIN
+	t.Run("join", func(t *testing.T) {
+		for _, indexed := range []bool{false, true} {
+			files, owners := prepareJoinTables(indexed)
+
+			jc := JoinConf{Type: INNER_JOIN, LeftOn: []string{"owner"}, RightOn: []string{"name"}}
+
+			s, err := Join(files, owners, jc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err := s.Collect()
+			if err != nil {
+				t.Error(err)
+			}
+			if len(output) != 3 {
+				t.Errorf("Inner join: expected 3 rows, got %d.", len(output))
+			}
+			for _, o := range output {
+				if o.Left.Cols[1].(FieldConf[string]).Value != o.Right.Cols[0].(FieldConf[string]).Value {
+					t.Error("Joined rows do not match.")
+				}
+			}
+
+			jc.Type = LEFT_JOIN
+			s, _ = Join(files, owners, jc)
+			output, _ = s.Collect()
+			if len(output) != 4 || output[3].Right.Id.ValidP() {
+				t.Error("Left join: unmatched row missing.")
+			}
+
+			jc.Type = SEMI_JOIN
+			s, _ = Join(files, owners, jc)
+			output, _ = s.Collect()
+			if len(output) != 2 || output[0].Left.Id != 1 || output[1].Left.Id != 2 {
+				t.Error("Semi join: wrong rows.")
+			}
+
+			jc.Type = ANTI_JOIN
+			s, _ = Join(files, owners, jc)
+			output, _ = s.Collect()
+			if len(output) != 1 || output[0].Left.Id != 3 {
+				t.Error("Anti join: wrong rows.")
+			}
+		}
+
+		files, owners := prepareJoinTables(true)
+		if _, err := Join(files, owners, JoinConf{LeftOn: []string{"size"}, RightOn: []string{"name"}}); err == nil {
+			t.Error("Joining columns of different types should fail.")
+		}
+		if _, err := Join(files, owners, JoinConf{LeftOn: []string{"owner"}, RightOn: []string{"nope"}}); err == nil {
+			t.Error("Joining on a missing column should fail.")
 		}
+
+		// the indexes of the right collection are replaced by the clearing while the join looks them up
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				owners.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}})
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			if _, err := Join(files, owners, JoinConf{LeftOn: []string{"owner"}, RightOn: []string{"name"}}); err != nil {
+				t.Error(err)
+			}
+		}
+		<-done
 	})
-}
 
-func testNthLine(rc []RecordConf, n int) error {
-	rclen := len(rc[n].Cols)
+	// IMPORT & EXPORT
+	t.Run("importExport", func(t *testing.T) {
+		dir := t.TempDir()
+		now := time.Now().Truncate(time.Second)
 
-	if rclen != 2 {
-		return fmt.Errorf("Wrong number of result columns %d", rclen)
-	}
+		src := prepareExchangeTable()
+		for i := 0; i < 3; i++ {
+			src.AddRecord(RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: fmt.Sprintf("file,%d", i)},
+				FieldConf[int]{Value: i % 2},
+				FieldConf[[]string]{Value: []string{"a", fmt.Sprint(i)}},
+				FieldConf[time.Time]{Value: now.Add(time.Duration(i) * time.Hour)},
+			}})
+		}
+
+		for _, format := range []int{FORMAT_NDJSON, FORMAT_CSV} {
+			for _, bulk := range []bool{false, true} {
+				path := fmt.Sprintf("%s/export%d", dir, format)
+				if err := Export(src, ExportConf{Path: path, Format: format, IdKey: "id"}); err != nil {
+					t.Fatal(err)
+				}
 
-	col1, ok1 := rc[n].Cols[0].(FieldConf[string])
+				dst := prepareExchangeTable()
+				n, failed, err := Import(dst, ImportConf{Path: path, Format: format, IdKey: "id", Bulk: bulk})
+				if err != nil || n != 3 || len(failed) != 0 {
+					t.Fatalf("Import failed: %d rows, %v, %v", n, failed, err)
+				}
 
-	if !ok1 {
-		return fmt.Errorf("Cannot cast to the original FieldStringConf.")
-	}
+				output, err := filterCollect(dst, FilterArgument{
+					Limit:     NO_LIMIT,
+					QueryConf: QueryAtomConf{Name: "size", Value: 0, MatchType: FullmatchIndexConf[int]{}},
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				if len(output) != 2 || output[0].Id != 1 || output[1].Id != 3 {
+					t.Fatal("Wrong rows found in the imported collection.")
+				}
+				if output[1].Cols[0].(FieldConf[string]).Value != "file,2" ||
+					output[1].Cols[2].(FieldConf[[]string]).Value[1] != "2" ||
+					!output[1].Cols[3].(FieldConf[time.Time]).Value.Equal(now.Add(2*time.Hour)) {
+					t.Error("Values were not preserved.")
+				}
+			}
+		}
 
-	if col1.Value != "row0_str110" && col1.Value != "row1_str121" {
-		return fmt.Errorf("Wrong order of values, got: %s", col1.Value)
-	}
+		path := dir + "/broken.ndjson"
+		os.WriteFile(path, []byte(`{"file": "x", "size": 1}
+{"file": "y", "size": "big"}
+not json
+{"file": "z", "tags": ["b"]}
+`), 0664)
 
-	col1_2, ok1_2 := rc[n].Cols[1].(FieldConf[string])
+		dst := prepareExchangeTable()
+		n, failed, err := Import(dst, ImportConf{Path: path, Format: FORMAT_NDJSON, Mapping: map[string]string{"file": "name"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 || len(failed) != 2 || failed[0].Line != 2 || failed[1].Line != 3 {
+			t.Errorf("Expected lines 2 and 3 to fail, got %v.", failed)
+		}
+		output, _ := filterCollect(dst, FilterArgument{Limit: NO_LIMIT, QueryConf: QueryAndConf{}})
+		if len(output) != 2 || output[1].Cols[0].(FieldConf[string]).Value != "z" {
+			t.Error("Header mapping not applied.")
+		}
 
-	if col1.Value == "row0_str110" {
-		if !ok1_2 {
-			return fmt.Errorf("Cannot cast to the original FieldStringConf.")
+		// the batches loaded before a failing one stay imported
+		dst = prepareExchangeTable()
+		dst.AddRecord(RecordConf{Id: 3, Cols: []FielderConf{FieldConf[string]{}, FieldConf[int]{}, FieldConf[[]string]{}, FieldConf[time.Time]{}}})
+		n, _, err = Import(dst, ImportConf{Path: fmt.Sprintf("%s/export%d", dir, FORMAT_NDJSON), Format: FORMAT_NDJSON, IdKey: "id", Bulk: true, Batch: 2})
+		if err == nil || n != 2 {
+			t.Errorf("Expected 2 imported rows and the failure of the second batch, got %d (%v).", n, err)
 		}
-		if col1_2.Value != "row0_str345" {
-			return fmt.Errorf("Wrong second value of column 1.")
+		if count, _ := dst.Count(QueryAndConf{}); count != 3 {
+			t.Errorf("Expected 3 records after the partial import, got %d.", count)
 		}
-	} else {
-		if !ok1_2 {
-			return fmt.Errorf("Cannot cast to the original FieldStringConf.")
+
+		if _, err := os.Stat("/dev/full"); err == nil {
+			if err := Export(src, ExportConf{Path: "/dev/full", Format: FORMAT_NDJSON}); err == nil {
+				t.Error("Failed write of the export should be reported.")
+			}
 		}
-		if col1_2.Value != "row1_str368" {
-			return fmt.Errorf("Wrong second value of column 2.")
+	})
+
+	// SNAPSHOT
+	t.Run("snapshot", func(t *testing.T) {
+		rmc := prepareTable(true, false, false)
+		if err := testFilling(rmc, 5, false); err != nil {
+			t.Error(err)
 		}
-	}
 
-	return nil
-}
+		s, err := rmc.Filter(FilterArgument{Limit: NO_LIMIT, QueryConf: QueryAndConf{}})
+		if err != nil {
+			t.Fatal(err)
+		}
 
-func prepareTable(fullmatchIndexP bool, prefixIndexP bool, stringPrefixIndexP bool) *RamCollection {
+		first := make([]RecordConf, 1)
+		if _, err := s.Read(first); err != nil || first[0].Id != 1 {
+			t.Fatal("Cannot read the first record.")
+		}
 
-	rmC := RamCollectionConf{
-		SchemaConf: SchemaConf{
-			Name:         "FooBarTable",
-			FieldsNaming: []string{"who", "whom"},
-			Fields: []FielderConf{
-				FieldConf[string]{},
-				FieldConf[string]{},
-			},
-			Indexes: [][]IndexerConf{{}},
-		},
-		MaxMemory: 1024 * 1024 * 1024,
-	}
+		// writers must not wait for the stream to be consumed
+		rc := RecordConf{Id: 3, Cols: []FielderConf{FieldConf[string]{Value: "changed"}, FieldConf[string]{Value: "changed"}}}
+		if err := rmc.EditRecord(rc); err != nil {
+			t.Error(err)
+		}
+		if err := rmc.DeleteRecord(RecordConf{Id: 4}); err != nil {
+			t.Error(err)
+		}
+		if _, err := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{}, FieldConf[string]{}}}); err != nil {
+			t.Error(err)
+		}
 
-	if fullmatchIndexP {
+		rest, err := s.Collect()
+		if err != nil {
+			t.Error(err)
+		}
+		if len(rest) != 4 || rest[1].Id != 3 || rest[2].Id != 4 {
+			t.Fatal("The stream does not reflect the state at the time of the filter.")
+		}
+		if rest[1].Cols[0].(FieldConf[string]).Value != "row2_str132" {
+			t.Error("Edit is visible in the older stream.")
+		}
 
-		rmC.Indexes = [][]IndexerConf{
-			{
-				FullmatchIndexConf[string]{Name: "who"},
-				FullmatchIndexConf[string]{Name: "whom"},
+		output, _ := filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "who", Value: "changed", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 1 || output[0].Id != 3 {
+			t.Error("Edited value not found in the index.")
+		}
+	})
+
+	// HISTORY
+	t.Run("history", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Versioned",
+				FieldsNaming: []string{"name"},
+				Fields:       []FielderConf{FieldConf[string]{}},
+				Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "name"}}},
 			},
+			Versioned: true,
+		})
+
+		byName := func(name string, at time.Time) []RecordConf {
+			output, err := filterCollect(rmc, FilterArgument{
+				Limit:     NO_LIMIT,
+				QueryConf: QueryAtomConf{Name: "name", Value: name, MatchType: FullmatchIndexConf[string]{}},
+				AsOf:      at,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return output
 		}
-	}
 
-	if prefixIndexP {
+		id, _ := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "first"}}})
+		afterAdd := time.Now()
+		rmc.EditRecord(RecordConf{Id: id, Cols: []FielderConf{FieldConf[string]{Value: "second"}}})
+		afterEdit := time.Now()
+		rmc.DeleteRecord(RecordConf{Id: id})
 
-		rmC.SchemaConf.Fields = []FielderConf{
-			FieldConf[[]string]{},
-			FieldConf[[]string]{},
+		if len(byName("first", afterAdd)) != 1 || len(byName("second", afterAdd)) != 0 {
+			t.Error("Wrong state after the addition.")
+		}
+		if len(byName("first", afterEdit)) != 0 || len(byName("second", afterEdit)) != 1 {
+			t.Error("Wrong state after the edit.")
+		}
+		if len(byName("second", time.Time{})) != 0 || len(byName("first", afterAdd.Add(-time.Hour))) != 0 {
+			t.Error("Record visible when it did not exist.")
 		}
 
-		rmC.Indexes = [][]IndexerConf{
-			{
-				PrefixIndexConf[[]string]{Name: "who"},
-				PrefixIndexConf[[]string]{Name: "whom"},
-			},
+		s, err := rmc.History(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs, _ := s.Collect()
+		if len(revs) != 3 || revs[0].Cols[0].(FieldConf[string]).Value != "first" ||
+			revs[1].Cols[0].(FieldConf[string]).Value != "second" || !revs[2].Deleted {
+			t.Error("Wrong history of the record.")
+		}
+		if revs[0].Time.After(afterAdd) || revs[1].Time.Before(afterAdd) {
+			t.Error("Wrong times of the revisions.")
 		}
-	}
 
-	if stringPrefixIndexP {
+		for _, name := range []string{"a", "b", "c"} {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}}})
+		}
+		if err := rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}, Limit: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := rmc.Count(QueryAndConf{}); n != 2 {
+			t.Errorf("The limit of the deletion should be respected, %d records left.", n)
+		}
+		if err := rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}, AsOf: afterAdd, Limit: NO_LIMIT}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := rmc.Count(QueryAndConf{}); n != 2 {
+			t.Errorf("Only the records matching in the past should be deleted, %d records left.", n)
+		}
+		if err := rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}}); err != nil {
+			t.Fatal(err)
+		}
+		fresh, _ := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "new"}}})
+		if s, err = rmc.History(fresh); err != nil {
+			t.Fatal(err)
+		}
+		if revs, _ = s.Collect(); len(revs) != 1 || revs[0].Cols[0].(FieldConf[string]).Value != "new" {
+			t.Errorf("The CId of a cleared record should not be reused, got history %v.", revs)
+		}
 
-		rmC.Indexes = [][]IndexerConf{
-			{
-				PrefixIndexConf[string]{Name: "who"},
-				PrefixIndexConf[string]{Name: "whom"},
+		// unchanged records are found by the indexes of the collection, changed ones by their revisions
+		stable, _ := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "stable"}}})
+		beforeRename := time.Now()
+		rmc.EditRecord(RecordConf{Id: fresh, Cols: []FielderConf{FieldConf[string]{Value: "renamed"}}})
+		if out := byName("stable", beforeRename); len(out) != 1 || out[0].Id != stable || out[0].Version != 1 {
+			t.Errorf("Unchanged record should keep its version in the past, got %v.", out)
+		}
+		if len(byName("new", beforeRename)) != 1 || len(byName("renamed", beforeRename)) != 0 {
+			t.Error("Changed record should have its past state.")
+		}
+
+		retained := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{Name: "Retained", FieldsNaming: []string{"name"}, Fields: []FielderConf{FieldConf[string]{}}},
+			Versioned:  true,
+			Retention:  100 * time.Millisecond,
+		})
+		kept, _ := retained.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "first"}}})
+		retained.EditRecord(RecordConf{Id: kept, Cols: []FielderConf{FieldConf[string]{Value: "second"}}})
+		gone, _ := retained.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "gone"}}})
+		retained.DeleteRecord(RecordConf{Id: gone})
+		time.Sleep(120 * time.Millisecond)
+		beforeThird := time.Now()
+		retained.EditRecord(RecordConf{Id: kept, Cols: []FielderConf{FieldConf[string]{Value: "third"}}})
+
+		if s, err = retained.History(kept); err != nil {
+			t.Fatal(err)
+		}
+		if revs, _ = s.Collect(); len(revs) != 2 || revs[0].Cols[0].(FieldConf[string]).Value != "second" {
+			t.Errorf("Only the state at the retention horizon and the newer revisions should be kept, got %v.", revs)
+		}
+		if _, err := retained.History(gone); err == nil {
+			t.Error("History of a record deleted before the retention horizon should be dropped.")
+		}
+		if _, err := retained.Filter(FilterArgument{QueryConf: QueryAndConf{}, AsOf: afterAdd}); err == nil {
+			t.Error("State before the retention horizon should not be available.")
+		}
+		out, err := filterCollect(retained, FilterArgument{QueryConf: QueryAndConf{}, AsOf: beforeThird, Limit: NO_LIMIT})
+		if err != nil || len(out) != 1 || out[0].Cols[0].(FieldConf[string]).Value != "second" {
+			t.Errorf("Retained state should be available, got %v (%v).", out, err)
+		}
+
+		plain := prepareTable(false, false, false)
+		if _, err := plain.Filter(FilterArgument{QueryConf: QueryAndConf{}, AsOf: time.Now()}); err == nil {
+			t.Error("Time travel should fail for a collection which is not versioned.")
+		}
+	})
+
+	// EXPIRY
+	t.Run("expiry", func(t *testing.T) {
+		conf := RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Sessions",
+				FieldsNaming: []string{"user", "expires"},
+				Fields:       []FielderConf{FieldConf[string]{}, FieldConf[time.Time]{}},
+				Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "user"}}},
+				ExpiryColumn: "expires",
 			},
 		}
+		rmc := NewRamCollection(conf)
 
-	}
+		now := time.Now()
+		for i, expires := range []time.Time{now.Add(-time.Second), now.Add(time.Hour), {}} {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: fmt.Sprintf("user%d", i)},
+				FieldConf[time.Time]{Value: expires},
+			}})
+		}
 
-	return NewRamCollection(rmC)
+		output, _ := filterCollect(rmc, FilterArgument{Limit: NO_LIMIT, QueryConf: QueryAndConf{}})
+		if len(output) != 2 || output[0].Id != 2 || output[1].Id != 3 {
+			t.Error("Expired record is visible.")
+		}
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "user", Value: "user0", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 0 {
+			t.Error("Expired record is visible through the index.")
+		}
+
+		if n, err := rmc.Reap(); err != nil || n != 1 {
+			t.Errorf("Expected 1 reaped record, got %d.", n)
+		}
+		if n, _ := rmc.Reap(); n != 0 {
+			t.Error("Record reaped twice.")
+		}
+
+		// a vetoed record does not stop the reaping of the others
+		vetoing := NewRamCollection(conf)
+		for _, user := range []string{"a", "keep", "b", "c"} {
+			vetoing.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: user}, FieldConf[time.Time]{Value: now.Add(-time.Second)}}})
+		}
+		vetoing.AddHook(BEFORE_DELETE, func(m *MutationConf) error {
+			if m.Old.Cols[0].(FieldConf[string]).Value == "keep" {
+				return fmt.Errorf("kept")
+			}
+			return nil
+		})
+		if n, err := vetoing.Reap(); err == nil || n != 3 {
+			t.Errorf("Expected 3 reaped records and the veto, got %d (%v).", n, err)
+		}
+		if n, err := vetoing.Reap(); err == nil || n != 0 {
+			t.Errorf("The vetoed record should fail again, got %d (%v).", n, err)
+		}
+
+		conf.FieldsNaming[1] = "created"
+		conf.ExpiryColumn = "created"
+		conf.TTL = time.Minute
+		conf.ReapInterval = time.Millisecond
+		rmc = NewRamCollection(conf)
+		defer rmc.Close()
+
+		rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "old"}, FieldConf[time.Time]{Value: now.Add(-time.Hour)}}})
+		rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "new"}, FieldConf[time.Time]{Value: now}}})
+
+		time.Sleep(50 * time.Millisecond)
+		if n, _ := rmc.Reap(); n != 0 {
+			t.Error("Expired record not reaped in the background.")
+		}
+		output, _ = filterCollect(rmc, FilterArgument{Limit: NO_LIMIT, QueryConf: QueryAndConf{}})
+		if len(output) != 1 || output[0].Id != 2 {
+			t.Error("Wrong records left after reaping.")
+		}
+
+		// concurrent closes must not close the stop channel twice
+		closing := NewRamCollection(conf)
+		done := make(chan struct{})
+		for i := 0; i < 8; i++ {
+			go func() {
+				closing.Close()
+				done <- struct{}{}
+			}()
+		}
+		for i := 0; i < 8; i++ {
+			<-done
+		}
+
+		conf.ExpiryColumn = "user"
+		if NewRamCollection(conf) != nil {
+			t.Error("Expiry column has to be of time type.")
+		}
+	})
+
+	// UPSERT
+	t.Run("upsert", func(t *testing.T) {
+		for _, indexed := range []bool{false, true} {
+			rmc := prepareTable(indexed, false, false)
+			if err := testFilling(rmc, 3, false); err != nil {
+				t.Error(err)
+			}
+
+			rec := RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "row1_str121"}, FieldConf[string]{Value: "upserted"}}}
+			if id, err := rmc.Upsert(rec, []string{"who"}); err != nil || id != 2 {
+				t.Errorf("Expected existing record 2 to be edited, got %d.", id)
+			}
+			rec.Cols[0] = FieldConf[string]{Value: "newcomer"}
+			if id, err := rmc.Upsert(rec, []string{"who", "whom"}); err != nil || id != 4 {
+				t.Errorf("Expected a new record 4, got %d.", id)
+			}
+			if id, _ := rmc.Upsert(rec, []string{"who", "whom"}); id != 4 {
+				t.Error("Upsert of the same key created a new record.")
+			}
+
+			output, _ := filterCollect(rmc, FilterArgument{
+				Limit:     NO_LIMIT,
+				QueryConf: QueryAtomConf{Name: "whom", Value: "upserted", MatchType: FullmatchIndexConf[string]{}},
+			})
+			if len(output) != 2 || output[0].Id != 2 || output[1].Id != 4 {
+				t.Error("Wrong records after upsert.")
+			}
+			if output[0].Version != 2 || output[1].Version != 2 {
+				t.Errorf("Wrong versions %d and %d.", output[0].Version, output[1].Version)
+			}
+
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "newcomer"}, FieldConf[string]{}}})
+			if _, err := rmc.Upsert(rec, []string{"who"}); err == nil {
+				t.Error("Upsert on a key which is not unique should fail.")
+			}
+		}
+	})
+
+	t.Run("updateWhere", func(t *testing.T) {
+		rmc := prepareTable(true, false, false)
+		if err := testFilling(rmc, 3, false); err != nil {
+			t.Error(err)
+		}
+		rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "row0_str110"}, FieldConf[string]{}}})
+
+		fa := FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "who", Value: "row0_str110", MatchType: FullmatchIndexConf[string]{}},
+		}
+		read, _ := filterCollect(rmc, fa)
+
+		n, err := rmc.UpdateWhere(fa, PatchConf{Cols: map[string]FielderConf{"whom": FieldConf[string]{Value: "patched"}}})
+		if err != nil || n != 2 {
+			t.Errorf("Expected 2 updated rows, got %d.", n)
+		}
+
+		output, _ := filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "whom", Value: "patched", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 2 || output[0].Id != 1 || output[1].Id != 4 ||
+			output[0].Cols[0].(FieldConf[string]).Value != "row0_str110" {
+			t.Error("Wrong rows after the update.")
+		}
+
+		// stale versions
+		versions := map[CId]uint64{read[0].Id: read[0].Version, read[1].Id: read[1].Version}
+		if _, err := rmc.UpdateWhere(fa, PatchConf{Cols: map[string]FielderConf{"whom": FieldConf[string]{}}, Versions: versions}); err == nil {
+			t.Error("Update with stale versions should fail.")
+		}
+		read[0].Cols[1] = FieldConf[string]{Value: "stale"}
+		if err := rmc.EditRecord(read[0]); err == nil {
+			t.Error("Edit with a stale version should fail.")
+		}
+
+		versions = map[CId]uint64{output[0].Id: output[0].Version, output[1].Id: output[1].Version}
+		if n, err := rmc.UpdateWhere(fa, PatchConf{Cols: map[string]FielderConf{"whom": FieldConf[string]{Value: "again"}}, Versions: versions}); err != nil || n != 2 {
+			t.Error("Update with current versions should pass.")
+		}
+
+		if _, err := rmc.UpdateWhere(fa, PatchConf{Cols: map[string]FielderConf{"whom": FieldConf[int]{}}}); err == nil {
+			t.Error("Patch of a wrong type should fail.")
+		}
+	})
+
+	t.Run("patchRecord", func(t *testing.T) {
+		rmc := prepareTable(true, false, false)
+		if err := testFilling(rmc, 3, false); err != nil {
+			t.Error(err)
+		}
+
+		if err := rmc.PatchRecord(2, PatchConf{Cols: map[string]FielderConf{"who": FieldConf[string]{Value: "patched"}}}); err != nil {
+			t.Error(err)
+		}
+
+		output, _ := filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "who", Value: "patched", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 1 || output[0].Id != 2 || output[0].Cols[1].(FieldConf[string]).Value != "row1_str368" {
+			t.Error("Wrong row after the patch.")
+		}
+
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "who", Value: "row1_str121", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 0 {
+			t.Error("Old value should be removed from the index.")
+		}
+
+		stale := PatchConf{Cols: map[string]FielderConf{"whom": FieldConf[string]{}}, Versions: map[CId]uint64{2: 1}}
+		if err := rmc.PatchRecord(2, stale); err == nil {
+			t.Error("Patch with a stale version should fail.")
+		}
+
+		if err := rmc.PatchRecord(42, PatchConf{}); err == nil {
+			t.Error("Patch of a missing record should fail.")
+		}
+		if err := rmc.PatchRecord(2, PatchConf{Cols: map[string]FielderConf{"nope": FieldConf[string]{}}}); err == nil {
+			t.Error("Patch of a missing column should fail.")
+		}
+	})
+
+	t.Run("bitmap", func(t *testing.T) {
+		ids := make([]CId, 0)
+		for i := CId(1); i <= 10000; i += 2 {
+			ids = append(ids, i) // dense container
+		}
+		ids = append(ids, 1<<20, 1<<20+5, 1<<40) // sparse containers
+
+		odd := CIdBitmapFromSlice(ids)
+		if odd.Len() != len(ids) || !odd.Contains(9999) || odd.Contains(10000) || !odd.Contains(1<<40) {
+			t.Error("Wrong content of the bitmap.")
+		}
+		if !slices.Equal(odd.ToSlice(), ids) {
+			t.Error("Bitmap should be converted to a sorted slice.")
+		}
+
+		low := CIdBitmapFromSlice([]CId{1, 2, 3, 4, 5, 1 << 20})
+		if got := odd.Intersect(low).ToSlice(); !slices.Equal(got, []CId{1, 3, 5, 1 << 20}) {
+			t.Errorf("Wrong intersection %v.", got)
+		}
+		if got := odd.Merge(low).Len(); got != len(ids)+2 {
+			t.Errorf("Wrong union size %d.", got)
+		}
+		if got := low.Subtract(odd).ToSlice(); !slices.Equal(got, []CId{2, 4}) {
+			t.Errorf("Wrong difference %v.", got)
+		}
+
+		for _, cid := range ids[:4990] {
+			odd.Remove(cid)
+		}
+		if got := odd.ToSlice(); !slices.Equal(got, ids[4990:]) {
+			t.Errorf("Wrong content after removal %v.", got)
+		}
+		if set := low.ToSet(); len(set) != 6 || !set[1<<20] || !set.ToBitmap().Contains(5) {
+			t.Error("Wrong conversion between the bitmap and the set.")
+		}
+	})
+
+	t.Run("bitmapIndex", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Flags",
+				FieldsNaming: []string{"name", "flags", "kind"},
+				Fields:       []FielderConf{FieldConf[string]{}, FieldConf[uint8]{}, FieldConf[string]{}},
+				Indexes: [][]IndexerConf{{
+					BitmapIndexConf[uint8]{Name: "flags"},
+					BitmapIndexConf[string]{Name: "kind"},
+				}},
+			},
+		})
+
+		for i := 0; i < 100; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: fmt.Sprintf("file%d", i)},
+				FieldConf[uint8]{Value: uint8(i % 4)},
+				FieldConf[string]{Value: []string{"dir", "file"}[i%2]},
+			}})
+		}
+
+		flags := func(v uint8) QueryAtomConf {
+			return QueryAtomConf{Name: "flags", Value: v, MatchType: BitmapIndexConf[uint8]{}}
+		}
+		kind := func(v string) QueryAtomConf {
+			return QueryAtomConf{Name: "kind", Value: v, MatchType: FullmatchIndexConf[string]{}}
+		}
+
+		output, _ := filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAndConf{QueryContextConf{Context: []QueryConf{QueryOrConf{QueryContextConf{Context: []QueryConf{flags(1), flags(2)}}}, kind("file")}}},
+		})
+		if len(output) != 25 || output[0].Id != 2 {
+			t.Errorf("Wrong result of the bitmap query, got %d rows.", len(output))
+		}
+
+		// mixed with an unindexed column
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit: NO_LIMIT,
+			QueryConf: QueryAndConf{QueryContextConf{Context: []QueryConf{
+				flags(3),
+				QueryAtomConf{Name: "name", Value: "file7", MatchType: FullmatchIndexConf[string]{}},
+			}}},
+		})
+		if len(output) != 1 || output[0].Id != 8 {
+			t.Error("Wrong result of the mixed query.")
+		}
+
+		if err := rmc.PatchRecord(8, PatchConf{Cols: map[string]FielderConf{"flags": FieldConf[uint8]{Value: 0}}}); err != nil {
+			t.Error(err)
+		}
+		rmc.DeleteRecord(RecordConf{Id: 4})
+		output, _ = filterCollect(rmc, FilterArgument{Limit: NO_LIMIT, QueryConf: flags(3)})
+		if len(output) != 23 {
+			t.Errorf("Index should follow the changes, got %d rows.", len(output))
+		}
+
+		// bitmap query on a column without the bitmap index
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "name", Value: "file9", MatchType: BitmapIndexConf[string]{}},
+		})
+		if len(output) != 1 || output[0].Id != 10 {
+			t.Error("Bitmap query should fall back to the primary index.")
+		}
+	})
+
+	t.Run("collation", func(t *testing.T) {
+		fold := CollationConf{Normalization: NORM_NFKC, CaseFold: true, StripAccents: true}
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Names",
+				FieldsNaming: []string{"name", "city"},
+				Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}},
+				Indexes: [][]IndexerConf{{
+					FullmatchIndexConf[string]{Name: "name", Collation: fold},
+					PrefixIndexConf[string]{Name: "name", Collation: fold},
+				}},
+			},
+		})
+
+		for _, row := range [][]string{{"Zoë", "Praha"}, {"zoe", "Brno"}, {"ZOE", "Plzeň"}, {"Émile", "Ostrava"}, {"ﬁona", "Praha"}, {"anna", "Brno"}} {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: row[0]}, FieldConf[string]{Value: row[1]}}})
+		}
+
+		output, _ := filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "name", Value: "zoe", MatchType: FullmatchIndexConf[string]{Collation: fold}},
+		})
+		if len(output) != 3 {
+			t.Errorf("Expected 3 collated matches, got %d.", len(output))
+		}
+
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "name", Value: "FI", MatchType: PrefixIndexConf[string]{Collation: fold}},
+		})
+		if len(output) != 1 || output[0].Id != 5 {
+			t.Error("Compatibility normalization should apply to prefixes.")
+		}
+
+		// raw query is not served by the collated index
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "name", Value: "zoe", MatchType: FullmatchIndexConf[string]{}},
+		})
+		if len(output) != 1 || output[0].Id != 2 {
+			t.Error("Raw query should compare bytes.")
+		}
+
+		// unindexed column
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "city", Value: "PLZEN", MatchType: FullmatchIndexConf[string]{Collation: fold}},
+		})
+		if len(output) != 1 || output[0].Id != 3 {
+			t.Error("Collated query should work without an index.")
+		}
+
+		rmc.PatchRecord(2, PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: "Zoey"}}})
+		output, _ = filterCollect(rmc, FilterArgument{
+			Limit:     NO_LIMIT,
+			QueryConf: QueryAtomConf{Name: "name", Value: "ZOË", MatchType: FullmatchIndexConf[string]{Collation: fold}},
+		})
+		if len(output) != 2 {
+			t.Errorf("Collated index should follow the changes, got %d rows.", len(output))
+		}
+
+		output, _ = filterCollect(rmc, FilterArgument{Limit: NO_LIMIT, QueryConf: QueryAndConf{}, Sort: []string{"name"}, Collation: fold})
+		names := make([]string, len(output))
+		for i, rec := range output {
+			names[i] = rec.Cols[0].(FieldConf[string]).Value
+		}
+		if !slices.Equal(names, []string{"anna", "Émile", "ﬁona", "Zoë", "ZOE", "Zoey"}) {
+			t.Errorf("Wrong collated order %v.", names)
+		}
+
+		if NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			FieldsNaming: []string{"n"},
+			Fields:       []FielderConf{FieldConf[int]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[int]{Name: "n", Collation: fold}}},
+		}}) != nil {
+			t.Error("Collation of a non-string column should be refused.")
+		}
+	})
+
+	t.Run("substring", func(t *testing.T) {
+		names := []string{"boot.bin", "kernel.bin", "readme.md", "bin", "a.bin.bak", "data.b1n", "robin.txt"}
+		for _, indexed := range []bool{true, false} {
+			conf := RamCollectionConf{SchemaConf: SchemaConf{
+				Name:         "Names",
+				FieldsNaming: []string{"name"},
+				Fields:       []FielderConf{FieldConf[string]{}},
+			}}
+			if indexed {
+				conf.Indexes = [][]IndexerConf{{NgramIndexConf{Name: "name"}}}
+			}
+			rmc := NewRamCollection(conf)
+			for _, name := range names {
+				rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}}})
+			}
+
+			match := func(mt IndexerConf, pattern string) []CId {
+				output, err := filterCollect(rmc, FilterArgument{
+					Limit:     NO_LIMIT,
+					QueryConf: QueryAtomConf{Name: "name", Value: pattern, MatchType: mt},
+				})
+				if err != nil {
+					t.Error(err)
+				}
+				ids := make([]CId, len(output))
+				for i, rec := range output {
+					ids[i] = rec.Id
+				}
+				return ids
+			}
+
+			if got := match(SuffixMatchConf{}, ".bin"); !slices.Equal(got, []CId{1, 2}) {
+				t.Errorf("Wrong suffix matches %v (indexed %t).", got, indexed)
+			}
+			if got := match(ContainsMatchConf{}, "bin"); !slices.Equal(got, []CId{1, 2, 4, 5, 7}) {
+				t.Errorf("Wrong contains matches %v (indexed %t).", got, indexed)
+			}
+			if got := match(GlobMatchConf{}, "*.b?n"); !slices.Equal(got, []CId{1, 2, 6}) {
+				t.Errorf("Wrong glob matches %v (indexed %t).", got, indexed)
+			}
+			if got := match(GlobMatchConf{}, "*.bin*"); !slices.Equal(got, []CId{1, 2, 5}) {
+				t.Errorf("Wrong glob matches %v (indexed %t).", got, indexed)
+			}
+			if got := match(GlobMatchConf{}, "bin"); !slices.Equal(got, []CId{4}) {
+				t.Errorf("Glob without wildcards should match whole values, got %v (indexed %t).", got, indexed)
+			}
+			if got := match(SuffixMatchConf{}, "n"); len(got) != 4 {
+				t.Errorf("Short suffix should still match, got %v (indexed %t).", got, indexed)
+			}
+
+			rmc.PatchRecord(3, PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: "readme.bin"}}})
+			rmc.DeleteRecord(RecordConf{Id: 1})
+			if got := match(SuffixMatchConf{}, ".bin"); !slices.Equal(got, []CId{2, 3}) {
+				t.Errorf("Matches should follow the changes, got %v (indexed %t).", got, indexed)
+			}
+		}
+	})
+
+	t.Run("regex", func(t *testing.T) {
+		names := []string{"boot.bin", "kernel.bin", "readme.md", "bootstrap.sh", "Boot.BIN", "kernel-6.1.img"}
+		for _, indexed := range []bool{true, false} {
+			conf := RamCollectionConf{SchemaConf: SchemaConf{
+				Name:         "Names",
+				FieldsNaming: []string{"name"},
+				Fields:       []FielderConf{FieldConf[string]{}},
+			}}
+			if indexed {
+				conf.Indexes = [][]IndexerConf{{PrefixIndexConf[string]{Name: "name"}, NgramIndexConf{Name: "name"}}}
+			}
+			rmc := NewRamCollection(conf)
+			for _, name := range names {
+				rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}}})
+			}
+
+			match := func(pattern string) ([]CId, error) {
+				output, err := filterCollect(rmc, FilterArgument{
+					Limit:     NO_LIMIT,
+					QueryConf: QueryAtomConf{Name: "name", Value: pattern, MatchType: RegexMatchConf{}},
+				})
+				ids := make([]CId, len(output))
+				for i, rec := range output {
+					ids[i] = rec.Id
+				}
+				return ids, err
+			}
+
+			for pattern, expected := range map[string][]CId{
+				`^boot`:                {1, 4},
+				`^boot\.bin$`:          {1},
+				`\.bin$`:               {1, 2},
+				`(?i)\.bin$`:           {1, 2, 5},
+				`kernel-\d+\.\d+\.img`: {6},
+				`^(boot|kernel)\.`:     {1, 2},
+				`e.*\.`:                {2, 3, 6},
+				`strap`:                {4},
+			} {
+				got, err := match(pattern)
+				if err != nil {
+					t.Error(err)
+				}
+				if !slices.Equal(got, expected) {
+					t.Errorf("Wrong matches of %s: %v (indexed %t).", pattern, got, indexed)
+				}
+			}
+
+			if _, err := match("(unclosed"); err == nil {
+				t.Error("Invalid expression should fail.")
+			}
+		}
+	})
+
+	t.Run("fuzzy", func(t *testing.T) {
+		names := []string{"Jonathan", "Johnathan", "Jonatan", "Jnoathan", "Nathan", "Jon", ""}
+		for _, indexed := range []bool{true, false} {
+			conf := RamCollectionConf{SchemaConf: SchemaConf{
+				Name:         "Names",
+				FieldsNaming: []string{"name"},
+				Fields:       []FielderConf{FieldConf[string]{}},
+			}}
+			if indexed {
+				conf.Indexes = [][]IndexerConf{{PrefixIndexConf[string]{Name: "name"}}}
+			}
+			rmc := NewRamCollection(conf)
+			for _, name := range names {
+				rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}}})
+			}
+
+			fuzzy := func(value string, fm FuzzyMatchConf) QueryAtomConf {
+				return QueryAtomConf{Name: "name", Value: value, MatchType: fm}
+			}
+			query := func(q QueryConf) []CId {
+				output, err := filterCollect(rmc, FilterArgument{Limit: NO_LIMIT, QueryConf: q})
+				if err != nil {
+					t.Error(err)
+				}
+				ids := make([]CId, len(output))
+				for i, rec := range output {
+					ids[i] = rec.Id
+				}
+				return ids
+			}
+			match := func(value string, fm FuzzyMatchConf) []CId {
+				return query(fuzzy(value, fm))
+			}
+
+			if got := match("Jonathan", FuzzyMatchConf{MaxDistance: 1}); !slices.Equal(got, []CId{1, 2, 3}) {
+				t.Errorf("Wrong Levenshtein matches %v (indexed %t).", got, indexed)
+			}
+			if got := match("Jonathon", FuzzyMatchConf{MaxDistance: 2}); !slices.Equal(got, []CId{1, 2, 3}) {
+				t.Errorf("Matches should be ordered by distance, got %v (indexed %t).", got, indexed)
+			}
+			if got := match("Jonatan", FuzzyMatchConf{MaxDistance: 2}); !slices.Equal(got, []CId{3, 1, 2}) {
+				t.Errorf("Matches should be ordered by distance, got %v (indexed %t).", got, indexed)
+			}
+			if got := match("Jonathan", FuzzyMatchConf{MaxDistance: 1, Transpositions: true}); !slices.Equal(got, []CId{1, 2, 3, 4}) {
+				t.Errorf("Transposition should be a single edit, got %v (indexed %t).", got, indexed)
+			}
+			if got := match("Jo", FuzzyMatchConf{MaxDistance: 2}); !slices.Equal(got, []CId{6, 7}) {
+				t.Errorf("Wrong matches of a short value %v (indexed %t).", got, indexed)
+			}
+
+			// nested fuzzy atoms rank the results by the smallest distance
+			and := QueryAndConf{QueryContextConf{Context: []QueryConf{fuzzy("Jonatan", FuzzyMatchConf{MaxDistance: 2})}}}
+			if got := query(and); !slices.Equal(got, []CId{3, 1, 2}) {
+				t.Errorf("Matches of a conjunction should be ordered by distance, got %v (indexed %t).", got, indexed)
+			}
+			or := QueryOrConf{QueryContextConf{Context: []QueryConf{fuzzy("Jon", FuzzyMatchConf{}), fuzzy("Jonatan", FuzzyMatchConf{MaxDistance: 1})}}}
+			if got := query(or); !slices.Equal(got, []CId{3, 6, 1}) {
+				t.Errorf("Matches of a disjunction should be ordered by the smallest distance, got %v (indexed %t).", got, indexed)
+			}
+		}
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		rmc := prepareTable(false, false, false)
+		for i := 0; i < 20; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("name%02d", 19-i)}, FieldConf[string]{}}})
+		}
+
+		fa := FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"who"}, Limit: 6}
+		seen := make([]string, 0)
+		for pages := 0; ; pages++ {
+			page, err := rmc.FilterPage(fa)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range page.Records {
+				seen = append(seen, rec.Cols[0].(FieldConf[string]).Value)
+			}
+			if pages == 0 {
+				// rows added before the cursor do not shift the next pages
+				rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "name00"}, FieldConf[string]{}}})
+				rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "aaa"}, FieldConf[string]{}}})
+			}
+			if page.Next == "" {
+				if pages != 3 {
+					t.Errorf("Expected 4 pages, got %d.", pages+1)
+				}
+				break
+			}
+			fa.After = page.Next
+		}
+		if len(seen) != 20 || !slices.IsSorted(seen) || seen[19] != "name19" {
+			t.Errorf("Wrong paging %v.", seen)
+		}
+
+		// descending order by CId
+		page, _ := rmc.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, SortOrder: DESC, Limit: 2})
+		page, _ = rmc.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, SortOrder: DESC, Limit: 2, After: page.Next})
+		if len(page.Records) != 2 || page.Records[0].Id != 20 {
+			t.Error("Wrong page in the descending order.")
+		}
+
+		// the cursor works with the plain filter too
+		output, _ := filterCollect(rmc, FilterArgument{QueryConf: QueryAndConf{}, SortOrder: DESC, Limit: NO_LIMIT, After: page.Next})
+		if len(output) != 18 || output[0].Id != 18 {
+			t.Error("Filter should continue after the cursor.")
+		}
+
+		if _, err := rmc.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"whom"}, Limit: 2, After: page.Next}); err == nil {
+			t.Error("Cursor of a different order should be refused.")
+		}
+		if _, err := rmc.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, Limit: 2, After: "garbage"}); err == nil {
+			t.Error("Invalid cursor should be refused.")
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "StatsTable",
+				FieldsNaming: []string{"name", "age"},
+				Fields: []FielderConf{
+					FieldConf[string]{},
+					FieldConf[int]{},
+				},
+				Indexes: [][]IndexerConf{{
+					FullmatchIndexConf[string]{Name: "name"},
+					BitmapIndexConf[int]{Name: "age"},
+				}},
+			},
+			MaxMemory: 1024 * 1024 * 1024,
+		})
+
+		stats, err := rmc.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rows != 0 || stats.Columns[0].Distinct != 0 || stats.Columns[1].Min != nil {
+			t.Error("Empty collection should have empty stats.")
+		}
+
+		for i := 0; i < 1000; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("name%d", i%500)}, FieldConf[int]{Value: i % 100}}})
+		}
+
+		stats, err = rmc.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rows != 1000 {
+			t.Errorf("Expected 1000 rows, got %d.", stats.Rows)
+		}
+		if d := stats.Columns[0].Distinct; d < 450 || d > 550 {
+			t.Errorf("Distinct estimate %d too far from 500.", d)
+		}
+		if d := stats.Columns[1].Distinct; d < 90 || d > 110 {
+			t.Errorf("Distinct estimate %d too far from 100.", d)
+		}
+
+		age := stats.Columns[1]
+		if age.Min.(FieldConf[int]).Value != 0 || age.Max.(FieldConf[int]).Value != 99 {
+			t.Error("Wrong minimum or maximum.")
+		}
+		if len(age.Histogram) != 10 || age.Histogram[0].Count != 100 || age.Histogram[9].Count != 100 {
+			t.Errorf("Wrong histogram %v.", age.Histogram)
+		}
+		if stats.Columns[0].Histogram != nil || stats.Columns[0].Min.(FieldConf[string]).Value != "name0" {
+			t.Error("Wrong stats of the string column.")
+		}
+
+		if len(stats.Indexes) != 2 || stats.Indexes[0].Keys != 500 || stats.Indexes[1].Kind != "bitmap" || stats.Indexes[1].Keys != 100 || stats.Indexes[1].Memory == 0 {
+			t.Errorf("Wrong index stats %v.", stats.Indexes)
+		}
+
+		sketches := func() uintptr {
+			// the sketches are not exported, only their identity is compared
+			return reflect.ValueOf(rmc).Elem().FieldByName("sketches").Pointer()
+		}
+		built := sketches()
+		for i := 1000; i < 1200; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("name%d", i)}, FieldConf[int]{Value: i % 100}}})
+		}
+		stats, _ = rmc.Stats()
+		if sketches() != built {
+			t.Error("Sketches should be maintained by the additions, not rebuilt.")
+		}
+		if d := stats.Columns[0].Distinct; d < 630 || d > 770 {
+			t.Errorf("Distinct estimate %d too far from 700.", d)
+		}
+
+		for cid := CId(1); cid <= 1150; cid++ {
+			rmc.DeleteRecord(RecordConf{Id: cid})
+		}
+		stats, _ = rmc.Stats()
+		if sketches() == built {
+			t.Error("Sketches should be rebuilt after removing most of the records.")
+		}
+		if d := stats.Columns[0].Distinct; stats.Rows != 50 || d < 45 || d > 55 {
+			t.Errorf("Distinct estimate %d of %d rows too far from 50.", d, stats.Rows)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "CountTable",
+				FieldsNaming: []string{"name", "age"},
+				Fields: []FielderConf{
+					FieldConf[string]{},
+					FieldConf[int]{},
+				},
+				Indexes: [][]IndexerConf{{BitmapIndexConf[int]{Name: "age"}}},
+			},
+		})
+		for i := 0; i < 30; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("name%d", i%3)}, FieldConf[int]{Value: i % 5}}})
+		}
+
+		if n, err := rmc.Count(QueryAndConf{}); err != nil || n != 30 {
+			t.Errorf("Expected 30 records, got %d.", n)
+		}
+		if n, _ := rmc.Count(QueryAtomConf{Name: "age", Value: 2, MatchType: BitmapIndexConf[int]{}}); n != 6 {
+			t.Errorf("Expected 6 records from the bitmap, got %d.", n)
+		}
+		if n, _ := rmc.Count(QueryAndConf{QueryContextConf{Context: []QueryConf{
+			QueryAtomConf{Name: "age", Value: 2, MatchType: BitmapIndexConf[int]{}},
+			QueryAtomConf{Name: "name", Value: "name0", MatchType: FullmatchIndexConf[string]{}},
+		}}}); n != 2 {
+			t.Errorf("Expected 2 records, got %d.", n)
+		}
+		if ok, err := rmc.Exists(QueryAtomConf{Name: "name", Value: "name2", MatchType: FullmatchIndexConf[string]{}}); err != nil || !ok {
+			t.Error("Record should exist.")
+		}
+		if ok, _ := rmc.Exists(QueryAtomConf{Name: "age", Value: 7, MatchType: BitmapIndexConf[int]{}}); ok {
+			t.Error("Record should not exist.")
+		}
+		if _, err := rmc.Count(QueryAtomConf{Name: "height", Value: 7, MatchType: FullmatchIndexConf[int]{}}); err == nil {
+			t.Error("Unknown column should fail.")
+		}
+
+		expiring := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Sessions",
+				FieldsNaming: []string{"expires"},
+				Fields:       []FielderConf{FieldConf[time.Time]{}},
+				ExpiryColumn: "expires",
+			},
+		})
+		expiring.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[time.Time]{Value: time.Now().Add(-time.Second)}}})
+		if n, _ := expiring.Count(QueryAndConf{}); n != 0 {
+			t.Error("Expired record should not be counted.")
+		}
+		if ok, _ := expiring.Exists(QueryAndConf{}); ok {
+			t.Error("Expired record should not exist.")
+		}
+	})
+
+	t.Run("sharded", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "People",
+			FieldsNaming: []string{"name", "age"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "name"}}},
+		}
+		shards := func(n int) []Collection {
+			out := make([]Collection, n)
+			for i := range out {
+				out[i] = NewRamCollection(RamCollectionConf{SchemaConf: schema})
+			}
+			return out
+		}
+
+		single := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		hashed := NewShardedCollection(ShardedCollectionConf{ShardKey: "name"}, shards(3)...)
+		rangeShards := shards(3)
+		ranged := NewShardedCollection(ShardedCollectionConf{
+			ShardKey: "age",
+			Sharding: RANGE_SHARDING,
+			Bounds:   []FielderConf{FieldConf[int]{Value: 30}, FieldConf[int]{Value: 60}},
+		}, rangeShards...)
+		if hashed == nil || ranged == nil {
+			t.Fatal("Should return valid instances of ShardedCollection.")
+		}
+
+		for i := 0; i < 90; i++ {
+			rec := RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("name%02d", i*7%90)}, FieldConf[int]{Value: i}}}
+			for _, c := range []Collection{single, hashed, ranged} {
+				if _, err := c.AddRecord(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, shard := range rangeShards {
+			if n, _ := shard.Count(QueryAndConf{}); n != 30 {
+				t.Errorf("Expected 30 records in the range shard, got %d.", n)
+			}
+		}
+
+		names := func(recs []RecordConf) []string {
+			out := make([]string, len(recs))
+			for i, rec := range recs {
+				out[i] = rec.Cols[0].(FieldConf[string]).Value
+			}
+			return out
+		}
+
+		ids := make(map[CId]bool)
+		for _, fa := range []FilterArgument{
+			{QueryConf: QueryAndConf{}, Sort: []string{"name"}, Skip: 5, Limit: 10},
+			{QueryConf: QueryAndConf{}, Sort: []string{"age"}, SortOrder: DESC, Limit: NO_LIMIT},
+			{QueryConf: QueryAndConf{}, SortOrder: DESC, Skip: 3, Limit: 7},
+			{QueryConf: QueryAtomConf{Name: "name", Value: "name1", MatchType: PrefixIndexConf[string]{}}, Limit: NO_LIMIT},
+		} {
+			expected, _ := filterCollect(single, fa)
+			for _, c := range []Collection{hashed, ranged} {
+				output, err := filterCollect(c, fa)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(names(output), names(expected)) {
+					t.Errorf("Merged results %v differ from %v.", names(output), names(expected))
+				}
+				for _, rec := range output {
+					ids[rec.Id] = true
+				}
+			}
+		}
+		all, _ := filterCollect(hashed, FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT})
+		for _, rec := range all {
+			ids[rec.Id] = true
+		}
+		if len(all) != 90 {
+			t.Errorf("Expected 90 records, got %d.", len(all))
+		}
+
+		seen := make([]string, 0)
+		fa := FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"name"}, Limit: 7}
+		for {
+			page, err := ranged.FilterPage(fa)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, names(page.Records)...)
+			if page.Next == "" {
+				break
+			}
+			fa.After = page.Next
+		}
+		if len(seen) != 90 || !slices.IsSorted(seen) {
+			t.Errorf("Wrong paging %v.", seen)
+		}
+
+		young, _ := filterCollect(ranged, FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"age"}, Limit: 1})
+		rec := young[0]
+		rec.Cols = []FielderConf{rec.Cols[0], FieldConf[int]{Value: 80}}
+		if err := ranged.EditRecord(rec); err == nil {
+			t.Error("Shard key should not be changed.")
+		}
+		rec.Cols = []FielderConf{FieldConf[string]{Value: "youngest"}, FieldConf[int]{Value: 0}}
+		if err := ranged.EditRecord(rec); err != nil {
+			t.Error(err)
+		}
+		if _, err := ranged.AddRecord(RecordConf{Id: rec.Id, Cols: rec.Cols}); err == nil {
+			t.Error("Id should not be reused.")
+		}
+		if _, err := ranged.AddRecord(RecordConf{Id: rec.Id + 1, Cols: rec.Cols}); err == nil {
+			t.Error("Id of another shard should be refused.")
+		}
+
+		cid, err := hashed.Upsert(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "name05"}, FieldConf[int]{Value: 100}}}, []string{"name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := hashed.Count(QueryAtomConf{Name: "age", Value: 100, MatchType: FullmatchIndexConf[int]{}}); n != 1 || !ids[cid] {
+			t.Error("Upsert should edit the existing record.")
+		}
+		if _, err := ranged.Upsert(rec, []string{"name"}); err == nil {
+			t.Error("Upsert keys have to include the shard key.")
+		}
+
+		fresh := RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "newcomer"}, FieldConf[int]{Value: 1}}}
+		var wg sync.WaitGroup
+		upserted := make([]CId, 8)
+		for i := range upserted {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				upserted[i], _ = hashed.Upsert(fresh, []string{"name"})
+			}(i)
+		}
+		wg.Wait()
+		for _, cid := range upserted {
+			if cid != upserted[0] {
+				t.Errorf("Concurrent upserts of one key should agree on the record, got %v.", upserted)
+				break
+			}
+		}
+		if recs, _ := hashed.Get(upserted[0]); len(recs) != 1 || recs[0].Cols[0].(FieldConf[string]).Value != "newcomer" {
+			t.Error("Upserted record should be found by its CId.")
+		}
+		if n, _ := hashed.Count(QueryAtomConf{Name: "name", Value: "newcomer", MatchType: FullmatchIndexConf[string]{}}); n != 1 {
+			t.Errorf("Expected a single upserted record, got %d.", n)
+		}
+		hashed.DeleteRecord(RecordConf{Id: upserted[0]})
+
+		n, err := ranged.UpdateWhere(FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"age"}, SortOrder: DESC, Limit: 5}, PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: "old"}}})
+		if err != nil || n != 5 {
+			t.Errorf("Expected 5 updated records, got %d.", n)
+		}
+		old, _ := filterCollect(ranged, FilterArgument{QueryConf: QueryAtomConf{Name: "name", Value: "old", MatchType: FullmatchIndexConf[string]{}}, Sort: []string{"age"}, Limit: NO_LIMIT})
+		if len(old) != 5 || old[0].Cols[1].(FieldConf[int]).Value != 85 {
+			t.Error("Wrong records updated.")
+		}
+
+		// the shards updated before a failure stay updated and are counted
+		vetoShards := shards(3)
+		vetoed := NewShardedCollection(ShardedCollectionConf{
+			ShardKey: "age",
+			Sharding: RANGE_SHARDING,
+			Bounds:   []FielderConf{FieldConf[int]{Value: 30}, FieldConf[int]{Value: 60}},
+		}, vetoShards...)
+		for age := 0; age < 90; age += 10 {
+			vetoed.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "young"}, FieldConf[int]{Value: age}}})
+		}
+		vetoShards[1].AddHook(BEFORE_EDIT, func(m *MutationConf) error { return fmt.Errorf("vetoed") })
+		rename := func(name string) PatchConf {
+			return PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: name}}}
+		}
+		if n, err := vetoed.UpdateWhere(FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT}, rename("old")); err == nil || n != 6 {
+			t.Errorf("Expected 6 updated records and the veto, got %d (%v).", n, err)
+		}
+		if n, err := vetoed.UpdateWhere(FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"age"}, Limit: 9}, rename("older")); err == nil || n != 3 {
+			t.Errorf("Expected 3 updated records and the veto, got %d (%v).", n, err)
+		}
+
+		if err := ranged.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"age"}, Limit: 10}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := ranged.Count(QueryAndConf{}); n != 80 {
+			t.Errorf("Expected 80 records, got %d.", n)
+		}
+		if ok, _ := ranged.Exists(QueryAtomConf{Name: "name", Value: "youngest", MatchType: FullmatchIndexConf[string]{}}); ok {
+			t.Error("Record should be deleted.")
+		}
+
+		stats, err := ranged.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rows != 80 || stats.Columns[1].Min.(FieldConf[int]).Value != 10 || stats.Columns[1].Max.(FieldConf[int]).Value != 89 {
+			t.Error("Wrong combined stats.")
+		}
+
+		// CIds continue after the existing records
+		reopened := NewShardedCollection(ShardedCollectionConf{
+			ShardKey: "age",
+			Sharding: RANGE_SHARDING,
+			Bounds:   []FielderConf{FieldConf[int]{Value: 30}, FieldConf[int]{Value: 60}},
+		}, rangeShards...)
+		if cid, err := reopened.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{}, FieldConf[int]{Value: 1}}}); err != nil || cid <= 270 {
+			t.Errorf("CId %d should follow the existing ones.", cid)
+		}
+
+		if NewShardedCollection(ShardedCollectionConf{ShardKey: "age", Sharding: RANGE_SHARDING}, shards(2)...) != nil {
+			t.Error("Bounds have to match the shards.")
+		}
+		if NewShardedCollection(ShardedCollectionConf{ShardKey: "height"}, shards(2)...) != nil {
+			t.Error("Shard key has to exist.")
+		}
+	})
+
+	t.Run("replication", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "Replicated",
+			FieldsNaming: []string{"name", "age"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "name"}}},
+		}
+		person := func(name string, age int) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}, FieldConf[int]{Value: age}}}
+		}
+
+		for _, network := range []string{"tcp", "unix"} {
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				address = filepath.Join(t.TempDir(), "primary.sock")
+			}
+
+			rmc := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+			for i := 0; i < 10; i++ {
+				rmc.AddRecord(person(fmt.Sprintf("name%d", i), i))
+			}
+
+			primary := NewPrimary(PrimaryConf{Network: network, Address: address, LogSize: 5}, rmc)
+			if primary == nil {
+				t.Fatal("Should return valid instance of Primary.")
+			}
+			if NewPrimary(PrimaryConf{Network: network, Address: address}, rmc) != nil {
+				t.Error("Collection should have a single primary.")
+			}
+
+			conf := ReplicaConf{RamCollectionConf: RamCollectionConf{SchemaConf: schema}, Network: network, Address: primary.Addr(), RetryInterval: 10 * time.Millisecond}
+			replicas := []*Replica{NewReplica(conf), NewReplica(conf)}
+
+			inSync := func() bool {
+				for _, r := range replicas {
+					if s := r.Status(); !s.Connected || s.Applied != primary.Head() || s.Lag != 0 {
+						return false
+					}
+				}
+				return true
+			}
+			sameRecords := func() {
+				expected, _ := filterCollect(rmc, FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT})
+				for _, r := range replicas {
+					output, err := filterCollect(r, FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(output, expected) {
+						t.Errorf("Replica over %s differs from the primary.", network)
+					}
+				}
+			}
+
+			eventually(t, inSync)
+			sameRecords()
+
+			rmc.AddRecord(person("new", 50))
+			rmc.EditRecord(RecordConf{Id: 1, Cols: []FielderConf{FieldConf[string]{Value: "edited"}, FieldConf[int]{Value: 1}}})
+			rmc.PatchRecord(2, PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: 20}}})
+			rmc.DeleteRecord(RecordConf{Id: 3})
+			eventually(t, inSync)
+			sameRecords()
+
+			if n, _ := replicas[0].Count(QueryAtomConf{Name: "name", Value: "edited", MatchType: FullmatchIndexConf[string]{}}); n != 1 {
+				t.Error("Replica should serve indexed queries.")
+			}
+			if _, err := replicas[0].AddRecord(person("refused", 1)); err == nil {
+				t.Error("Replica should be read-only.")
+			}
+
+			eventually(t, func() bool {
+				status := primary.Replicas()
+				return len(status) == 2 && status[0].Lag == 0 && status[1].Lag == 0
+			})
+
+			// replicas catch up from a snapshot when the new primary starts another log
+			address = primary.Addr()
+			primary.Close()
+			for i := 0; i < 20; i++ {
+				rmc.PatchRecord(4, PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: i}}})
+			}
+			rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAtomConf{Name: "name", Value: "name5", MatchType: FullmatchIndexConf[string]{}}, Limit: NO_LIMIT})
+			if replicas[0].Status().Applied == 0 {
+				t.Error("Replica should keep the applied mutations.")
+			}
+
+			primary = NewPrimary(PrimaryConf{Network: network, Address: address, LogSize: 5}, rmc)
+			if primary == nil {
+				t.Fatal("Primary should listen again.")
+			}
+			eventually(t, inSync)
+			sameRecords()
+
+			// more mutations than retained by the log are sent at once
+			for i := 0; i < 30; i++ {
+				rmc.AddRecord(person(fmt.Sprintf("bulk%d", i), i))
+			}
+			eventually(t, inSync)
+			sameRecords()
+
+			for _, r := range replicas {
+				r.Close()
+			}
+			primary.Close()
+			rmc.AddRecord(person("after", 1))
+		}
+
+		if NewPrimary(PrimaryConf{Network: "tcp", Address: "invalid address"}, NewRamCollection(RamCollectionConf{SchemaConf: schema})) != nil {
+			t.Error("Invalid address should be refused.")
+		}
+	})
+
+	t.Run("id", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "Items",
+			FieldsNaming: []string{"name", "kind"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}},
+			Indexes:      [][]IndexerConf{{BitmapIndexConf[int]{Name: "kind"}}},
+		}
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: schema, Versioned: true})
+		for i := 0; i < 100; i++ {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("item%d", i)}, FieldConf[int]{Value: i % 2}}})
+		}
+		for _, cid := range []CId{70000, 70001, 140000} {
+			rmc.AddRecord(RecordConf{Id: cid, Cols: []FielderConf{FieldConf[string]{Value: "far"}, FieldConf[int]{Value: 2}}})
+		}
+		before := time.Now()
+
+		recs, err := rmc.Get(5, 1000, 70001, 3)
+		if err != nil || len(recs) != 3 || recs[0].Id != 5 || recs[1].Id != 70001 || recs[2].Id != 3 {
+			t.Fatalf("Wrong records %v.", recs)
+		}
+		if recs[0].Cols[0].(FieldConf[string]).Value != "item4" || recs[0].Version != 1 {
+			t.Errorf("Wrong record %v.", recs[0])
+		}
+
+		id := func(v any) QueryAtomConf {
+			return QueryAtomConf{Value: v, MatchType: IdMatchConf{}}
+		}
+		count := func(q QueryConf, expected int) {
+			t.Helper()
+			if n, err := rmc.Count(q); err != nil || n != expected {
+				t.Errorf("Expected %d records, got %d (%v).", expected, n, err)
+			}
+		}
+
+		count(id(CId(7)), 1)
+		count(id(CId(500)), 0)
+		count(id([]CId{1, 2, 2, 70000, 99999}), 3)
+		count(id(QueryRange[CId]{Lower: 90, Higher: 70000}), 12)
+		count(id(QueryRange[CId]{Lower: 70001}), 2)
+		count(QueryAndConf{QueryContextConf{Context: []QueryConf{
+			id(QueryRange[CId]{Lower: 1, Higher: 10}),
+			QueryAtomConf{Name: "kind", Value: 1, MatchType: BitmapIndexConf[int]{}},
+		}}}, 5)
+		count(QueryOrConf{QueryContextConf{Context: []QueryConf{
+			id(CId(1)),
+			QueryAtomConf{Name: "name", Value: "far", MatchType: FullmatchIndexConf[string]{}},
+		}}}, 4)
+		if _, err := rmc.Count(id("1")); err == nil {
+			t.Error("Invalid id value should fail.")
+		}
+
+		out, err := filterCollect(rmc, FilterArgument{QueryConf: id(QueryRange[CId]{Lower: 98}), Sort: []string{"name"}, Limit: NO_LIMIT})
+		if err != nil || len(out) != 6 || out[0].Id != 70000 || out[5].Id != 100 {
+			t.Errorf("Wrong filtered records %v.", out)
+		}
+
+		rmc.DeleteRecord(RecordConf{Id: 70000})
+		count(id(QueryRange[CId]{Lower: 101}), 2)
+		if recs, _ := rmc.Get(70000); len(recs) != 0 {
+			t.Error("Deleted record should not be found.")
+		}
+
+		out, err = filterCollect(rmc, FilterArgument{QueryConf: id(CId(70000)), AsOf: before, Limit: NO_LIMIT})
+		if err != nil || len(out) != 1 {
+			t.Errorf("Deleted record should be found in the past, got %v.", out)
+		}
+
+		rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}})
+		count(id(QueryRange[CId]{}), 0)
+
+		shards := []Collection{NewRamCollection(RamCollectionConf{SchemaConf: schema}), NewRamCollection(RamCollectionConf{SchemaConf: schema})}
+		sc := NewShardedCollection(ShardedCollectionConf{ShardKey: "name"}, shards...)
+		ids := make([]CId, 10)
+		for i := range ids {
+			ids[i], _ = sc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: fmt.Sprintf("item%d", i)}, FieldConf[int]{Value: i}}})
+		}
+		recs, err = sc.Get(ids[7], 0, ids[2], ids[7])
+		if err != nil || len(recs) != 3 || recs[0].Id != ids[7] || recs[1].Id != ids[2] || recs[2].Id != ids[7] {
+			t.Errorf("Wrong sharded records %v.", recs)
+		}
+		if n, _ := sc.Count(id(ids[:4])); n != 4 {
+			t.Errorf("Expected 4 sharded records, got %d.", n)
+		}
+	})
+
+	t.Run("computed", func(t *testing.T) {
+		RegisterFunction("ext", func(args ...any) (any, error) {
+			name := args[0].(string)
+			if i := strings.LastIndex(name, "."); i != -1 {
+				return name[i+1:], nil
+			}
+			return "", nil
+		})
+		RegisterFunction("broken", func(args ...any) (any, error) {
+			return 1, nil
+		})
+
+		schema := SchemaConf{
+			Name:         "Files",
+			FieldsNaming: []string{"path", "modified"},
+			Fields:       []FielderConf{FieldConf[[]string]{}, FieldConf[time.Time]{}},
+			Indexes: [][]IndexerConf{{
+				FullmatchIndexConf[string]{Name: "file", Collation: CollationConf{CaseFold: true}},
+				BitmapIndexConf[int]{Name: "year"},
+				FullmatchIndexConf[string]{Name: "ext"},
+			}},
+			Computed: []ComputedConf{
+				{Name: "file", Field: FieldConf[string]{}, Function: "last", Arguments: []string{"path"}},
+				{Name: "year", Field: FieldConf[int]{}, Function: "year", Arguments: []string{"modified"}},
+				{Name: "ext", Field: FieldConf[string]{}, Function: "ext", Arguments: []string{"file"}},
+			},
+		}
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		if rmc == nil {
+			t.Fatal("Collection with computed columns should be created.")
+		}
+		if names := rmc.Schema().FieldsNaming; !slices.Equal(names, []string{"path", "modified", "file", "year", "ext"}) {
+			t.Errorf("Wrong columns %v.", names)
+		}
+
+		file := func(path []string, year int) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[[]string]{Value: path}, FieldConf[time.Time]{Value: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)}}}
+		}
+		byFile := func(name string) QueryAtomConf {
+			return QueryAtomConf{Name: "file", Value: name, MatchType: FullmatchIndexConf[string]{Collation: CollationConf{CaseFold: true}}}
+		}
+		byYear := func(year int) QueryAtomConf {
+			return QueryAtomConf{Name: "year", Value: year, MatchType: BitmapIndexConf[int]{}}
+		}
+
+		a, _ := rmc.AddRecord(file([]string{"home", "Notes.txt"}, 2020))
+		rmc.AddRecord(file([]string{"tmp", "image.png"}, 2021))
+		rmc.BulkLoad([]RecordConf{file([]string{"var", "log.txt"}, 2020), file([]string{}, 2022)})
+
+		recs, err := filterCollect(rmc, FilterArgument{QueryConf: byFile("notes.TXT"), Limit: NO_LIMIT})
+		if err != nil || len(recs) != 1 || len(recs[0].Cols) != 5 {
+			t.Fatalf("Wrong records %v.", recs)
+		}
+		if recs[0].Cols[2].(FieldConf[string]).Value != "Notes.txt" || recs[0].Cols[3].(FieldConf[int]).Value != 2020 || recs[0].Cols[4].(FieldConf[string]).Value != "txt" {
+			t.Errorf("Wrong computed values %v.", recs[0])
+		}
+		if n, _ := rmc.Count(byYear(2020)); n != 2 {
+			t.Errorf("Expected 2 records of 2020, got %d.", n)
+		}
+		if n, _ := rmc.Count(QueryAtomConf{Name: "file", Value: "", MatchType: FullmatchIndexConf[string]{Collation: CollationConf{CaseFold: true}}}); n != 1 {
+			t.Errorf("Expected 1 record with an empty path, got %d.", n)
+		}
+
+		// values given for the computed columns are replaced
+		edited := file([]string{"home", "todo.md"}, 2023)
+		edited.Id = a
+		edited.Cols = append(edited.Cols, FieldConf[string]{Value: "wrong"}, FieldConf[int]{Value: 1}, FieldConf[string]{Value: "wrong"})
+		if err := rmc.EditRecord(edited); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := rmc.Count(byFile("notes.txt")); n != 0 {
+			t.Error("Old computed value should be removed from the index.")
+		}
+		if n, _ := rmc.Count(QueryAndConf{QueryContextConf{Context: []QueryConf{byFile("todo.md"), byYear(2023)}}}); n != 1 {
+			t.Error("New computed values should be indexed.")
+		}
+
+		if err := rmc.PatchRecord(a, PatchConf{Cols: map[string]FielderConf{"path": FieldConf[[]string]{Value: []string{"readme"}}}}); err != nil {
+			t.Fatal(err)
+		}
+		if recs, _ := rmc.Get(a); recs[0].Cols[2].(FieldConf[string]).Value != "readme" || recs[0].Cols[4].(FieldConf[string]).Value != "" {
+			t.Errorf("Patched record not recomputed %v.", recs[0])
+		}
+		if err := rmc.PatchRecord(a, PatchConf{Cols: map[string]FielderConf{"file": FieldConf[string]{Value: "x"}}}); err == nil {
+			t.Error("Patching a computed column should fail.")
+		}
+		if n, _ := rmc.UpdateWhere(FilterArgument{QueryConf: byYear(2020), Limit: NO_LIMIT}, PatchConf{Cols: map[string]FielderConf{"modified": FieldConf[time.Time]{Value: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}}}); n != 1 {
+			t.Errorf("Expected 1 updated record, got %d.", n)
+		}
+		if n, _ := rmc.Count(byYear(2024)); n != 1 {
+			t.Error("Updated records should be recomputed.")
+		}
+
+		if _, err := rmc.Upsert(file([]string{"img", "image.png"}, 2025), []string{"file"}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := rmc.Count(QueryAndConf{}); n != 4 {
+			t.Errorf("Upsert on a computed key should edit, got %d records.", n)
+		}
+
+		if NewRamCollection(RamCollectionConf{SchemaConf: rmc.Schema()}) == nil {
+			t.Error("Collection should be created from the schema of another one.")
+		}
+		for _, cc := range []ComputedConf{
+			{Name: "file", Field: FieldConf[string]{}, Function: "unknown", Arguments: []string{"path"}},
+			{Name: "file", Field: FieldConf[string]{}, Function: "last", Arguments: []string{"name"}},
+			{Name: "path", Field: FieldConf[string]{}, Function: "last", Arguments: []string{"path"}},
+		} {
+			bad := schema
+			bad.Indexes = nil
+			bad.Computed = []ComputedConf{cc}
+			if NewRamCollection(RamCollectionConf{SchemaConf: bad}) != nil {
+				t.Errorf("Collection with computed column %v should not be created.", cc)
+			}
+		}
+
+		broken := schema
+		broken.Indexes = nil
+		broken.Computed = []ComputedConf{{Name: "file", Field: FieldConf[string]{}, Function: "broken", Arguments: []string{"path"}}}
+		if _, err := NewRamCollection(RamCollectionConf{SchemaConf: broken}).AddRecord(file([]string{"a"}, 2020)); err == nil {
+			t.Error("Computed value of a wrong type should fail.")
+		}
+	})
+
+	t.Run("constraints", func(t *testing.T) {
+		dirs := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Dirs",
+			FieldsNaming: []string{"name", "parent"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[uint64]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[uint64]{Name: "parent"}}},
+			Checks:       []CheckConf{{Column: "name", NonEmpty: true, Pattern: "^[a-z]+$"}},
+			References:   []ReferenceConf{{Column: "parent", OnDelete: ON_DELETE_CASCADE}},
+		}})
+		files := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Files",
+			FieldsNaming: []string{"dir", "size"},
+			Fields:       []FielderConf{FieldConf[uint64]{}, FieldConf[int]{}},
+			Checks:       []CheckConf{{Column: "size", Min: FieldConf[int]{Value: 0}, Max: FieldConf[int]{Value: 100}}},
+			References:   []ReferenceConf{{Column: "dir", Collection: "Dirs", OnDelete: ON_DELETE_RESTRICT}},
+		}})
+		tags := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Tags",
+			FieldsNaming: []string{"dirName"},
+			Fields:       []FielderConf{FieldConf[string]{}},
+			References:   []ReferenceConf{{Column: "dirName", Collection: "Dirs", Target: "name", OnDelete: ON_DELETE_SET_NULL}},
+		}})
+		if dirs == nil || files == nil || tags == nil {
+			t.Fatal("Collections with constraints should be created.")
+		}
+
+		dir := func(name string, parent CId) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}, FieldConf[uint64]{Value: uint64(parent)}}}
+		}
+		file := func(dir CId, size int) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[uint64]{Value: uint64(dir)}, FieldConf[int]{Value: size}}}
+		}
+		tag := func(name string) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}}}
+		}
+		count := func(c *RamCollection, expected int) {
+			t.Helper()
+			if n, _ := c.Count(QueryAndConf{}); n != expected {
+				t.Errorf("Expected %d records in %s, got %d.", expected, c.Schema().Name, n)
+			}
+		}
+
+		root, err := dirs.AddRecord(dir("root", 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rc := range []RecordConf{dir("", root), dir("Home", root), dir("home", 42)} {
+			if _, err := dirs.AddRecord(rc); err == nil {
+				t.Errorf("Record %v should violate the constraints.", rc)
+			}
+		}
+		home, _ := dirs.AddRecord(dir("home", root))
+		user, _ := dirs.AddRecord(dir("user", home))
+		tmp, _ := dirs.AddRecord(dir("tmp", root))
+
+		if _, err := files.AddRecord(file(home, 10)); err == nil {
+			t.Error("Unlinked reference should fail.")
+		}
+		if err := files.Link(dirs); err != nil {
+			t.Fatal(err)
+		}
+		if err := files.Link(tags); err == nil {
+			t.Error("Linking an unreferenced collection should fail.")
+		}
+		tags.Link(dirs)
+
+		if _, err := files.AddRecord(file(home, 101)); err == nil {
+			t.Error("Value above the maximum should fail.")
+		}
+		if _, err := files.AddRecord(file(CId(1000), 1)); err == nil {
+			t.Error("Reference to a missing record should fail.")
+		}
+		f, err := files.AddRecord(file(tmp, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files.AddRecord(file(0, 5))
+		if err := files.PatchRecord(f, PatchConf{Cols: map[string]FielderConf{"size": FieldConf[int]{Value: -1}}}); err == nil {
+			t.Error("Patch below the minimum should fail.")
+		}
+		if recs, _ := files.Get(f); recs[0].Cols[1].(FieldConf[int]).Value != 10 || recs[0].Version != 1 {
+			t.Errorf("Failed patch should not modify the record %v.", recs[0])
+		}
+		if _, err := tags.AddRecord(tag("nothing")); err == nil {
+			t.Error("Reference to a missing value should fail.")
+		}
+		tags.AddRecord(tag("user"))
+		tags.AddRecord(tag("tmp"))
+
+		if err := dirs.DeleteRecord(RecordConf{Id: tmp}); err == nil {
+			t.Error("Deletion of a restricted record should fail.")
+		}
+		count(dirs, 4)
+		files.DeleteRecord(RecordConf{Id: f})
+		if err := dirs.DeleteRecord(RecordConf{Id: tmp}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := dirs.DeleteRecord(RecordConf{Id: home}); err != nil {
+			t.Fatal(err)
+		}
+		count(dirs, 1)
+		if recs, _ := dirs.Get(user); len(recs) != 0 {
+			t.Error("Grandchild should be deleted by the cascade.")
+		}
+		if n, _ := tags.Count(QueryAtomConf{Name: "dirName", Value: "", MatchType: FullmatchIndexConf[string]{}}); n != 2 {
+			t.Errorf("References should be set to null, got %d.", n)
+		}
+
+		if _, err := dirs.BulkLoad([]RecordConf{
+			{Id: 100, Cols: dir("a", 101).Cols},
+			{Id: 101, Cols: dir("b", root).Cols},
+		}); err != nil {
+			t.Errorf("Records of a batch should reference each other: %v", err)
+		}
+		if _, err := dirs.BulkLoad([]RecordConf{dir("c", root), dir("d", 500)}); err == nil {
+			t.Error("Batch with a missing reference should fail.")
+		}
+		count(dirs, 3)
+
+		if err := dirs.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT}); err != nil {
+			t.Fatal(err)
+		}
+		count(dirs, 0)
+
+		bad := []SchemaConf{
+			{Name: "Bad", FieldsNaming: []string{"n"}, Fields: []FielderConf{FieldConf[int]{}}, Checks: []CheckConf{{Column: "n", Min: FieldConf[string]{}}}},
+			{Name: "Bad", FieldsNaming: []string{"n"}, Fields: []FielderConf{FieldConf[int]{}}, Checks: []CheckConf{{Column: "n", Pattern: "a"}}},
+			{Name: "Bad", FieldsNaming: []string{"n"}, Fields: []FielderConf{FieldConf[int]{}}, References: []ReferenceConf{{Column: "n"}}},
+			{Name: "Bad", FieldsNaming: []string{"n"}, Fields: []FielderConf{FieldConf[uint64]{}}, References: []ReferenceConf{{Column: "m"}}},
+		}
+		for _, schema := range bad {
+			if NewRamCollection(RamCollectionConf{SchemaConf: schema}) != nil {
+				t.Errorf("Collection %v should not be created.", schema)
+			}
+		}
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "Users",
+			FieldsNaming: []string{"name", "age"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}},
+		}
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		audit := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Audit",
+			FieldsNaming: []string{"event", "id", "old", "new"},
+			Fields:       []FielderConf{FieldConf[int]{}, FieldConf[uint64]{}, FieldConf[string]{}, FieldConf[string]{}},
+		}})
+		name := func(rec RecordConf) string {
+			if rec.Cols == nil {
+				return ""
+			}
+			return rec.Cols[0].(FieldConf[string]).Value
+		}
+		user := func(name string, age int) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}, FieldConf[int]{Value: age}}}
+		}
+
+		for _, event := range []int{AFTER_ADD, AFTER_EDIT, AFTER_DELETE} {
+			rmc.AddHook(event, func(m *MutationConf) error {
+				id := m.New.Id
+				if m.Event == AFTER_DELETE {
+					id = m.Old.Id
+				}
+				_, err := audit.AddRecord(RecordConf{Cols: []FielderConf{
+					FieldConf[int]{Value: m.Event}, FieldConf[uint64]{Value: uint64(id)},
+					FieldConf[string]{Value: name(m.Old)}, FieldConf[string]{Value: name(m.New)},
+				}})
+				return err
+			})
+		}
+		rmc.AddHook(BEFORE_ADD, func(m *MutationConf) error {
+			if name(m.New) == "root" {
+				return errors.NewValueError(rmc, errors.LevelError, "Reserved name.")
+			}
+			m.New.Cols[0] = FieldConf[string]{Value: strings.ToLower(name(m.New))}
+			return nil
+		})
+		rmc.AddHook(BEFORE_EDIT, func(m *MutationConf) error {
+			if m.New.Cols[1].(FieldConf[int]).Value < 0 {
+				return errors.NewValueError(rmc, errors.LevelError, "Negative age.")
+			}
+			m.New.Cols[0] = FieldConf[string]{Value: strings.ToLower(name(m.New))}
+			return nil
+		})
+		rmc.AddHook(BEFORE_DELETE, func(m *MutationConf) error {
+			if m.Old.Cols[1].(FieldConf[int]).Value > 100 {
+				return errors.NewStateError(rmc, errors.LevelError, "Protected record.")
+			}
+			return nil
+		})
+		if _, err := rmc.AddHook(AFTER_DELETE+1, func(m *MutationConf) error { return nil }); err == nil {
+			t.Error("Unknown event should fail.")
+		}
+
+		alice, err := rmc.AddRecord(user("Alice", 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rmc.AddRecord(user("root", 1)); err == nil {
+			t.Error("Vetoed addition should fail.")
+		}
+		ids, _ := rmc.BulkLoad([]RecordConf{user("Bob", 40), user("Carol", 50)})
+		if recs, _ := rmc.Get(alice, ids[0]); len(recs) != 2 || name(recs[0]) != "alice" || name(recs[1]) != "bob" {
+			t.Errorf("Before-hook should modify the added records %v.", recs)
+		}
+
+		if err := rmc.EditRecord(RecordConf{Id: alice, Cols: user("ALICE", -1).Cols}); err == nil {
+			t.Error("Vetoed edit should fail.")
+		}
+		if err := rmc.PatchRecord(alice, PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: "Alicia"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if recs, _ := rmc.Get(alice); name(recs[0]) != "alicia" || recs[0].Version != 2 {
+			t.Errorf("Before-hook should modify the edited record %v.", recs[0])
+		}
+		if n, _ := rmc.UpdateWhere(FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT}, PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: 150}}}); n != 3 {
+			t.Errorf("Expected 3 updated records, got %d.", n)
+		}
+
+		if err := rmc.DeleteRecord(RecordConf{Id: alice}); err == nil {
+			t.Error("Vetoed deletion should fail.")
+		}
+		rmc.UpdateWhere(FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT}, PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: 100}}})
+		if err := rmc.PatchRecord(ids[1], PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: 300}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.DeleteByFilter(FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"age"}, Limit: NO_LIMIT}); err == nil {
+			t.Error("Deletion of all records should be vetoed by the protected one.")
+		}
+		if n, _ := rmc.Count(QueryAndConf{}); n != 1 {
+			t.Errorf("Only the protected record should remain, got %d.", n)
+		}
+
+		events := map[int]int{}
+		recs, _ := filterCollect(audit, FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT})
+		for _, rec := range recs {
+			events[rec.Cols[0].(FieldConf[int]).Value]++
+			if rec.Cols[0].(FieldConf[int]).Value == AFTER_EDIT && rec.Cols[1].(FieldConf[uint64]).Value == uint64(alice) && rec.Cols[2].(FieldConf[string]).Value == "alice" && rec.Cols[3].(FieldConf[string]).Value != "alicia" {
+				t.Errorf("Wrong audit of the edit %v.", rec)
+			}
+		}
+		if events[AFTER_ADD] != 3 || events[AFTER_EDIT] != 8 || events[AFTER_DELETE] != 2 {
+			t.Errorf("Wrong audit events %v.", events)
+		}
+
+		shards := []Collection{NewRamCollection(RamCollectionConf{SchemaConf: schema}), NewRamCollection(RamCollectionConf{SchemaConf: schema})}
+		sc := NewShardedCollection(ShardedCollectionConf{ShardKey: "name"}, shards...)
+		var added atomic.Int32
+		hook, _ := sc.AddHook(AFTER_ADD, func(m *MutationConf) error {
+			added.Add(1)
+			return nil
+		})
+		for i := 0; i < 10; i++ {
+			sc.AddRecord(user(fmt.Sprintf("user%d", i), i))
+		}
+		if added.Load() != 10 {
+			t.Errorf("Expected 10 hook calls, got %d.", added.Load())
+		}
+
+		if err := sc.RemoveHook(hook); err != nil {
+			t.Fatal(err)
+		}
+		sc.AddRecord(user("user10", 10))
+		if added.Load() != 10 {
+			t.Errorf("Removed hook should not be called, got %d calls.", added.Load())
+		}
+		if err := sc.RemoveHook(hook); err == nil {
+			t.Error("Removed hook should not be found.")
+		}
+		if err := rmc.RemoveHook(hook + 100); err == nil {
+			t.Error("Unknown hook should not be found.")
+		}
+	})
+
+	t.Run("views", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Employees",
+			FieldsNaming: []string{"name", "dept", "salary", "status"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}, FieldConf[int]{}, FieldConf[string]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "dept"}}},
+		}})
+		employee := func(name string, dept string, salary int, status string) RecordConf {
+			return RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: name}, FieldConf[string]{Value: dept},
+				FieldConf[int]{Value: salary}, FieldConf[string]{Value: status},
+			}}
+		}
+		ids, err := rmc.BulkLoad([]RecordConf{
+			employee("Alice", "dev", 100, "active"),
+			employee("Bob", "dev", 80, "active"),
+			employee("Carol", "ops", 90, "left"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		active := QueryAtomConf{Name: "status", Value: "active", MatchType: FullmatchIndexConf[string]{}}
+		all := FilterArgument{QueryConf: active, Limit: NO_LIMIT}
+
+		if NewMaterializedView(MaterializedViewConf{FilterArgument: FilterArgument{QueryConf: active, Limit: 10}}, rmc) != nil {
+			t.Error("View with a limit should not be created.")
+		}
+		if NewMaterializedView(MaterializedViewConf{FilterArgument: all, Group: &GroupConf{
+			Columns:    []string{"dept"},
+			Aggregates: []AggregateConf{{Name: "total", Function: AGG_SUM, Column: "name"}},
+		}}, rmc) != nil {
+			t.Error("Sum of a string column should fail.")
+		}
+
+		plain := NewMaterializedView(MaterializedViewConf{Name: "Active", FilterArgument: all}, rmc)
+		byDept := NewMaterializedView(MaterializedViewConf{Name: "ByDept", FilterArgument: all, Group: &GroupConf{
+			Columns: []string{"dept"},
+			Aggregates: []AggregateConf{
+				{Name: "count", Function: AGG_COUNT},
+				{Name: "total", Function: AGG_SUM, Column: "salary"},
+				{Name: "avg", Function: AGG_AVG, Column: "salary"},
+				{Name: "min", Function: AGG_MIN, Column: "salary"},
+				{Name: "max", Function: AGG_MAX, Column: "salary"},
+			},
+		}}, rmc)
+		if plain == nil || byDept == nil {
+			t.Fatal("Views should be created.")
+		}
+
+		names := func() []string {
+			out, err := filterCollect(plain, FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"name"}, Limit: NO_LIMIT})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := make([]string, len(out))
+			for i, rec := range out {
+				names[i] = rec.Cols[0].(FieldConf[string]).Value
+			}
+			return names
+		}
+		group := func(dept string) []any {
+			out, err := filterCollect(byDept, FilterArgument{QueryConf: QueryAtomConf{Name: "dept", Value: dept, MatchType: FullmatchIndexConf[string]{}}, Limit: NO_LIMIT})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out) == 0 {
+				return nil
+			}
+			if len(out) > 1 {
+				t.Fatalf("Group %s should be a single record.", dept)
+			}
+			values := make([]any, 0, 5)
+			for _, fc := range out[0].Cols[1:] {
+				values = append(values, reflect.ValueOf(fc).FieldByName("Value").Interface())
+			}
+			return values
+		}
+
+		if n := names(); !slices.Equal(n, []string{"Alice", "Bob"}) {
+			t.Errorf("View should hold the active employees, got %v.", n)
+		}
+		if recs, _ := plain.Get(ids[0]); len(recs) != 1 {
+			t.Error("Records of the view should keep the CIds of the source.")
+		}
+		if g := group("dev"); !reflect.DeepEqual(g, []any{2, 180.0, 90.0, 80.0, 100.0}) {
+			t.Errorf("Wrong aggregates of group dev %v.", g)
+		}
+		if g := group("ops"); g != nil {
+			t.Errorf("Group without active employees should not exist, got %v.", g)
+		}
+
+		// incremental maintenance
+		if _, err := rmc.AddRecord(employee("Dave", "ops", 70, "active")); err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.PatchRecord(ids[2], PatchConf{Cols: map[string]FielderConf{"status": FieldConf[string]{Value: "active"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.PatchRecord(ids[0], PatchConf{Cols: map[string]FielderConf{"dept": FieldConf[string]{Value: "ops"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if n := names(); !slices.Equal(n, []string{"Alice", "Bob", "Carol", "Dave"}) {
+			t.Errorf("View should follow the source, got %v.", n)
+		}
+		if g := group("ops"); !reflect.DeepEqual(g, []any{3, 260.0, 260.0 / 3, 70.0, 100.0}) {
+			t.Errorf("Wrong aggregates of group ops %v.", g)
+		}
+		if g := group("dev"); !reflect.DeepEqual(g, []any{1, 80.0, 80.0, 80.0, 80.0}) {
+			t.Errorf("Record moved to another group should leave its group, got %v.", g)
+		}
+
+		if err := rmc.DeleteRecord(RecordConf{Id: ids[1]}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.PatchRecord(ids[0], PatchConf{Cols: map[string]FielderConf{"status": FieldConf[string]{Value: "left"}}}); err != nil {
+			t.Fatal(err)
+		}
+		if n := names(); !slices.Equal(n, []string{"Carol", "Dave"}) {
+			t.Errorf("Records no longer matching should leave the view, got %v.", n)
+		}
+		if g := group("dev"); g != nil {
+			t.Errorf("Emptied group should be removed, got %v.", g)
+		}
+		if g := group("ops"); !reflect.DeepEqual(g, []any{2, 160.0, 80.0, 70.0, 90.0}) {
+			t.Errorf("Wrong aggregates of group ops %v.", g)
+		}
+
+		// read API
+		var view Collection = byDept
+		if n, _ := view.Count(QueryAndConf{}); n != 1 {
+			t.Errorf("View should have 1 group, got %d.", n)
+		}
+		if found, _ := plain.Exists(QueryAtomConf{Name: "name", Value: "Dave", MatchType: FullmatchIndexConf[string]{}}); !found {
+			t.Error("Dave should be found in the view.")
+		}
+		if schema := view.Schema(); !slices.Equal(schema.FieldsNaming, []string{"dept", "count", "total", "avg", "min", "max"}) {
+			t.Errorf("Wrong columns of the view %v.", schema.FieldsNaming)
+		}
+		if _, err := view.AddRecord(employee("Eve", "dev", 60, "active")); err == nil {
+			t.Error("View should be read-only.")
+		}
+
+		hooks := func() int {
+			// the hooks are not exported, they are only counted
+			return reflect.ValueOf(rmc).Elem().FieldByName("hooks").Index(AFTER_ADD).Len()
+		}
+		registered := hooks()
+		plain.Close()
+		if hooks() != registered-1 {
+			t.Errorf("Closed view should remove its hook, %d of %d left.", hooks(), registered)
+		}
+		plain.Close()
+		rmc.AddRecord(employee("Eve", "dev", 60, "active"))
+		if n := names(); len(n) != 2 {
+			t.Errorf("Closed view should not be maintained, got %v.", n)
+		}
+		if g := group("dev"); !reflect.DeepEqual(g, []any{1, 60.0, 60.0, 60.0, 60.0}) {
+			t.Errorf("Wrong aggregates of group dev %v.", g)
+		}
+	})
+
+	t.Run("serializeData", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{
+			SchemaConf: SchemaConf{
+				Name:         "Files",
+				FieldsNaming: []string{"path", "size"},
+				Fields:       []FielderConf{FieldConf[[]string]{}, FieldConf[int]{}},
+				Indexes:      [][]IndexerConf{{PrefixIndexConf[[]string]{Name: "path"}}, {FullmatchIndexConf[int]{Name: "size"}}},
+				Computed:     []ComputedConf{{Name: "file", Field: FieldConf[string]{}, Function: "last", Arguments: []string{"path"}}},
+			},
+		})
+		file := func(size int, path ...string) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[[]string]{Value: path}, FieldConf[int]{Value: size}}}
+		}
+
+		ids, err := rmc.BulkLoad([]RecordConf{file(10, "etc", "hosts"), file(20, "etc", "passwd"), file(30, "usr", "bin", "go")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.PatchRecord(ids[0], PatchConf{Cols: map[string]FielderConf{"size": FieldConf[int]{Value: 15}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rmc.DeleteRecord(RecordConf{Id: ids[2]}); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := rmc.SerializeData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		serialized := conf.(RamCollectionConf)
+		if len(serialized.Data.Records) != 2 || serialized.Data.Records[0].Id != ids[0] || serialized.Data.Records[0].Version != 2 {
+			t.Fatalf("Serialized records are wrong %v.", serialized.Data.Records)
+		}
+
+		restored := NewRamCollection(serialized)
+		if restored == nil {
+			t.Fatal("Collection should be restored from its serialization.")
+		}
+		if conf, _ := restored.SerializeData(); !reflect.DeepEqual(conf, serialized) {
+			t.Error("Serialization of the restored collection should be equal.")
+		}
+
+		out, err := filterCollect(restored, FilterArgument{
+			QueryConf: QueryAtomConf{Name: "path", Value: []string{"etc"}, MatchType: PrefixIndexConf[[]string]{}},
+			Sort:      []string{"size"},
+			Limit:     NO_LIMIT,
+		})
+		if err != nil || len(out) != 2 || out[0].Cols[2].(FieldConf[string]).Value != "hosts" || out[0].Cols[1].(FieldConf[int]).Value != 15 {
+			t.Errorf("Indexes of the restored collection should be filled, got %v.", out)
+		}
+		if n, _ := restored.Count(QueryAtomConf{Name: "size", Value: 20, MatchType: FullmatchIndexConf[int]{}}); n != 1 {
+			t.Errorf("Restored collection should find 1 record of size 20, got %d.", n)
+		}
+		if cid, _ := restored.AddRecord(file(40, "tmp", "x")); cid <= ids[2] {
+			t.Errorf("Restored collection should not reuse the ids, got %d.", cid)
+		}
+		if err := restored.EditRecord(RecordConf{Id: ids[0], Cols: file(5, "etc", "hosts").Cols, Version: 1}); err == nil {
+			t.Error("Restored collection should keep the versions.")
+		}
+
+		if data := restored.Serialize().(RamCollectionConf).Data; len(data.Records) != 0 || data.Autoincrement != 0 {
+			t.Error("Serialize should omit the records.")
+		}
+		// the configuration is not kept with the loaded records
+		if param := reflect.ValueOf(restored).Elem().FieldByName("param").FieldByName("Data").FieldByName("Records"); param.Len() != 0 {
+			t.Errorf("Restored collection should not keep %d loaded records in its configuration.", param.Len())
+		}
+
+		// the history of a versioned collection starts with the loaded records
+		versionedConf := serialized
+		versionedConf.Versioned = true
+		versioned := NewRamCollection(versionedConf)
+		time.Sleep(time.Millisecond)
+		loaded := time.Now()
+		time.Sleep(time.Millisecond)
+		if err := versioned.DeleteRecord(RecordConf{Id: ids[0]}); err != nil {
+			t.Fatal(err)
+		}
+		added, _ := versioned.AddRecord(file(50, "var", "log"))
+		time.Sleep(time.Millisecond)
+		for at, expected := range map[time.Time][]CId{loaded: {ids[0], ids[1]}, time.Now(): {ids[1], added}} {
+			out, err := filterCollect(versioned, FilterArgument{QueryConf: QueryAndConf{}, AsOf: at, Limit: NO_LIMIT})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]CId, len(out))
+			for i, rec := range out {
+				got[i] = rec.Id
+			}
+			if !slices.Equal(got, expected) {
+				t.Errorf("Expected records %v as of %v, got %v.", expected, at, got)
+			}
+		}
+
+		broken := serialized
+		broken.Data.Records = append(slices.Clone(broken.Data.Records), broken.Data.Records[0])
+		if NewRamCollection(broken) != nil {
+			t.Error("Duplicate ids should fail.")
+		}
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "Items",
+			FieldsNaming: []string{"name", "kind", "size"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}, FieldConf[int]{}},
+			Indexes:      [][]IndexerConf{{BitmapIndexConf[string]{Name: "kind"}}},
+		}
+		kinds := []string{"a", "b", "c", "d"}
+		items := make([]RecordConf, 100)
+		for i := range items {
+			items[i] = RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: fmt.Sprintf("item%d", i)}, FieldConf[string]{Value: kinds[i%4]}, FieldConf[int]{Value: i},
+			}}
+		}
+
+		single := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		shards := make([]Collection, 3)
+		for i := range shards {
+			shards[i] = NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		}
+		sharded := NewShardedCollection(ShardedCollectionConf{ShardKey: "name"}, shards...)
+		if _, err := single.BulkLoad(items); err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range items {
+			if _, err := sharded.AddRecord(rec); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all := FilterArgument{QueryConf: QueryAndConf{}}
+		kindA := FilterArgument{QueryConf: QueryAtomConf{Name: "kind", Value: "a", MatchType: BitmapIndexConf[string]{}}}
+		bySize := FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"size"}, SortOrder: DESC}
+
+		for name, c := range map[string]Collection{"single": single, "sharded": sharded} {
+			sample, err := c.Sample(bySize, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[CId]bool)
+			for i, rec := range sample {
+				seen[rec.Id] = true
+				if i > 0 && rec.Cols[2].(FieldConf[int]).Value > sample[i-1].Cols[2].(FieldConf[int]).Value {
+					t.Errorf("%s: sample should be ordered.", name)
+				}
+			}
+			if len(sample) != 10 || len(seen) != 10 {
+				t.Errorf("%s: sample should have 10 distinct records, got %d.", name, len(seen))
+			}
+
+			if sample, _ = c.Sample(kindA, 5); len(sample) != 5 {
+				t.Errorf("%s: sample of kind a should have 5 records, got %d.", name, len(sample))
+			}
+			for _, rec := range sample {
+				if rec.Cols[1].(FieldConf[string]).Value != "a" {
+					t.Errorf("%s: sampled record %v does not match the query.", name, rec)
+				}
+			}
+			if sample, _ = c.Sample(kindA, 1000); len(sample) != 25 {
+				t.Errorf("%s: oversized sample should hold all 25 matching records, got %d.", name, len(sample))
+			}
+			if sample, _ = c.Sample(all, 0); len(sample) != 0 {
+				t.Errorf("%s: empty sample should be empty.", name)
+			}
+			if _, err := c.Sample(all, -1); err == nil {
+				t.Errorf("%s: negative size should fail.", name)
+			}
+
+			strata := make(map[string]int)
+			sample, err = c.StratifiedSample(FilterArgument{QueryConf: QueryAndConf{}}, "kind", 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, rec := range sample {
+				strata[rec.Cols[1].(FieldConf[string]).Value]++
+				if i > 0 && rec.Id <= sample[i-1].Id {
+					t.Errorf("%s: stratified sample should be ordered by CIds.", name)
+				}
+			}
+			if !reflect.DeepEqual(strata, map[string]int{"a": 3, "b": 3, "c": 3, "d": 3}) {
+				t.Errorf("%s: each stratum should have 3 records, got %v.", name, strata)
+			}
+			if sample, _ = c.StratifiedSample(kindA, "kind", 30); len(sample) != 25 {
+				t.Errorf("%s: stratum smaller than the sample should be whole, got %d.", name, len(sample))
+			}
+			if _, err := c.StratifiedSample(all, "color", 3); err == nil {
+				t.Errorf("%s: unknown stratum column should fail.", name)
+			}
+
+			// every record of the stratum should have the same chance
+			hits := make(map[string]int)
+			few := QueryOrConf{QueryContextConf{Context: []QueryConf{}}}
+			for _, size := range []int{0, 1, 4, 5} {
+				few.Context = append(few.Context, QueryAtomConf{Name: "size", Value: size, MatchType: FullmatchIndexConf[int]{}})
+			}
+			for i := 0; i < 2000; i++ {
+				sample, err := c.StratifiedSample(FilterArgument{QueryConf: few}, "kind", 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, rec := range sample {
+					hits[rec.Cols[0].(FieldConf[string]).Value]++
+				}
+			}
+			for _, item := range []string{"item0", "item1", "item4", "item5"} {
+				if hits[item] < 850 || hits[item] > 1150 {
+					t.Errorf("%s: %s sampled %d times out of 2000 draws of 1 of 2.", name, item, hits[item])
+				}
+			}
+		}
+	})
+
+	t.Run("distinct", func(t *testing.T) {
+		schema := SchemaConf{
+			Name:         "Products",
+			FieldsNaming: []string{"kind", "color", "tags", "path", "size"},
+			Fields: []FielderConf{
+				FieldConf[string]{}, FieldConf[string]{}, FieldConf[[]string]{}, FieldConf[string]{}, FieldConf[int]{},
+			},
+		}
+		indexed := schema
+		indexed.Indexes = [][]IndexerConf{
+			{BitmapIndexConf[string]{Name: "kind"}},
+			{FullmatchIndexConf[string]{Name: "color"}},
+			{FullmatchIndexConf[[]string]{Name: "tags"}},
+			{PrefixIndexConf[string]{Name: "path"}},
+		}
+		plain := NewRamCollection(RamCollectionConf{SchemaConf: schema})
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: indexed})
+		shards := make([]Collection, 3)
+		for i := range shards {
+			shards[i] = NewRamCollection(RamCollectionConf{SchemaConf: indexed})
+		}
+		sharded := NewShardedCollection(ShardedCollectionConf{ShardKey: "path"}, shards...)
+
+		colors := []string{"red", "green", "blue"}
+		for i := 0; i < 30; i++ {
+			rec := RecordConf{Cols: []FielderConf{
+				FieldConf[string]{Value: []string{"shirt", "shoe"}[i%2]},
+				FieldConf[string]{Value: colors[i%5%3]},
+				FieldConf[[]string]{Value: []string{"sale", fmt.Sprint(i % 3)}[:1+i%2]},
+				FieldConf[string]{Value: fmt.Sprintf("/p/%d", i%7)},
+				FieldConf[int]{Value: i % 4},
+			}}
+			for _, c := range []Collection{plain, rmc, sharded} {
+				if _, err := c.AddRecord(rec); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		shirts := QueryAtomConf{Name: "kind", Value: "shirt", MatchType: BitmapIndexConf[string]{}}
+		queries := map[string]QueryConf{
+			"all":    QueryAndConf{},
+			"shirts": shirts,
+			"small": QueryOrConf{QueryContextConf{Context: []QueryConf{
+				QueryAtomConf{Name: "size", Value: 0, MatchType: FullmatchIndexConf[int]{}},
+				QueryAtomConf{Name: "size", Value: 1, MatchType: FullmatchIndexConf[int]{}},
+			}}},
+		}
+
+		for _, column := range schema.FieldsNaming {
+			for name, q := range queries {
+				expected, err := plain.Distinct(column, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				total := 0
+				for i, dc := range expected {
+					total += dc.Count
+					if i > 0 && dc.Count > expected[i-1].Count {
+						t.Errorf("%s of %s: values should be ordered by their frequencies.", column, name)
+					}
+				}
+				if n, _ := plain.Count(q); total != n {
+					t.Errorf("%s of %s: frequencies should sum to %d, got %d.", column, name, n, total)
+				}
+				for cname, c := range map[string]Collection{"indexed": rmc, "sharded": sharded} {
+					if got, err := c.Distinct(column, q); err != nil || !reflect.DeepEqual(got, expected) {
+						t.Errorf("%s of %s (%s): expected %v, got %v (%v).", column, name, cname, expected, got, err)
+					}
+				}
+			}
+		}
+
+		got, _ := rmc.Distinct("color", shirts)
+		expected := []DistinctConf{
+			{Value: FieldConf[string]{Value: "green"}, Count: 6},
+			{Value: FieldConf[string]{Value: "red"}, Count: 6},
+			{Value: FieldConf[string]{Value: "blue"}, Count: 3},
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %v, got %v.", expected, got)
+		}
+		if got, _ = rmc.Distinct("kind", QueryAtomConf{Name: "kind", Value: "hat", MatchType: BitmapIndexConf[string]{}}); len(got) != 0 {
+			t.Errorf("No records should give no values, got %v.", got)
+		}
+		if _, err := rmc.Distinct("weight", QueryAndConf{}); err == nil {
+			t.Error("Unknown column should fail.")
+		}
+	})
+
+	t.Run("editReindexesNewValue", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Cities",
+			FieldsNaming: []string{"name"},
+			Fields:       []FielderConf{FieldConf[string]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "name"}}},
+		}})
+		city := func(name string) []FielderConf { return []FielderConf{FieldConf[string]{Value: name}} }
+		byName := func(name string) QueryConf {
+			return QueryAtomConf{Name: "name", Value: name, MatchType: FullmatchIndexConf[string]{}}
+		}
+
+		cid, _ := rmc.AddRecord(RecordConf{Cols: city("Brno")})
+		if err := rmc.EditRecord(RecordConf{Id: cid, Cols: city("Praha")}); err != nil {
+			t.Fatal(err)
+		}
+
+		// the index used to get the old value back instead of the new one
+		if n, _ := rmc.Count(byName("Praha")); n != 1 {
+			t.Errorf("Edited record should be found by its new value, got %d.", n)
+		}
+		if n, _ := rmc.Count(byName("Brno")); n != 0 {
+			t.Errorf("Edited record should not be found by its old value, got %d.", n)
+		}
+	})
+
+	t.Run("updateWhereAtomic", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Accounts",
+			FieldsNaming: []string{"owner", "state"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[string]{Name: "state"}}},
+		}})
+		for _, owner := range []string{"ann", "ben", "zoe"} {
+			rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: owner}, FieldConf[string]{Value: "open"}}})
+		}
+		frozen := true
+		rmc.AddHook(BEFORE_EDIT, func(m *MutationConf) error {
+			if frozen && m.New.Cols[0].(FieldConf[string]).Value == "zoe" {
+				return errors.NewStateError(rmc, errors.LevelError, "Frozen account.")
+			}
+			return nil
+		})
+
+		closed := PatchConf{Cols: map[string]FielderConf{"state": FieldConf[string]{Value: "closed"}}}
+		all := FilterArgument{QueryConf: QueryAndConf{}, Sort: []string{"owner"}, Limit: NO_LIMIT}
+		if n, err := rmc.UpdateWhere(all, closed); err == nil || n != 0 {
+			t.Errorf("Vetoed update should fail, got %d.", n)
+		}
+
+		state := func(s string) QueryConf {
+			return QueryAtomConf{Name: "state", Value: s, MatchType: FullmatchIndexConf[string]{}}
+		}
+		if n, _ := rmc.Count(state("open")); n != 3 {
+			t.Errorf("Failed update should leave all records open, got %d.", n)
+		}
+		out, _ := filterCollect(rmc, all)
+		for _, rec := range out {
+			if rec.Version != 1 || rec.Cols[1].(FieldConf[string]).Value != "open" {
+				t.Errorf("Record %v should not be updated.", rec)
+			}
+		}
+
+		frozen = false
+		if n, err := rmc.UpdateWhere(all, closed); err != nil || n != 3 {
+			t.Errorf("Update should close 3 accounts, got %d (%v).", n, err)
+		}
+		if n, _ := rmc.Count(state("closed")); n != 3 {
+			t.Errorf("Expected 3 closed accounts, got %d.", n)
+		}
+	})
+
+	t.Run("constraintsAtomic", func(t *testing.T) {
+		owners := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Owners",
+			FieldsNaming: []string{"name", "age"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}},
+		}})
+		pets := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Pets",
+			FieldsNaming: []string{"owner", "name"},
+			Fields:       []FielderConf{FieldConf[uint64]{}, FieldConf[string]{}},
+			References:   []ReferenceConf{{Column: "owner", Collection: "Owners", OnDelete: ON_DELETE_CASCADE}},
+		}})
+		tags := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Tags",
+			FieldsNaming: []string{"ownerName"},
+			Fields:       []FielderConf{FieldConf[string]{}},
+			References:   []ReferenceConf{{Column: "ownerName", Collection: "Owners", Target: "name", OnDelete: ON_DELETE_SET_NULL}},
+		}})
+		pets.Link(owners)
+		tags.Link(owners)
+
+		owner := func(name string) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}, FieldConf[int]{Value: 30}}}
+		}
+		alice, _ := owners.AddRecord(owner("alice"))
+		bob, _ := owners.AddRecord(owner("bob"))
+		for _, name := range []string{"max", "rex"} {
+			pets.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[uint64]{Value: uint64(alice)}, FieldConf[string]{Value: name}}})
+		}
+		tags.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "alice"}}})
+		bobTag, _ := tags.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "bob"}}})
+
+		guarded := true
+		pets.AddHook(BEFORE_DELETE, func(m *MutationConf) error {
+			if guarded && m.Old.Cols[1].(FieldConf[string]).Value == "rex" {
+				return errors.NewStateError(pets, errors.LevelError, "Rex stays.")
+			}
+			return nil
+		})
+
+		named := func(name string) QueryConf {
+			return QueryAtomConf{Name: "ownerName", Value: name, MatchType: FullmatchIndexConf[string]{}}
+		}
+		if err := owners.DeleteRecord(RecordConf{Id: alice}); err == nil {
+			t.Error("Deletion with a vetoed cascade should fail.")
+		}
+		if n, _ := owners.Count(QueryAndConf{}); n != 2 {
+			t.Errorf("Failed deletion should keep the owner, got %d owners.", n)
+		}
+		if n, _ := pets.Count(QueryAndConf{}); n != 2 {
+			t.Errorf("Failed deletion should keep all pets, got %d.", n)
+		}
+		if n, _ := tags.Count(named("alice")); n != 1 {
+			t.Error("Failed deletion should not set the references to null.")
+		}
+
+		guarded = false
+		if err := owners.DeleteRecord(RecordConf{Id: alice}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := pets.Count(QueryAndConf{}); n != 0 {
+			t.Errorf("Pets should be deleted by the cascade, got %d.", n)
+		}
+		if n, _ := tags.Count(named("")); n != 1 {
+			t.Error("Reference should be set to null.")
+		}
+
+		rename := PatchConf{Cols: map[string]FielderConf{"name": FieldConf[string]{Value: "robert"}}}
+		if err := owners.PatchRecord(bob, rename); err == nil {
+			t.Error("Edit of a referenced value should fail.")
+		}
+		if n, _ := tags.Count(named("bob")); n != 1 {
+			t.Error("Referencing record should not change.")
+		}
+		if err := owners.PatchRecord(bob, PatchConf{Cols: map[string]FielderConf{"age": FieldConf[int]{Value: 31}}}); err != nil {
+			t.Errorf("Edit of an unreferenced column should pass: %v", err)
+		}
+		tags.DeleteRecord(RecordConf{Id: bobTag})
+		if err := owners.PatchRecord(bob, rename); err != nil {
+			t.Errorf("Edit of a value no longer referenced should pass: %v", err)
+		}
+	})
+
+	t.Run("constraintsConcurrent", func(t *testing.T) {
+		schema := func(name string, other string) RamCollectionConf {
+			return RamCollectionConf{SchemaConf: SchemaConf{
+				Name:         name,
+				FieldsNaming: []string{"other"},
+				Fields:       []FielderConf{FieldConf[uint64]{}},
+				Indexes:      [][]IndexerConf{{FullmatchIndexConf[uint64]{Name: "other"}}},
+				References:   []ReferenceConf{{Column: "other", Collection: other, OnDelete: ON_DELETE_SET_NULL}},
+			}}
+		}
+		left := NewRamCollection(schema("Left", "Right"))
+		right := NewRamCollection(schema("Right", "Left"))
+		left.Link(right)
+		right.Link(left)
+
+		ref := func(cid CId) RecordConf {
+			return RecordConf{Cols: []FielderConf{FieldConf[uint64]{Value: uint64(cid)}}}
+		}
+		l0, _ := left.AddRecord(ref(0))
+		r0, _ := right.AddRecord(ref(l0))
+
+		// widens the window between taking the lock of a collection and checking the other one
+		slow := func(*MutationConf) error {
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		left.AddHook(BEFORE_ADD, slow)
+		right.AddHook(BEFORE_ADD, slow)
+
+		var wg sync.WaitGroup
+		writer := func(c *RamCollection, target CId) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				cid, err := c.AddRecord(ref(target))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				c.DeleteRecord(RecordConf{Id: cid})
+			}
+		}
+		wg.Add(4)
+		go writer(left, r0)
+		go writer(right, l0)
+		go writer(left, r0)
+		go writer(right, l0)
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Writers of mutually referencing collections are deadlocked.")
+		}
+	})
+
+	t.Run("viewsNaN", func(t *testing.T) {
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Measurements",
+			FieldsNaming: []string{"sensor", "value"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[float64]{}},
+		}})
+		view := NewMaterializedView(MaterializedViewConf{
+			Name:           "BySensor",
+			FilterArgument: FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT},
+			Group: &GroupConf{Columns: []string{"sensor"}, Aggregates: []AggregateConf{
+				{Name: "sum", Function: AGG_SUM, Column: "value"},
+				{Name: "min", Function: AGG_MIN, Column: "value"},
+				{Name: "max", Function: AGG_MAX, Column: "value"},
+			}},
+		}, rmc)
+		defer view.Close()
+
+		measure := func(v float64) CId {
+			cid, _ := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "s"}, FieldConf[float64]{Value: v}}})
+			return cid
+		}
+		aggregates := func() []float64 {
+			recs, _ := filterCollect(view, FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT})
+			if len(recs) != 1 {
+				return nil
+			}
+			out := make([]float64, 0, 3)
+			for _, fc := range recs[0].Cols[1:] {
+				out = append(out, fc.(FieldConf[float64]).Value)
+			}
+			return out
+		}
+
+		nan := measure(math.NaN())
+		if a := aggregates(); len(a) != 3 || !math.IsNaN(a[0]) || !math.IsNaN(a[1]) || !math.IsNaN(a[2]) {
+			t.Errorf("Aggregates of a NaN should be NaN, got %v.", a)
+		}
+		measure(5)
+		measure(-2)
+		if a := aggregates(); len(a) != 3 || !math.IsNaN(a[1]) {
+			t.Errorf("Minimum with a NaN should be NaN, got %v.", a)
+		}
+		rmc.DeleteRecord(RecordConf{Id: nan})
+		if a := aggregates(); !slices.Equal(a, []float64{3, -2, 5}) {
+			t.Errorf("Aggregates without the NaN should recover, got %v.", a)
+		}
+	})
+
+	t.Run("viewsBroken", func(t *testing.T) {
+		// fails every second call when armed, so the view fails after the source succeeds
+		var armed bool
+		var calls int
+		RegisterFunction("flaky", func(args ...any) (any, error) {
+			if armed {
+				if calls++; calls%2 == 0 {
+					return nil, fmt.Errorf("flaky failure")
+				}
+			}
+			return args[0], nil
+		})
+		rmc := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Flaky",
+			FieldsNaming: []string{"name"},
+			Fields:       []FielderConf{FieldConf[string]{}},
+			Computed:     []ComputedConf{{Name: "copy", Field: FieldConf[string]{}, Function: "flaky", Arguments: []string{"name"}}},
+		}})
+		view := NewMaterializedView(MaterializedViewConf{
+			Name:           "FlakyView",
+			FilterArgument: FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT},
+		}, rmc)
+		defer view.Close()
+
+		rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "a"}}})
+		if n, err := view.Count(QueryAndConf{}); err != nil || n != 1 {
+			t.Fatalf("View should have 1 record, got %d (%v).", n, err)
+		}
+
+		armed = true
+		if _, err := rmc.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: "b"}}}); err != nil {
+			t.Fatal(err)
+		}
+		armed = false
+		if _, err := view.Count(QueryAndConf{}); err == nil {
+			t.Error("View missing a mutation should fail.")
+		}
+		if _, err := view.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, Limit: NO_LIMIT}); err == nil {
+			t.Error("View missing a mutation should fail.")
+		}
+	})
+
+	t.Run("expiryConstraints", func(t *testing.T) {
+		owners := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Owners",
+			FieldsNaming: []string{"name", "expires"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[time.Time]{}},
+			ExpiryColumn: "expires",
+		}})
+		pets := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Pets",
+			FieldsNaming: []string{"owner"},
+			Fields:       []FielderConf{FieldConf[uint64]{}},
+			References:   []ReferenceConf{{Column: "owner", Collection: "Owners", OnDelete: ON_DELETE_RESTRICT}},
+		}})
+		leashes := NewRamCollection(RamCollectionConf{SchemaConf: SchemaConf{
+			Name:         "Leashes",
+			FieldsNaming: []string{"owner"},
+			Fields:       []FielderConf{FieldConf[uint64]{}},
+			References:   []ReferenceConf{{Column: "owner", Collection: "Owners", OnDelete: ON_DELETE_CASCADE}},
+		}})
+		if err := pets.Link(owners); err != nil {
+			t.Fatal(err)
+		}
+		if err := leashes.Link(owners); err != nil {
+			t.Fatal(err)
+		}
+
+		owner := func(name string, expires time.Time) CId {
+			id, err := owners.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[string]{Value: name}, FieldConf[time.Time]{Value: expires}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}
+		past := time.Now().Add(-time.Second)
+		petOwner := owner("petOwner", time.Time{})
+		leashOwner := owner("leashOwner", time.Time{})
+		if _, err := pets.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[uint64]{Value: uint64(petOwner)}}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := leashes.AddRecord(RecordConf{Cols: []FielderConf{FieldConf[uint64]{Value: uint64(leashOwner)}}}); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []CId{petOwner, leashOwner} {
+			if err := owners.PatchRecord(id, PatchConf{Cols: map[string]FielderConf{"expires": FieldConf[time.Time]{Value: past}}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		owner("alone", past)
+
+		if n, err := owners.Reap(); err != nil || n != 2 {
+			t.Errorf("Expected 2 reaped records, got %d (%v).", n, err)
+		}
+		revived := PatchConf{Cols: map[string]FielderConf{"expires": FieldConf[time.Time]{}}}
+		if err := owners.PatchRecord(petOwner, revived); err != nil {
+			t.Error("Expired record referenced through a restricting reference should be kept.")
+		}
+		if n, _ := leashes.Count(QueryAndConf{}); n != 0 {
+			t.Errorf("Reaping should cascade, %d referencing records left.", n)
+		}
+	})
+
+	// TODO
+	t.Run("commit", func(t *testing.T) {
+		// TODO: ...
+		rmc := prepareTable(true, true, false)
+		err := rmc.Commit()
+		if err != nil {
+			t.Error(err.Error())
+		}
+	})
+}
+
+func testNthLine(rc []RecordConf, n int) error {
+	rclen := len(rc[n].Cols)
+
+	if rclen != 2 {
+		return fmt.Errorf("Wrong number of result columns %d", rclen)
+	}
+
+	col1, ok1 := rc[n].Cols[0].(FieldConf[string])
+
+	if !ok1 {
+		return fmt.Errorf("Cannot cast to the original FieldStringConf.")
+	}
+
+	if col1.Value != "row0_str110" && col1.Value != "row1_str121" {
+		return fmt.Errorf("Wrong order of values, got: %s", col1.Value)
+	}
+
+	col1_2, ok1_2 := rc[n].Cols[1].(FieldConf[string])
+
+	if col1.Value == "row0_str110" {
+		if !ok1_2 {
+			return fmt.Errorf("Cannot cast to the original FieldStringConf.")
+		}
+		if col1_2.Value != "row0_str345" {
+			return fmt.Errorf("Wrong second value of column 1.")
+		}
+	} else {
+		if !ok1_2 {
+			return fmt.Errorf("Cannot cast to the original FieldStringConf.")
+		}
+		if col1_2.Value != "row1_str368" {
+			return fmt.Errorf("Wrong second value of column 2.")
+		}
+	}
+
+	return nil
+}
+
+func prepareTable(fullmatchIndexP bool, prefixIndexP bool, stringPrefixIndexP bool) *RamCollection {
+
+	rmC := RamCollectionConf{
+		SchemaConf: SchemaConf{
+			Name:         "FooBarTable",
+			FieldsNaming: []string{"who", "whom"},
+			Fields: []FielderConf{
+				FieldConf[string]{},
+				FieldConf[string]{},
+			},
+			Indexes: [][]IndexerConf{{}},
+		},
+		MaxMemory: 1024 * 1024 * 1024,
+	}
+
+	if fullmatchIndexP {
+
+		rmC.Indexes = [][]IndexerConf{
+			{
+				FullmatchIndexConf[string]{Name: "who"},
+				FullmatchIndexConf[string]{Name: "whom"},
+			},
+		}
+	}
+
+	if prefixIndexP {
+
+		rmC.SchemaConf.Fields = []FielderConf{
+			FieldConf[[]string]{},
+			FieldConf[[]string]{},
+		}
+
+		rmC.Indexes = [][]IndexerConf{
+			{
+				PrefixIndexConf[[]string]{Name: "who"},
+				PrefixIndexConf[[]string]{Name: "whom"},
+			},
+		}
+	}
+
+	if stringPrefixIndexP {
+
+		rmC.Indexes = [][]IndexerConf{
+			{
+				PrefixIndexConf[string]{Name: "who"},
+				PrefixIndexConf[string]{Name: "whom"},
+			},
+		}
+
+	}
+
+	return NewRamCollection(rmC)
+}
+
+func prepareJoinTables(indexed bool) (*RamCollection, *RamCollection) {
+	files := NewRamCollection(RamCollectionConf{
+		SchemaConf: SchemaConf{
+			Name:         "Files",
+			FieldsNaming: []string{"path", "owner", "size"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}, FieldConf[int]{}},
+		},
+	})
+
+	ownersConf := RamCollectionConf{
+		SchemaConf: SchemaConf{
+			Name:         "Owners",
+			FieldsNaming: []string{"name", "group"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[string]{}},
+		},
+	}
+	if indexed {
+		ownersConf.Indexes = [][]IndexerConf{{FullmatchIndexConf[string]{Name: "name"}}}
+	}
+	owners := NewRamCollection(ownersConf)
+
+	for i, owner := range []string{"alice", "bob", "carol"} {
+		files.AddRecord(RecordConf{Cols: []FielderConf{
+			FieldConf[string]{Value: fmt.Sprintf("/file%d", i)},
+			FieldConf[string]{Value: owner},
+			FieldConf[int]{Value: i},
+		}})
+	}
+	for _, row := range [][]string{{"alice", "staff"}, {"bob", "staff"}, {"bob", "admin"}} {
+		owners.AddRecord(RecordConf{Cols: []FielderConf{
+			FieldConf[string]{Value: row[0]},
+			FieldConf[string]{Value: row[1]},
+		}})
+	}
+
+	return files, owners
+}
+
+func prepareExchangeTable() *RamCollection {
+	return NewRamCollection(RamCollectionConf{
+		SchemaConf: SchemaConf{
+			Name:         "Exchange",
+			FieldsNaming: []string{"name", "size", "tags", "created"},
+			Fields:       []FielderConf{FieldConf[string]{}, FieldConf[int]{}, FieldConf[[]string]{}, FieldConf[time.Time]{}},
+			Indexes:      [][]IndexerConf{{FullmatchIndexConf[int]{Name: "size"}}},
+		},
+	})
+}
+
+/*
+Waits until the condition holds, fails the test after five seconds.
+*/
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time.")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
 }
 
 func testFilling(rmc *RamCollection, iteration int, prefixI bool) error {
@@ -1426,7 +4268,7 @@ func testFilling(rmc *RamCollection, iteration int, prefixI bool) error {
 	return nil
 }
 
-func filterCollect(rmc *RamCollection, fa FilterArgument) ([]RecordConf, error) {
+func filterCollect(rmc Collection, fa FilterArgument) ([]RecordConf, error) {
 	smc, err := rmc.Filter(fa)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,314 @@
+package collection
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+/*
+Function computing the value of a computed column.
+The arguments are the values of the argument columns of the record,
+the result has to be of the type of the computed column.
+*/
+type ComputeFunc func(args ...any) (any, error)
+
+// Functions available to the computed columns, by their names.
+var computeFuncs = map[string]ComputeFunc{
+	"lower": computeLower,
+	"upper": computeUpper,
+	"last":  computeLast,
+	"year":  computeYear,
+}
+
+var computeMutex sync.RWMutex
+
+/*
+Registers the function for the computed columns, replacing the function of the same name.
+Collections resolve the functions on their creation.
+
+Parameters:
+  - name - name of the function, referred to by ComputedConf,
+  - fn - the function.
+*/
+func RegisterFunction(name string, fn ComputeFunc) {
+	computeMutex.Lock()
+	defer computeMutex.Unlock()
+	computeFuncs[name] = fn
+}
+
+// Computed column of a RamCollection.
+type computedColumn struct {
+	col  int // position of the column in the row
+	fn   ComputeFunc
+	args []int // positions of the argument columns
+	kind reflect.Type
+}
+
+/*
+Resolves the computed columns of the schema and appends them after the stored columns.
+Computed columns already present at the end of the columns (a schema of another collection) are kept.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) registerComputed() error {
+	schema := &ego.param.SchemaConf
+
+	stored := len(schema.FieldsNaming)
+	for stored > 0 && slices.ContainsFunc(schema.Computed, func(cc ComputedConf) bool { return cc.Name == schema.FieldsNaming[stored-1] }) {
+		stored--
+	}
+	ego.storedCols = stored
+
+	if len(schema.Computed) == 0 {
+		return nil
+	}
+
+	naming := slices.Clone(schema.FieldsNaming[:stored])
+	fields := slices.Clone(schema.Fields[:stored])
+
+	computeMutex.RLock()
+	defer computeMutex.RUnlock()
+
+	for _, cc := range schema.Computed {
+		if slices.Contains(naming, cc.Name) {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Column %s already exists.", cc.Name))
+		}
+
+		fn, found := computeFuncs[cc.Function]
+		if !found {
+			return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Function %s not registered.", cc.Function))
+		}
+
+		zero, ok := fieldValue(cc.Field)
+		if !ok {
+			return errors.NewNotImplError(ego)
+		}
+
+		column := computedColumn{col: len(naming), fn: fn, args: make([]int, len(cc.Arguments)), kind: reflect.TypeOf(zero)}
+		for i, arg := range cc.Arguments {
+			// only the preceding columns, so the columns can be computed in order
+			if column.args[i] = slices.Index(naming, arg); column.args[i] == -1 {
+				return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Argument column %s not found.", arg))
+			}
+		}
+
+		ego.computed = append(ego.computed, column)
+		naming = append(naming, cc.Name)
+		fields = append(fields, cc.Field)
+	}
+
+	schema.FieldsNaming = naming
+	schema.Fields = fields
+
+	return nil
+}
+
+/*
+Checks if the record has a valid number of columns,
+either all columns or only the stored ones.
+
+Parameters:
+  - n - number of the columns of the record.
+
+Returns:
+  - true if the number is valid, false otherwise.
+*/
+func (ego *RamCollection) validLength(n int) bool {
+	return n == len(ego.param.Fields) || n == ego.storedCols
+}
+
+/*
+Computes the computed columns of the row, the values given for them are replaced.
+
+Parameters:
+  - row - the row, either with all columns or only the stored ones.
+
+Returns:
+  - the row with all columns,
+  - error, if any.
+*/
+func (ego *RamCollection) compute(row []any) ([]any, error) {
+	if len(ego.computed) == 0 {
+		return row, nil
+	}
+
+	switch len(row) {
+	case ego.storedCols:
+		row = append(row, make([]any, len(ego.computed))...)
+	case len(ego.param.Fields):
+	default:
+		return nil, errors.NewValueError(ego, errors.LevelWarning, "Wrong number of columns")
+	}
+
+	for _, c := range ego.computed {
+		args := make([]any, len(c.args))
+		for i, arg := range c.args {
+			args[i] = row[arg]
+		}
+
+		val, err := c.fn(args...)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.TypeOf(val) != c.kind {
+			return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Wrong type of computed column %s.", ego.param.FieldsNaming[c.col]))
+		}
+		row[c.col] = val
+	}
+
+	return row, nil
+}
+
+/*
+Extends the new values of the patched columns by the affected computed columns.
+
+Parameters:
+  - row - current row,
+  - cols - new values by the positions of the columns.
+
+Returns:
+  - new values including the computed columns,
+  - error, if any.
+*/
+func (ego *RamCollection) patchComputed(row []any, cols map[int]any) (map[int]any, error) {
+	if len(ego.computed) == 0 {
+		return cols, nil
+	}
+
+	patched := slices.Clone(row)
+	for col, val := range cols {
+		patched[col] = val
+	}
+
+	patched, err := ego.compute(patched)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[int]any, len(cols)+len(ego.computed))
+	for col, val := range cols {
+		if col < ego.storedCols {
+			out[col] = val
+		}
+	}
+	for _, c := range ego.computed {
+		out[c.col] = patched[c.col]
+	}
+
+	return out, nil
+}
+
+/*
+Creates the error of a built-in function called with wrong arguments.
+
+Parameters:
+  - fn - name of the function,
+  - expected - description of the expected argument.
+
+Returns:
+  - created error.
+*/
+func computeArgError(fn string, expected string) error {
+	return errors.New(errors.ErrorConf{Type: errors.TypeValue, Level: errors.LevelError, Msg: fmt.Sprintf("Function %s takes %s.", fn, expected)})
+}
+
+/*
+Extracts the only argument of a built-in function.
+
+Parameters:
+  - args - the arguments.
+
+Returns:
+  - the argument,
+  - true if there is exactly one argument of the given type, false otherwise.
+*/
+func oneArg[T any](args []any) (T, bool) {
+	var zero T
+	if len(args) != 1 {
+		return zero, false
+	}
+	v, ok := args[0].(T)
+	return v, ok
+}
+
+/*
+Converts the string to lower case.
+
+Parameters:
+  - args - the string.
+
+Returns:
+  - the converted string,
+  - error, if any.
+*/
+func computeLower(args ...any) (any, error) {
+	s, ok := oneArg[string](args)
+	if !ok {
+		return nil, computeArgError("lower", "one string")
+	}
+	return strings.ToLower(s), nil
+}
+
+/*
+Converts the string to upper case.
+
+Parameters:
+  - args - the string.
+
+Returns:
+  - the converted string,
+  - error, if any.
+*/
+func computeUpper(args ...any) (any, error) {
+	s, ok := oneArg[string](args)
+	if !ok {
+		return nil, computeArgError("upper", "one string")
+	}
+	return strings.ToUpper(s), nil
+}
+
+/*
+Takes the last element of the string slice, e.g. the name of the file from its path.
+
+Parameters:
+  - args - the slice.
+
+Returns:
+  - the last element, empty string for an empty slice,
+  - error, if any.
+*/
+func computeLast(args ...any) (any, error) {
+	s, ok := oneArg[[]string](args)
+	if !ok {
+		return nil, computeArgError("last", "one string slice")
+	}
+	if len(s) == 0 {
+		return "", nil
+	}
+	return s[len(s)-1], nil
+}
+
+/*
+Takes the year of the time.
+
+Parameters:
+  - args - the time.
+
+Returns:
+  - the year,
+  - error, if any.
+*/
+func computeYear(args ...any) (any, error) {
+	t, ok := oneArg[time.Time](args)
+	if !ok {
+		return nil, computeArgError("year", "one time")
+	}
+	return t.Year(), nil
+}
@@ -0,0 +1,617 @@
+package collection
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// Check of a column of a RamCollection.
+type columnCheck struct {
+	CheckConf
+	col     int
+	min     any // nil if there is no lower bound
+	max     any // nil if there is no upper bound
+	pattern *regexp.Regexp
+}
+
+// Reference of a column of a RamCollection to the records of a collection.
+type reference struct {
+	ReferenceConf
+	from      *RamCollection
+	col       int
+	to        *RamCollection // nil until linked
+	targetCol int            // -1 if the CIds are referenced
+	null      any            // zero value of the column meaning no reference
+}
+
+/*
+Resolves the checks and references of the schema.
+References to this collection are bound, the others have to be linked by Link.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) registerConstraints() error {
+	for _, cc := range ego.param.Checks {
+		check := columnCheck{CheckConf: cc, col: ego.getFieldIndex(cc.Column)}
+		if check.col == -1 {
+			return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Checked column %s not found.", cc.Column))
+		}
+
+		field := ego.param.Fields[check.col]
+		for _, bound := range []struct {
+			fc  FielderConf
+			val *any
+		}{{cc.Min, &check.min}, {cc.Max, &check.max}} {
+			if bound.fc == nil {
+				continue
+			}
+			if reflect.TypeOf(bound.fc) != reflect.TypeOf(field) {
+				return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Wrong type of the bound of column %s.", cc.Column))
+			}
+			*bound.val, _ = fieldValue(bound.fc)
+			if _, ordered := cmpOrderedValues(*bound.val, *bound.val); !ordered {
+				return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Column %s has no order.", cc.Column))
+			}
+		}
+
+		if cc.Pattern != "" {
+			if _, ok := field.(FieldConf[string]); !ok {
+				return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Pattern of non-string column %s.", cc.Column))
+			}
+			var err error
+			if check.pattern, err = regexp.Compile(cc.Pattern); err != nil {
+				return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid pattern of column %s.", cc.Column))
+			}
+		}
+
+		ego.checks = append(ego.checks, check)
+	}
+
+	for _, rc := range ego.param.References {
+		ref := &reference{ReferenceConf: rc, from: ego, col: ego.getFieldIndex(rc.Column), targetCol: -1}
+		if ref.col == -1 || ref.col >= ego.storedCols {
+			return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Referencing column %s not found among the stored columns.", rc.Column))
+		}
+		if rc.OnDelete < ON_DELETE_RESTRICT || rc.OnDelete > ON_DELETE_SET_NULL {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Unknown on-delete action of column %s.", rc.Column))
+		}
+		ref.null, _ = fieldValue(ego.param.Fields[ref.col])
+
+		ego.references = append(ego.references, ref)
+
+		if rc.Collection == "" || rc.Collection == ego.param.Name {
+			if err := ref.bind(ego); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Links the references of the collection to the referenced collections, matched by their names.
+Has to be called before the referencing records are added.
+
+Parameters:
+  - targets - the referenced collections.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) Link(targets ...*RamCollection) error {
+	locks := lockSet{ego: true}
+	for _, target := range targets {
+		locks[target] = true
+	}
+	defer locks.lock()()
+	linkSeq.Add(1)
+
+	for _, target := range targets {
+		linked := false
+		for _, ref := range ego.references {
+			if ref.to != nil || ref.Collection != target.param.Name {
+				continue
+			}
+			if err := ref.bind(target); err != nil {
+				return err
+			}
+			linked = true
+		}
+		if !linked {
+			return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("No unlinked reference to collection %s.", target.param.Name))
+		}
+	}
+
+	return nil
+}
+
+/*
+Binds the reference to the referenced collection and registers it there.
+Has to be called under the write locks of both collections, unless the referencing one is being created.
+
+Parameters:
+  - target - the referenced collection.
+
+Returns:
+  - error, if any.
+*/
+func (ego *reference) bind(target *RamCollection) error {
+	field := ego.from.param.Fields[ego.col]
+
+	if ego.Target == "" {
+		if _, ok := field.(FieldConf[uint64]); !ok {
+			return errors.NewValueError(ego.from, errors.LevelError, fmt.Sprintf("Column %s referencing CIds has to be uint64.", ego.Column))
+		}
+	} else {
+		if ego.targetCol = target.getFieldIndex(ego.Target); ego.targetCol == -1 {
+			return errors.NewNotFoundError(ego.from, errors.LevelError, fmt.Sprintf("Referenced column %s not found.", ego.Target))
+		}
+		if reflect.TypeOf(target.param.Fields[ego.targetCol]) != reflect.TypeOf(field) {
+			return errors.NewValueError(ego.from, errors.LevelError, fmt.Sprintf("Column %s does not match the referenced column %s.", ego.Column, ego.Target))
+		}
+	}
+
+	ego.to = target
+	target.referrers = append(target.referrers, ego)
+
+	return nil
+}
+
+/*
+Creates the query of the referenced records.
+
+Parameters:
+  - v - value of the referencing column.
+
+Returns:
+  - query for the referenced collection.
+*/
+func (ego *reference) targetQuery(v any) QueryConf {
+	if ego.targetCol == -1 {
+		return QueryAtomConf{Value: CId(v.(uint64)), MatchType: IdMatchConf{}}
+	}
+	return QueryAtomConf{Name: ego.Target, Value: v, MatchType: fullmatchConfOf(ego.to.param.Fields[ego.targetCol], ego.Target)}
+}
+
+/*
+Creates the query of the records referencing the given one.
+
+Parameters:
+  - cid - CId of the referenced record,
+  - row - the referenced row.
+
+Returns:
+  - query for the referencing collection.
+*/
+func (ego *reference) referringQuery(cid CId, row []any) QueryConf {
+	var v any = uint64(cid)
+	if ego.targetCol != -1 {
+		v = row[ego.targetCol]
+	}
+	return QueryAtomConf{Name: ego.Column, Value: v, MatchType: fullmatchConfOf(ego.from.param.Fields[ego.col], ego.Column)}
+}
+
+/*
+Checks the values of the row against the checks and references of the collection.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - cid - CId of the row,
+  - row - the row,
+  - batch - CIds of the rows being added together with the row, nil if none.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) checkRow(cid CId, row []any, batch map[CId]bool) error {
+	if ego.unchecked {
+		return nil
+	}
+
+	for _, c := range ego.checks {
+		if !c.holds(row[c.col]) {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Value %v of column %s violates the check.", row[c.col], c.Column))
+		}
+	}
+
+	for _, ref := range ego.references {
+		v := row[ref.col]
+		if cmpFullmatchValues(v, ref.null) == 0 {
+			continue
+		}
+		if ref.to == nil {
+			return errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("Reference of column %s to collection %s not linked.", ref.Column, ref.Collection))
+		}
+
+		if ref.to == ego && ref.targetCol == -1 && (CId(v.(uint64)) == cid || batch[CId(v.(uint64))]) {
+			continue
+		}
+		found, err := ref.to.exists(ref.targetQuery(v))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Record %v referenced by column %s not found.", v, ref.Column))
+		}
+	}
+
+	return nil
+}
+
+/*
+Checks if the value satisfies the check.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - true if it does, false otherwise.
+*/
+func (ego *columnCheck) holds(v any) bool {
+	if ego.min != nil {
+		if c, _ := cmpOrderedValues(v, ego.min); c < 0 {
+			return false
+		}
+	}
+	if ego.max != nil {
+		if c, _ := cmpOrderedValues(v, ego.max); c > 0 {
+			return false
+		}
+	}
+	if ego.pattern != nil && !ego.pattern.MatchString(v.(string)) {
+		return false
+	}
+	if ego.NonEmpty {
+		if rv := reflect.ValueOf(v); (rv.Kind() == reflect.String || rv.Kind() == reflect.Slice) && rv.Len() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Checks that the changed columns of the row are not referenced by any record.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - cid - CId of the row,
+  - row - the current row,
+  - patched - the new row.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) checkTargets(cid CId, row []any, patched []any) error {
+	if ego.unchecked {
+		return nil
+	}
+
+	for _, ref := range ego.referrers {
+		if ref.targetCol == -1 || cmpFullmatchValues(row[ref.targetCol], patched[ref.targetCol]) == 0 {
+			continue
+		}
+		ids, err := ref.referring(cid, row)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			// the reference of the row itself is checked with its new values
+			if ref.from != ego || id != cid {
+				return errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("Value %v of column %s is referenced by collection %s.", row[ref.targetCol], ref.Target, ref.from.param.Name))
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Finds the live records referencing the given one.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - cid - CId of the referenced record,
+  - row - the referenced row.
+
+Returns:
+  - CIds of the referencing records in ascending order,
+  - error, if any.
+*/
+func (ego *reference) referring(cid CId, row []any) ([]CId, error) {
+	ids, err := ego.from.filterQueryEval(ego.referringQuery(cid, row))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]CId, 0, len(ids))
+	for id := range ids {
+		if !ego.from.expired(ego.from.rows[id], now) {
+			out = append(out, id)
+		}
+	}
+	slices.Sort(out)
+
+	return out, nil
+}
+
+// Record of a collection.
+type plannedRow struct {
+	rc  *RamCollection
+	cid CId
+}
+
+// Restrict action of a reference to a deleted record.
+type restriction struct {
+	ref    *reference
+	target plannedRow
+	ids    []CId // the referencing records
+}
+
+// Deletion of records together with the changes caused by the on-delete actions, all collected before any of them is applied.
+type deletePlan struct {
+	deletes    []plannedRow
+	deleted    map[plannedRow]bool
+	nulls      []plannedRow               // records whose references are set to null
+	nullCols   map[plannedRow]map[int]any // columns set to null
+	restricted []restriction
+}
+
+/*
+Creates an empty deletion plan.
+
+Returns:
+  - pointer to the plan.
+*/
+func newDeletePlan() *deletePlan {
+	return &deletePlan{deleted: make(map[plannedRow]bool), nullCols: make(map[plannedRow]map[int]any)}
+}
+
+/*
+Adds the deletion of the record to the plan together with the on-delete actions of the references to it,
+cascades are followed recursively. Nothing is changed yet.
+Has to be called under the locks taken by lockWrite with cascading.
+
+Parameters:
+  - rc - the collection,
+  - cid - CId of the record.
+
+Returns:
+  - error, if any.
+*/
+func (ego *deletePlan) delete(rc *RamCollection, cid CId) error {
+	pr := plannedRow{rc: rc, cid: cid}
+	if ego.deleted[pr] {
+		return nil
+	}
+
+	row, found := rc.rows[cid]
+	if !found {
+		return errors.NewNotFoundError(rc, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
+	}
+	ego.deleted[pr] = true
+	ego.deletes = append(ego.deletes, pr)
+
+	for _, ref := range rc.referrers {
+		ids, err := ref.referring(cid, row)
+		if err != nil {
+			return err
+		}
+
+		switch ref.OnDelete {
+		case ON_DELETE_RESTRICT:
+			ego.restricted = append(ego.restricted, restriction{ref: ref, target: pr, ids: ids})
+		case ON_DELETE_CASCADE:
+			for _, id := range ids {
+				if err := ego.delete(ref.from, id); err != nil {
+					return err
+				}
+			}
+		case ON_DELETE_SET_NULL:
+			for _, id := range ids {
+				r := plannedRow{rc: ref.from, cid: id}
+				cols, found := ego.nullCols[r]
+				if !found {
+					cols = make(map[int]any)
+					ego.nullCols[r] = cols
+					ego.nulls = append(ego.nulls, r)
+				}
+				cols[ref.col] = ref.null
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Applies the plan. The restrict actions, the before-hooks and the checks of all changes are processed first,
+so either all records are changed or none.
+Has to be called under the locks taken by lockWrite with cascading.
+
+Returns:
+  - error, if any.
+*/
+func (ego *deletePlan) apply() error {
+	if err := ego.restriction(); err != nil {
+		return err
+	}
+
+	olds := make([]RecordConf, len(ego.deletes))
+	for i, d := range ego.deletes {
+		var err error
+		if olds[i], err = d.rc.prepareDelete(d.cid); err != nil {
+			return err
+		}
+	}
+
+	nulls := make([]plannedRow, 0, len(ego.nulls))
+	patches := make([]rowPatch, 0, len(ego.nulls))
+	for _, n := range ego.nulls {
+		if ego.deleted[n] {
+			continue
+		}
+		p, err := n.rc.preparePatch(n.cid, ego.nullCols[n], 0)
+		if err != nil {
+			return err
+		}
+		nulls = append(nulls, n)
+		patches = append(patches, p)
+	}
+
+	var journal indexJournal
+	for _, d := range ego.deletes {
+		if err := d.rc.unindex(d.cid, d.rc.rows[d.cid], &journal); err != nil {
+			journal.rollback()
+			return err
+		}
+	}
+	for i, p := range patches {
+		if err := nulls[i].rc.reindex(p.cid, p.row, p.patched, &journal); err != nil {
+			journal.rollback()
+			return err
+		}
+	}
+
+	for i, d := range ego.deletes {
+		d.rc.commitDelete(d.cid, olds[i])
+	}
+	for i, p := range patches {
+		nulls[i].rc.commitPatch(p)
+	}
+
+	return nil
+}
+
+/*
+Checks the restrict actions of the plan.
+
+Returns:
+  - error if any record to be deleted is referenced by a record which is not deleted with it, nil otherwise.
+*/
+func (ego *deletePlan) restriction() error {
+	for _, r := range ego.restricted {
+		for _, id := range r.ids {
+			// records deleted together, e.g. the record itself, do not prevent the deletion
+			if !ego.deleted[plannedRow{rc: r.ref.from, cid: id}] {
+				return errors.NewStateError(r.target.rc, errors.LevelError, fmt.Sprintf("Record with id %d is referenced by collection %s.", r.target.cid, r.ref.from.param.Name))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Order of the creation of the collections, the collections are locked in this order.
+var collectionSeq atomic.Uint64
+
+// Number of the calls of Link, the locks of the collections are retaken if the references changed meanwhile.
+var linkSeq atomic.Uint64
+
+// Locks of more collections, true for the write lock.
+type lockSet map[*RamCollection]bool
+
+/*
+Adds the collection to the set together with the collections consulted or modified by its changes because of the references.
+
+Parameters:
+  - rc - the collection,
+  - write - true to lock the collection for writing,
+  - cascade - true if the records of the collection may be deleted.
+*/
+func (ego lockSet) add(rc *RamCollection, write bool, cascade bool) {
+	if w, found := ego[rc]; found && (w || !write) {
+		return
+	}
+	ego[rc] = write
+	if !write {
+		return
+	}
+
+	rc.mutex.RLock()
+	references, referrers := rc.references, rc.referrers
+	rc.mutex.RUnlock()
+
+	for _, ref := range references {
+		if ref.to != nil {
+			ego.add(ref.to, false, false)
+		}
+	}
+	for _, ref := range referrers {
+		ego.add(ref.from, cascade && ref.OnDelete != ON_DELETE_RESTRICT, cascade)
+	}
+}
+
+/*
+Takes the locks in the order of the creation of the collections,
+so concurrent changes of mutually referencing collections cannot deadlock.
+
+Returns:
+  - function releasing the locks.
+*/
+func (ego lockSet) lock() func() {
+	order := make([]*RamCollection, 0, len(ego))
+	for rc := range ego {
+		order = append(order, rc)
+	}
+	slices.SortFunc(order, func(a, b *RamCollection) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	for _, rc := range order {
+		if ego[rc] {
+			rc.mutex.Lock()
+		} else {
+			rc.mutex.RLock()
+		}
+	}
+
+	return func() {
+		for i := len(order) - 1; i >= 0; i-- {
+			if ego[order[i]] {
+				order[i].mutex.Unlock()
+			} else {
+				order[i].mutex.RUnlock()
+			}
+		}
+	}
+}
+
+/*
+Takes the write lock of the collection together with the read locks of the referenced collections
+and of the referencing ones. If records are to be deleted, the collections modified by the on-delete actions
+are locked for writing, recursively.
+
+Parameters:
+  - cascade - true if records are to be deleted.
+
+Returns:
+  - function releasing the locks.
+*/
+func (ego *RamCollection) lockWrite(cascade bool) func() {
+	for {
+		seq := linkSeq.Load()
+		locks := make(lockSet)
+		locks.add(ego, true, cascade)
+		unlock := ego.mutex.Unlock
+		if len(locks) == 1 {
+			ego.mutex.Lock()
+		} else {
+			unlock = locks.lock()
+		}
+		// a reference linked before the locks were taken may involve collections missing in the set
+		if linkSeq.Load() == seq {
+			return unlock
+		}
+		unlock()
+	}
+}
@@ -0,0 +1,96 @@
+package collection
+
+import (
+	"time"
+)
+
+/*
+Counts the records satisfying the query, without deinterpreting them.
+Queries served by bitmap indexes are counted from the bitmaps directly.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - number of the records,
+  - error, if any.
+*/
+func (ego *RamCollection) Count(q QueryConf) (int, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	if ego.expiryCol == -1 {
+		if bm := bitmapEval(ego, q); bm != nil {
+			return bm.Len(), nil
+		}
+	}
+
+	ids, err := ego.filterQueryEval(q)
+	if err != nil {
+		return 0, err
+	}
+
+	if ego.expiryCol == -1 {
+		return len(ids), nil
+	}
+
+	now := time.Now()
+	count := 0
+	for id := range ids {
+		if !ego.expired(ego.rows[id], now) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+/*
+Checks if any record satisfies the query, without deinterpreting it.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - true if there is such a record, false otherwise,
+  - error, if any.
+*/
+func (ego *RamCollection) Exists(q QueryConf) (bool, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	return ego.exists(q)
+}
+
+/*
+Checks if any record satisfies the query.
+Has to be called under the lock.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - true if there is such a record, false otherwise,
+  - error, if any.
+*/
+func (ego *RamCollection) exists(q QueryConf) (bool, error) {
+	if ego.expiryCol == -1 {
+		if bm := bitmapEval(ego, q); bm != nil {
+			return bm.Len() > 0, nil
+		}
+	}
+
+	ids, err := ego.filterQueryEval(q)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for id := range ids {
+		if !ego.expired(ego.rows[id], now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,359 @@
+package collection
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+/*
+Opaque position within the ordered results of a filter.
+It holds the sort key and the CId of the last row of a page,
+so the next page starts right after it even if rows were added or removed meanwhile.
+*/
+type Cursor string
+
+/*
+Page of the results of a filter.
+Next is empty if there are no more results.
+*/
+type PageConf struct {
+	Records []RecordConf
+	Next    Cursor
+}
+
+// Content of the cursor.
+type cursorConf struct {
+	Sort  string          `json:"sort,omitempty"`
+	Order int             `json:"order"`
+	Key   json.RawMessage `json:"key,omitempty"`
+	Id    CId             `json:"id"`
+}
+
+/*
+Total order of the rows of a filter result.
+Rows are compared by the sort key first, if there is any, then by CId.
+*/
+type rowOrder struct {
+	owner   *RamCollection
+	sort    string
+	col     int         // position of the sort column, -1 if sorted by CId only
+	desc    bool        // descending order of the key
+	tieDesc bool        // descending order of the CIds
+	keys    map[CId]any // sort keys by CId, nil if sorted by CId only
+	conf    FilterArgument
+}
+
+/*
+Orders the results and selects the requested page.
+
+Parameters:
+  - rows - results of the filter,
+  - fa - filter arguments.
+
+Returns:
+  - the page,
+  - order of the results,
+  - true if more results follow the page, false otherwise,
+  - error, if any.
+*/
+func (ego *RamCollection) paginate(rows []rowSnapshot, fa FilterArgument) ([]rowSnapshot, *rowOrder, bool, error) {
+
+	if len(rows) == 0 {
+		return rows, nil, false, nil
+	}
+
+	order, err := ego.rowOrder(rows, fa)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if fa.After != "" {
+		if rows, err = order.after(rows, fa.After); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	start := min(fa.Skip, len(rows))
+	end := len(rows)
+	if (fa.Limit != -1) && (start+fa.Limit) < len(rows) {
+		end = start + fa.Limit
+	}
+
+	// only the rows up to the end of the page have to be ordered
+	return order.sortFirst(rows, end)[start:end], order, end < len(rows), nil
+}
+
+/*
+Filters one page of the records, together with the cursor of the next page.
+Unlike Skip, the cursor keeps its position when rows are added or removed before it.
+
+Parameters:
+  - fa - filter arguments, the cursor of the previous page is given in After.
+
+Returns:
+  - the page,
+  - error, if any.
+*/
+func (ego *RamCollection) FilterPage(fa FilterArgument) (PageConf, error) {
+	rows, err := ego.snapshot(fa.QueryConf, fa.AsOf)
+	if err != nil {
+		return PageConf{}, err
+	}
+
+	page, order, more, err := ego.paginate(rows, fa)
+	if err != nil {
+		return PageConf{}, err
+	}
+
+	out := PageConf{Records: make([]RecordConf, len(page))}
+	for i, r := range page {
+		if out.Records[i], err = ego.DeinterpretRecord(r.row); err != nil {
+			return PageConf{}, err
+		}
+		out.Records[i].Id = r.id
+		out.Records[i].Version = r.version
+	}
+
+	if more && len(page) > 0 {
+		if out.Next, err = order.cursor(page[len(page)-1]); err != nil {
+			return PageConf{}, err
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Determines the order of the rows according to the filter arguments.
+
+Parameters:
+  - rows - rows to order,
+  - fa - filter arguments.
+
+Returns:
+  - the order,
+  - error, if any.
+*/
+func (ego *RamCollection) rowOrder(rows []rowSnapshot, fa FilterArgument) (*rowOrder, error) {
+	order := &rowOrder{owner: ego, col: -1, desc: fa.SortOrder == DESC, conf: fa}
+
+	if len(fa.Sort) > 0 {
+		order.sort = fa.Sort[0]
+		if order.col = ego.getFieldIndex(order.sort); order.col == -1 {
+			return nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Sort column %s not found.", order.sort))
+		}
+		order.keys = make(map[CId]any, len(rows))
+		for _, r := range rows {
+			order.keys[r.id] = fa.Collation.applyValue(r.row[order.col])
+		}
+		return order, nil
+	}
+
+	order.tieDesc = order.desc
+	// results of a fuzzy query are ordered by the distance first
+	if dist := ego.fuzzyDistances(rows, fa.QueryConf); dist != nil {
+		order.keys = make(map[CId]any, len(dist))
+		for id, d := range dist {
+			order.keys[id] = d
+		}
+	}
+
+	return order, nil
+}
+
+/*
+Compares two rows given by their keys and CIds.
+
+Returns:
+  - negative number if the first row goes first, positive number if the second one does, zero if they are the same.
+*/
+func (ego *rowOrder) compareKeys(aKey any, aId CId, bKey any, bId CId) int {
+	if ego.keys != nil {
+		c := cmpFullmatchValues(aKey, bKey)
+		if ego.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if ego.tieDesc {
+		return cmp.Compare(bId, aId)
+	}
+	return cmp.Compare(aId, bId)
+}
+
+/*
+Compares two rows.
+
+Returns:
+  - negative number if the first row goes first, positive number if the second one does, zero if they are the same.
+*/
+func (ego *rowOrder) compare(a rowSnapshot, b rowSnapshot) int {
+	return ego.compareKeys(ego.keys[a.id], a.id, ego.keys[b.id], b.id)
+}
+
+/*
+Finds and orders the first n rows.
+A bounded heap is used if n is small compared to the number of rows.
+
+Parameters:
+  - rows - the rows,
+  - n - number of the rows to order.
+
+Returns:
+  - at least n first rows in the order.
+*/
+func (ego *rowOrder) sortFirst(rows []rowSnapshot, n int) []rowSnapshot {
+	if n*4 >= len(rows) {
+		slices.SortFunc(rows, ego.compare)
+		return rows
+	}
+
+	h := &rowHeap{order: ego, rows: make([]rowSnapshot, 0, n+1)}
+	for _, r := range rows {
+		heap.Push(h, r)
+		if h.Len() > n {
+			heap.Pop(h)
+		}
+	}
+
+	slices.SortFunc(h.rows, ego.compare)
+	return h.rows
+}
+
+// Max-heap of the rows, the last row in the order is on the top.
+type rowHeap struct {
+	order *rowOrder
+	rows  []rowSnapshot
+}
+
+func (ego *rowHeap) Len() int           { return len(ego.rows) }
+func (ego *rowHeap) Less(i, j int) bool { return ego.order.compare(ego.rows[i], ego.rows[j]) > 0 }
+func (ego *rowHeap) Swap(i, j int)      { ego.rows[i], ego.rows[j] = ego.rows[j], ego.rows[i] }
+func (ego *rowHeap) Push(x any)         { ego.rows = append(ego.rows, x.(rowSnapshot)) }
+func (ego *rowHeap) Pop() any {
+	last := ego.rows[len(ego.rows)-1]
+	ego.rows = ego.rows[:len(ego.rows)-1]
+	return last
+}
+
+/*
+Creates the cursor pointing to the row.
+
+Parameters:
+  - r - the row.
+
+Returns:
+  - the cursor,
+  - error, if any.
+*/
+func (ego *rowOrder) cursor(r rowSnapshot) (Cursor, error) {
+	// the raw value is stored, the collation is applied again when decoding
+	var key any = ego.keys[r.id]
+	if ego.col != -1 {
+		key = r.row[ego.col]
+	}
+	return ego.encodeCursor(key, r.id)
+}
+
+/*
+Creates the cursor pointing to the position given by the key and the CId.
+
+Parameters:
+  - key - uncollated value of the sort column, or the fuzzy distance,
+  - id - CId of the row.
+
+Returns:
+  - the cursor,
+  - error, if any.
+*/
+func (ego *rowOrder) encodeCursor(key any, id CId) (Cursor, error) {
+	cc := cursorConf{Sort: ego.sort, Order: ego.conf.SortOrder, Id: id}
+
+	if ego.keys != nil {
+		raw, err := json.Marshal(key)
+		if err != nil {
+			return "", err
+		}
+		cc.Key = raw
+	}
+
+	out, err := json.Marshal(cc)
+	if err != nil {
+		return "", err
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(out)), nil
+}
+
+/*
+Drops the rows up to the one the cursor points to, including it.
+The row itself does not have to exist anymore.
+
+Parameters:
+  - rows - the rows,
+  - c - the cursor.
+
+Returns:
+  - the remaining rows,
+  - error, if any.
+*/
+func (ego *rowOrder) after(rows []rowSnapshot, c Cursor) ([]rowSnapshot, error) {
+	var cc cursorConf
+
+	raw, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err == nil {
+		err = json.Unmarshal(raw, &cc)
+	}
+	if err != nil || cc.Sort != ego.sort || cc.Order != ego.conf.SortOrder || (cc.Key == nil) != (ego.keys == nil) {
+		return nil, errors.NewValueError(ego.owner, errors.LevelError, "Cursor does not belong to the filter.")
+	}
+
+	var key any
+	if ego.keys != nil {
+		if ego.col == -1 {
+			var d int
+			err = json.Unmarshal(cc.Key, &d)
+			key = d
+		} else {
+			key, err = ego.decodeKey(cc.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]rowSnapshot, 0, len(rows))
+	for _, r := range rows {
+		if ego.compareKeys(ego.keys[r.id], r.id, key, cc.Id) > 0 {
+			out = append(out, r)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Decodes the value of the sort column stored in the cursor.
+
+Parameters:
+  - raw - JSON encoded value.
+
+Returns:
+  - the sort key,
+  - error, if any.
+*/
+func (ego *rowOrder) decodeKey(raw []byte) (any, error) {
+	fc, err := decodeField(ego.owner.param.Fields[ego.col], raw)
+	if err != nil {
+		return nil, err
+	}
+	val, _ := fieldValue(fc)
+	return ego.conf.Collation.applyValue(val), nil
+}
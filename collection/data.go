@@ -0,0 +1,106 @@
+package collection
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+/*
+Records of a RamCollection carried by its configuration.
+The records keep their CIds and versions, the revisions of a versioned collection are not carried.
+*/
+type RamDataConf struct {
+	Autoincrement CId          // Last generated CId.
+	Records       []RecordConf // Records ordered by their CIds, with all columns.
+}
+
+/*
+Loads the records into the empty collection.
+The records come from a checked collection, so they are not checked again and no hooks are called.
+Has to be called by the constructor.
+
+Parameters:
+  - data - the records.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) load(data RamDataConf) error {
+	ego.autoincrement = data.Autoincrement
+	if len(data.Records) == 0 {
+		return nil
+	}
+
+	cids := make([]CId, len(data.Records))
+	records := make([][]any, len(data.Records))
+
+	for i, rc := range data.Records {
+		if !rc.Id.ValidP() || rc.Id == CId(MaxUint) {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid id of record %d.", i))
+		}
+		if _, found := ego.rows[rc.Id]; found {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Can not reuse id %d!", rc.Id))
+		}
+		if !ego.validLength(len(rc.Cols)) {
+			return errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Wrong number of columns in record %d.", i))
+		}
+
+		record, err := ego.InterpretRecord(rc)
+		if err != nil {
+			return err
+		}
+
+		version := rc.Version
+		if version == 0 {
+			version = 1
+		}
+
+		ego.rows[rc.Id] = record
+		ego.versions[rc.Id] = version
+		ego.idIndex.Add(nil, rc.Id)
+		ego.addRevision(rc.Id, record)
+		ego.autoincrement = max(ego.autoincrement, rc.Id)
+
+		cids[i] = rc.Id
+		records[i] = record
+	}
+
+	return ego.indexRows(cids, records)
+}
+
+/*
+Serializes RamCollection including its records, so it can be materialized elsewhere by NewRamCollection.
+Expired records not reaped yet are included.
+Serialize itself omits the records, as it is called by the errors raised under the lock
+and the records would end up in their messages.
+
+Returns:
+  - configuration of the Gobject with the records,
+  - error, if any.
+*/
+func (ego *RamCollection) SerializeData() (gonatus.Conf, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	data := RamDataConf{Autoincrement: ego.autoincrement, Records: make([]RecordConf, 0, len(ego.rows))}
+
+	for cid, row := range ego.rows {
+		rec, err := ego.DeinterpretRecord(row)
+		if err != nil {
+			return nil, err
+		}
+		rec.Id = cid
+		rec.Version = ego.versions[cid]
+		data.Records = append(data.Records, rec)
+	}
+
+	slices.SortFunc(data.Records, func(a, b RecordConf) int { return cmp.Compare(a.Id, b.Id) })
+
+	out := ego.param
+	out.Data = data
+	return out, nil
+}
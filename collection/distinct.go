@@ -0,0 +1,251 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// Distinct value of a column with the number of the records holding it.
+type DistinctConf struct {
+	Value FielderConf
+	Count int
+}
+
+// Indexer able to enumerate the values of its column.
+type ramCollectionKeyedIndexer interface {
+	countKeys(accept func(CId) bool, fn func(v any, n int))
+}
+
+/*
+Finds the distinct values of the column among the records satisfying the query, with their frequencies.
+If the column has a fullmatch, bitmap or prefix index (without a collation), the values are taken
+from the keys of the index, the rows themselves are not read.
+The values are ordered by their frequencies, the most frequent first, equally frequent ones by the values.
+
+Parameters:
+  - column - name of the column,
+  - q - the query.
+
+Returns:
+  - the values with their frequencies,
+  - error, if any.
+*/
+func (ego *RamCollection) Distinct(column string, q QueryConf) ([]DistinctConf, error) {
+	col := ego.getFieldIndex(column)
+	if col == -1 {
+		return nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Column %s not found.", column))
+	}
+
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	accept, err := ego.acceptor(q)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]any, 0)
+	counts := make([]int, 0)
+	collect := func(v any, n int) {
+		if n > 0 {
+			values = append(values, v)
+			counts = append(counts, n)
+		}
+	}
+
+	keyed := false
+	for _, idx := range ego.indexes[column] {
+		if kidx, ok := idx.(ramCollectionKeyedIndexer); ok {
+			kidx.countKeys(accept, collect)
+			keyed = true
+			break
+		}
+	}
+
+	if !keyed {
+		groups := make(map[string]int)
+		for cid, row := range ego.rows {
+			if accept != nil && !accept(cid) {
+				continue
+			}
+			encoded, err := json.Marshal(row[col])
+			if err != nil {
+				return nil, err
+			}
+			if i, found := groups[string(encoded)]; found {
+				counts[i]++
+				continue
+			}
+			groups[string(encoded)] = len(values)
+			collect(row[col], 1)
+		}
+	}
+
+	out := make([]DistinctConf, len(values))
+	for i, v := range values {
+		fc, err := ego.DeinterpretField(v, col)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = DistinctConf{Value: fc, Count: counts[i]}
+	}
+	sortDistinct(out)
+
+	return out, nil
+}
+
+/*
+Creates the filter of the CIds of the live records satisfying the query.
+Has to be called under the lock.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - the filter, nil if all records are accepted,
+  - error, if any.
+*/
+func (ego *RamCollection) acceptor(q QueryConf) (func(CId) bool, error) {
+	var accept func(CId) bool
+
+	if qq, ok := q.(QueryAndConf); !ok || len(qq.Context) > 0 {
+		if bm := bitmapEval(ego, q); bm != nil {
+			accept = bm.Contains
+		} else {
+			ids, err := ego.filterQueryEval(q)
+			if err != nil {
+				return nil, err
+			}
+			accept = func(cid CId) bool { return ids[cid] }
+		}
+	}
+
+	if ego.expiryCol == -1 {
+		return accept, nil
+	}
+
+	now := time.Now()
+	return func(cid CId) bool {
+		return (accept == nil || accept(cid)) && !ego.expired(ego.rows[cid], now)
+	}, nil
+}
+
+/*
+Orders the distinct values by their frequencies, the most frequent first, equally frequent ones by the values.
+
+Parameters:
+  - values - the values.
+*/
+func sortDistinct(values []DistinctConf) {
+	slices.SortFunc(values, func(a, b DistinctConf) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		av, _ := fieldValue(a.Value)
+		bv, _ := fieldValue(b.Value)
+		if c, ordered := cmpOrderedValues(av, bv); ordered {
+			return c
+		}
+		// values without an order (slices) by their encodings
+		ae, _ := json.Marshal(av)
+		be, _ := json.Marshal(bv)
+		return strings.Compare(string(ae), string(be))
+	})
+}
+
+/*
+Counts the number of the CIds accepted by the filter.
+
+Parameters:
+  - ids - the CIds,
+  - accept - the filter, nil accepting all.
+
+Returns:
+  - the number.
+*/
+func countAccepted(ids []CId, accept func(CId) bool) int {
+	if accept == nil {
+		return len(ids)
+	}
+	n := 0
+	for _, cid := range ids {
+		if accept(cid) {
+			n++
+		}
+	}
+	return n
+}
+
+/*
+Calls the function for each key of the index with the number of its rows accepted by the filter.
+
+Parameters:
+  - accept - the filter, nil accepting all,
+  - fn - the function.
+*/
+func (ego *fullmatchIndexer[T]) countKeys(accept func(CId) bool, fn func(v any, n int)) {
+	for k, ids := range ego.index {
+		fn(k, countAccepted(ids, accept))
+	}
+}
+
+/*
+Calls the function for each key of the index with the number of its rows accepted by the filter.
+
+Parameters:
+  - accept - the filter, nil accepting all,
+  - fn - the function.
+*/
+func (ego *bitmapIndexer[T]) countKeys(accept func(CId) bool, fn func(v any, n int)) {
+	for k, bm := range ego.index {
+		if accept == nil {
+			fn(k, bm.Len())
+			continue
+		}
+		n := 0
+		bm.ForEach(func(cid CId) {
+			if accept(cid) {
+				n++
+			}
+		})
+		fn(k, n)
+	}
+}
+
+/*
+Calls the function for each value stored in the trie with the number of its rows accepted by the filter.
+
+Parameters:
+  - accept - the filter, nil accepting all,
+  - fn - the function.
+*/
+func (ego *prefixIndexer[T]) countKeys(accept func(CId) bool, fn func(v any, n int)) {
+	var walk func(n *trieNode[T], path []T)
+	walk = func(n *trieNode[T], path []T) {
+		if len(n.cids) > 0 {
+			fn(slices.Clone(path), countAccepted(n.cids, accept))
+		}
+		for e, child := range n.children {
+			walk(child, append(path, e))
+		}
+	}
+	walk(ego.index, make([]T, 0))
+}
+
+/*
+Calls the function for each string stored in the trie with the number of its rows accepted by the filter.
+
+Parameters:
+  - accept - the filter, nil accepting all,
+  - fn - the function.
+*/
+func (ego *stringPrefixIndexer) countKeys(accept func(CId) bool, fn func(v any, n int)) {
+	ego.prefixIndexer.countKeys(accept, func(v any, n int) {
+		fn(string(v.([]rune)), n)
+	})
+}
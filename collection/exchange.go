@@ -0,0 +1,414 @@
+package collection
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/gonatus/streamutil"
+	"github.com/DanielSvub/stream"
+)
+
+const (
+	FORMAT_NDJSON = iota // One JSON object per line, keys are column names.
+	FORMAT_CSV           // Header line with column names, one row per line.
+)
+
+type ImportConf struct {
+	Path    string
+	Format  int
+	Mapping map[string]string // Maps keys (NDJSON) or headers (CSV) of the file to column names, unmapped ones are used as they are.
+	IdKey   string            // Key (or header) holding the CId, empty means the ids are generated.
+	Bulk    bool              // Defers the index maintenance until a batch of rows is loaded.
+	Batch   int               // Number of rows loaded at once in the bulk mode, zero means all of them.
+}
+
+type ExportConf struct {
+	Path   string
+	Format int
+	IdKey  string    // Key (or header) to store the CId under, empty means the ids are not exported.
+	Query  QueryConf // Restricts the exported rows, nil means all rows.
+}
+
+/*
+Row of the imported file which could not be imported.
+*/
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (ego ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", ego.Line, ego.Err.Error())
+}
+
+/*
+Collection able to load many records at once, updating the indexes only at the end.
+*/
+type bulkLoader interface {
+	BulkLoad([]RecordConf) ([]CId, error)
+}
+
+/*
+Single row read from the imported file.
+Values are JSON encoded for NDJSON, plain cells for CSV.
+*/
+type importRow struct {
+	line   int
+	values map[string][]byte
+	err    error
+}
+
+/*
+Imports the records from a NDJSON or CSV file into the collection.
+Values are converted according to the schema of the collection,
+columns missing in the file get zero values.
+Rows which cannot be converted or added are skipped and reported.
+In the bulk mode, the rows are added in batches, so a failure of the addition
+of a batch stops the import, the batches added before it stay imported.
+
+Parameters:
+  - c - target collection,
+  - conf - import configuration.
+
+Returns:
+  - number of imported records,
+  - rows which could not be imported,
+  - error, if any.
+*/
+func Import(c Collection, conf ImportConf) (int, []ImportError, error) {
+
+	var rows <-chan importRow
+	switch conf.Format {
+	case FORMAT_NDJSON:
+		rows = readNdjsonRows(conf.Path)
+	case FORMAT_CSV:
+		file, err := os.Open(conf.Path)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer file.Close()
+		rows = readCsvRows(file)
+	default:
+		return 0, nil, errors.NewMisappError(c, "Unknown file format.")
+	}
+
+	schema := c.Schema()
+	columns := make(map[string]int, len(schema.FieldsNaming))
+	for i, name := range schema.FieldsNaming {
+		columns[name] = i
+	}
+
+	loader, bulk := c.(bulkLoader)
+	bulk = bulk && conf.Bulk
+	batch := make([]RecordConf, 0)
+	imported := 0
+	failed := make([]ImportError, 0)
+
+	for row := range rows {
+
+		if row.err != nil {
+			if row.line == 0 {
+				return imported, failed, row.err
+			}
+			failed = append(failed, ImportError{Line: row.line, Err: row.err})
+			continue
+		}
+
+		rec, err := importRecord(schema, columns, row.values, conf)
+		if err != nil {
+			failed = append(failed, ImportError{Line: row.line, Err: err})
+			continue
+		}
+
+		if bulk {
+			batch = append(batch, rec)
+			if len(batch) == conf.Batch {
+				if _, err := loader.BulkLoad(batch); err != nil {
+					return imported, failed, err
+				}
+				imported += len(batch)
+				batch = batch[:0]
+			}
+			continue
+		}
+
+		if _, err := c.AddRecord(rec); err != nil {
+			failed = append(failed, ImportError{Line: row.line, Err: err})
+			continue
+		}
+		imported++
+	}
+
+	if bulk && len(batch) > 0 {
+		if _, err := loader.BulkLoad(batch); err != nil {
+			return imported, failed, err
+		}
+		imported += len(batch)
+	}
+
+	return imported, failed, nil
+}
+
+/*
+Converts a row read from the file to a record.
+
+Parameters:
+  - schema - schema of the target collection,
+  - columns - positions of the columns by name,
+  - values - values of the row by key,
+  - conf - import configuration.
+
+Returns:
+  - the record,
+  - error, if any.
+*/
+func importRecord(schema SchemaConf, columns map[string]int, values map[string][]byte, conf ImportConf) (RecordConf, error) {
+	rec := RecordConf{Cols: make([]FielderConf, len(schema.Fields))}
+	copy(rec.Cols, schema.Fields)
+
+	for key, raw := range values {
+
+		if key == conf.IdKey {
+			id, err := strconv.ParseUint(string(raw), 10, 64)
+			if err != nil {
+				return RecordConf{}, fmt.Errorf("invalid id %q", raw)
+			}
+			rec.Id = CId(id)
+			continue
+		}
+
+		name := key
+		if mapped, found := conf.Mapping[key]; found {
+			name = mapped
+		}
+
+		col, found := columns[name]
+		if !found {
+			continue
+		}
+
+		if conf.Format == FORMAT_CSV {
+			if len(raw) == 0 {
+				continue
+			}
+			switch schema.Fields[col].(type) {
+			case FieldConf[string]:
+				rec.Cols[col] = FieldConf[string]{Value: string(raw)}
+				continue
+			case FieldConf[time.Time]:
+				raw, _ = json.Marshal(string(raw))
+			}
+		}
+
+		field, err := decodeField(schema.Fields[col], raw)
+		if err != nil {
+			return RecordConf{}, fmt.Errorf("column %s: %w", name, err)
+		}
+		rec.Cols[col] = field
+	}
+
+	return rec, nil
+}
+
+/*
+Reads the rows of a NDJSON file.
+
+Parameters:
+  - path - path to the file.
+
+Returns:
+  - channel of the rows, closed at the end of the file.
+*/
+func readNdjsonRows(path string) <-chan importRow {
+	out := make(chan importRow)
+
+	go func() {
+		defer close(out)
+		input := streamutil.NewNdjsonInput[map[string]json.RawMessage](path)
+		for line := 1; ; line++ {
+			value, valid, err := input.Get()
+			if !valid {
+				if err != nil {
+					out <- importRow{err: err}
+				}
+				return
+			}
+			if err != nil {
+				out <- importRow{line: line, err: err}
+				continue
+			}
+			values := make(map[string][]byte, len(value))
+			for k, v := range value {
+				values[k] = v
+			}
+			out <- importRow{line: line, values: values}
+		}
+	}()
+
+	return out
+}
+
+/*
+Reads the rows of a CSV file. The first line has to be a header.
+
+Parameters:
+  - r - source of the data.
+
+Returns:
+  - channel of the rows, closed at the end of the file.
+*/
+func readCsvRows(r io.Reader) <-chan importRow {
+	out := make(chan importRow)
+
+	go func() {
+		defer close(out)
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+
+		header, err := reader.Read()
+		if err != nil {
+			if err != io.EOF {
+				out <- importRow{err: err}
+			}
+			return
+		}
+
+		for {
+			cells, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				perr, ok := err.(*csv.ParseError)
+				if !ok {
+					out <- importRow{err: err}
+					return
+				}
+				out <- importRow{line: perr.Line, err: err}
+				continue
+			}
+			line, _ := reader.FieldPos(0)
+			if len(cells) != len(header) {
+				out <- importRow{line: line, err: fmt.Errorf("expected %d cells, got %d", len(header), len(cells))}
+				continue
+			}
+			values := make(map[string][]byte, len(cells))
+			for i, cell := range cells {
+				values[header[i]] = []byte(cell)
+			}
+			out <- importRow{line: line, values: values}
+		}
+	}()
+
+	return out
+}
+
+/*
+Exports the records of the collection into a NDJSON or CSV file, ordered by CId.
+An existing file is overwritten.
+
+Parameters:
+  - c - source collection,
+  - conf - export configuration.
+
+Returns:
+  - error, if any.
+*/
+func Export(c Collection, conf ExportConf) error {
+
+	schema := c.Schema()
+
+	s, err := c.Filter(FilterArgument{QueryConf: queryOrAll(conf.Query), Limit: NO_LIMIT})
+	if err != nil {
+		return err
+	}
+
+	switch conf.Format {
+
+	case FORMAT_NDJSON:
+		ts := stream.NewTransformer(func(rec RecordConf) map[string]any {
+			obj := make(map[string]any, len(rec.Cols)+1)
+			if conf.IdKey != "" {
+				obj[conf.IdKey] = rec.Id
+			}
+			for i, fc := range rec.Cols {
+				obj[schema.FieldsNaming[i]], _ = fieldValue(fc)
+			}
+			return obj
+		})
+		ndo := streamutil.NewNdjsonOutput[map[string]any](conf.Path, streamutil.FileWrite)
+		s.Pipe(ts).(stream.Producer[map[string]any]).Pipe(ndo)
+		return ndo.Run(nil)
+
+	case FORMAT_CSV:
+		file, err := os.Create(conf.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		writer := csv.NewWriter(file)
+		header := schema.FieldsNaming
+		if conf.IdKey != "" {
+			header = append([]string{conf.IdKey}, header...)
+		}
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+
+		err = s.ForEach(func(rec RecordConf) error {
+			cells := make([]string, 0, len(header))
+			if conf.IdKey != "" {
+				cells = append(cells, strconv.FormatUint(uint64(rec.Id), 10))
+			}
+			for _, fc := range rec.Cols {
+				cell, err := encodeCsvCell(fc)
+				if err != nil {
+					return err
+				}
+				cells = append(cells, cell)
+			}
+			return writer.Write(cells)
+		})
+		if err != nil {
+			return err
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return errors.NewMisappError(c, "Unknown file format.")
+	}
+}
+
+/*
+Encodes the value of the field as a CSV cell.
+Strings are stored as they are, times in RFC 3339 format, other values as JSON.
+
+Parameters:
+  - fc - the field.
+
+Returns:
+  - the cell,
+  - error, if any.
+*/
+func encodeCsvCell(fc FielderConf) (string, error) {
+	val, _ := fieldValue(fc)
+
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	default:
+		out, err := json.Marshal(v)
+		return string(out), err
+	}
+}
@@ -0,0 +1,121 @@
+package collection
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+/*
+Checks if the row has expired at the given time.
+Rows with zero time in the expiry column never expire.
+
+Parameters:
+  - row - values of the row,
+  - now - the point in time.
+
+Returns:
+  - True, if the row has expired, false otherwise.
+*/
+func (ego *RamCollection) expired(row []any, now time.Time) bool {
+	if ego.expiryCol == -1 {
+		return false
+	}
+
+	t := row[ego.expiryCol].(time.Time)
+	if t.IsZero() {
+		return false
+	}
+
+	return !t.Add(ego.param.TTL).After(now)
+}
+
+/*
+Removes all expired records from the RamCollection and from the lookup indexes,
+together with the on-delete actions of the references to them.
+Expired records are hidden from the queries even before they are removed.
+Records still referenced through a restricting reference are kept.
+A record which fails to be removed, e.g. because of a hook, is skipped and the others are removed anyway.
+
+Returns:
+  - Number of removed records,
+  - errors of the skipped records joined, if any.
+*/
+func (ego *RamCollection) Reap() (int, error) {
+	if ego.expiryCol == -1 {
+		return 0, nil
+	}
+
+	defer ego.lockWrite(true)()
+
+	now := time.Now()
+	expired := make([]CId, 0)
+	for cid, row := range ego.rows {
+		if ego.expired(row, now) {
+			expired = append(expired, cid)
+		}
+	}
+	slices.Sort(expired)
+
+	count := 0
+	var errs []error
+	for _, cid := range expired {
+		// the record may have been deleted meanwhile by an on-delete cascade of a previous one
+		if _, found := ego.rows[cid]; !found {
+			continue
+		}
+		plan := newDeletePlan()
+		if err := plan.delete(ego, cid); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if plan.restriction() != nil {
+			continue
+		}
+		if err := plan.apply(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		count++
+	}
+
+	return count, errors.Join(errs...)
+}
+
+/*
+Starts the periodic removal of the expired records.
+*/
+func (ego *RamCollection) startReaper() {
+	stop := make(chan struct{})
+	ego.stopReaper = stop
+	ticker := time.NewTicker(ego.param.ReapInterval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := ego.Reap(); err != nil {
+					ego.Log().Error("Reaping of expired records failed.", "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+/*
+Stops the background activity of the RamCollection.
+The collection stays usable, expired records are just not removed automatically anymore.
+Closing the collection more times is harmless.
+*/
+func (ego *RamCollection) Close() {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	if ego.stopReaper != nil {
+		close(ego.stopReaper)
+		ego.stopReaper = nil
+	}
+}
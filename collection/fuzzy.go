@@ -0,0 +1,240 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+/*
+Computes the edit distance of two strings.
+With transpositions, the optimal string alignment variant of the Damerau distance is used.
+
+Parameters:
+  - a, b - the strings,
+  - transpositions - true if a swap of adjacent characters is a single edit.
+
+Returns:
+  - the distance.
+*/
+func editDistance(a []rune, b []rune, transpositions bool) int {
+	prevprev := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	row := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		nextEditRow(row, prev, prevprev, a[i-1], b, i > 1 && transpositions, a[max(i-2, 0)])
+		prevprev, prev, row = prev, row, prevprev
+	}
+
+	return prev[len(b)]
+}
+
+/*
+Computes the next row of the edit distance matrix.
+
+Parameters:
+  - row - the computed row,
+  - prev - the previous row,
+  - prevprev - the row before the previous one,
+  - c - character of the row,
+  - target - the string of the columns,
+  - transpositions - true if the transposition may end at this row,
+  - prevChar - character of the previous row.
+*/
+func nextEditRow(row []int, prev []int, prevprev []int, c rune, target []rune, transpositions bool, prevChar rune) {
+	row[0] = prev[0] + 1
+
+	for j := 1; j < len(row); j++ {
+		cost := 1
+		if target[j-1] == c {
+			cost = 0
+		}
+		row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+		if transpositions && j > 1 && c == target[j-2] && prevChar == target[j-1] {
+			row[j] = min(row[j], prevprev[j-2]+1)
+		}
+	}
+}
+
+/*
+Searches the trie for the values within the edit distance from the target.
+Subtrees are pruned as soon as no cell of the row is within the distance.
+
+Parameters:
+  - n - current node,
+  - target - the searched string,
+  - fm - configuration of the match,
+  - prev - row of the parent node,
+  - prevprev - row of the grandparent node,
+  - prevChar - character of the parent node,
+  - depth - depth of the current node,
+  - out - found CIds.
+*/
+func fuzzyTrieSearch(n *trieNode[rune], target []rune, fm FuzzyMatchConf, prev []int, prevprev []int, prevChar rune, depth int, out CIdSet) {
+	for c, child := range n.children {
+		row := make([]int, len(target)+1)
+		nextEditRow(row, prev, prevprev, c, target, depth > 0 && fm.Transpositions, prevChar)
+
+		if row[len(target)] <= fm.MaxDistance {
+			for _, cid := range child.cids {
+				out[cid] = true
+			}
+		}
+
+		if slices.Min(row) <= fm.MaxDistance {
+			fuzzyTrieSearch(child, target, fm, row, prev, c, depth+1, out)
+		}
+	}
+}
+
+/*
+Evaluates the fuzzy query atom.
+The trie of the prefix index of the column is searched if present, otherwise all rows are compared.
+Has to be called under the lock.
+
+Parameters:
+  - col - position of the searched column,
+  - q - the query atom,
+  - fm - configuration of the match.
+
+Returns:
+  - CId set of rows satisfying the query,
+  - error, if any.
+*/
+func (ego *RamCollection) fuzzyEval(col int, q QueryAtomConf, fm FuzzyMatchConf) (CIdSet, error) {
+	if _, isString := ego.param.Fields[col].(FieldConf[string]); !isString {
+		return nil, errors.NewMisappError(ego, fmt.Sprintf("Column %s is not a string column.", q.Name))
+	}
+
+	value, ok := q.Value.(string)
+	if !ok {
+		return nil, errors.NewValueError(ego, errors.LevelError, "Searched value has to be a string.")
+	}
+	target := []rune(value)
+
+	out := make(CIdSet)
+
+	if indexer, ok := ego.getIndex(QueryAtomConf{Name: q.Name, MatchType: PrefixIndexConf[string]{}}).(*stringPrefixIndexer); ok {
+		first := make([]int, len(target)+1)
+		for j := range first {
+			first[j] = j
+		}
+		if len(target) <= fm.MaxDistance {
+			for _, cid := range indexer.index.cids {
+				out[cid] = true
+			}
+		}
+		fuzzyTrieSearch(indexer.index, target, fm, first, first, 0, 0, out)
+		return out, nil
+	}
+
+	for cid, row := range ego.rows {
+		if editDistance([]rune(row[col].(string)), target, fm.Transpositions) <= fm.MaxDistance {
+			out[cid] = true
+		}
+	}
+	return out, nil
+}
+
+// Fuzzy atom of a query resolved against the schema.
+type fuzzyTarget struct {
+	col    int
+	target []rune
+	fm     FuzzyMatchConf
+}
+
+/*
+Finds the fuzzy atoms of the query, including those nested in conjunctions and disjunctions.
+Negated atoms and the atoms of implications do not rank the results.
+
+Parameters:
+  - q - the query,
+  - naming - names of the columns.
+
+Returns:
+  - the atoms.
+*/
+func fuzzyTargets(q QueryConf, naming []string) []fuzzyTarget {
+	switch v := q.(type) {
+	case QueryAtomConf:
+		fm, isFuzzy := v.MatchType.(FuzzyMatchConf)
+		value, isString := v.Value.(string)
+		col := slices.Index(naming, v.Name)
+		if isFuzzy && isString && col != -1 {
+			return []fuzzyTarget{{col: col, target: []rune(value), fm: fm}}
+		}
+	case QueryAndConf:
+		return fuzzyContextTargets(v.Context, naming)
+	case QueryOrConf:
+		return fuzzyContextTargets(v.Context, naming)
+	}
+	return nil
+}
+
+/*
+Finds the fuzzy atoms of the subqueries.
+
+Parameters:
+  - context - the subqueries,
+  - naming - names of the columns.
+
+Returns:
+  - the atoms.
+*/
+func fuzzyContextTargets(context []QueryConf, naming []string) []fuzzyTarget {
+	var out []fuzzyTarget
+	for _, q := range context {
+		out = append(out, fuzzyTargets(q, naming)...)
+	}
+	return out
+}
+
+/*
+Computes the rank of a record, the smallest edit distance of its values from the targets of the fuzzy atoms.
+
+Parameters:
+  - targets - the fuzzy atoms,
+  - value - function returning the value of the column of the record.
+
+Returns:
+  - the rank.
+*/
+func fuzzyRank(targets []fuzzyTarget, value func(int) any) int {
+	rank := -1
+	for _, t := range targets {
+		s, _ := value(t.col).(string)
+		if d := editDistance([]rune(s), t.target, t.fm.Transpositions); rank == -1 || d < rank {
+			rank = d
+		}
+	}
+	return rank
+}
+
+/*
+Computes the ranks of the rows, if the query contains any fuzzy atom.
+
+Parameters:
+  - rows - the rows,
+  - q - the query.
+
+Returns:
+  - ranks of the rows by CId, nil if the query is not fuzzy.
+*/
+func (ego *RamCollection) fuzzyDistances(rows []rowSnapshot, q QueryConf) map[CId]int {
+	targets := fuzzyTargets(q, ego.param.FieldsNaming)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	out := make(map[CId]int, len(rows))
+	for _, r := range rows {
+		out[r.id] = fuzzyRank(targets, func(col int) any { return r.row[col] })
+	}
+	return out
+}
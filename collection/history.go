@@ -0,0 +1,248 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/stream"
+)
+
+// Single state of a record in a versioned collection.
+type revision struct {
+	time time.Time
+	row  []any // nil if the record was deleted
+}
+
+// Revision of a record in the log of the changes of the collection.
+type change struct {
+	time time.Time
+	cid  CId
+}
+
+/*
+Revision of a record streamed by History.
+For deleted records, Cols is nil and Deleted is set.
+*/
+type RevisionConf struct {
+	RecordConf
+	Time    time.Time
+	Deleted bool
+}
+
+/*
+Stores a new revision of the record, if the collection is versioned,
+and appends it to the replication log, if the collection is replicated.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the record,
+  - row - new state of the row, nil for a deletion.
+*/
+func (ego *RamCollection) addRevision(cid CId, row []any) {
+	if ego.oplog != nil {
+		ego.oplog.append(cid, row, ego.versions[cid])
+	}
+	if !ego.param.Versioned {
+		return
+	}
+
+	now := time.Now()
+	ego.history[cid] = append(ego.history[cid], revision{time: now, row: row})
+	ego.changes = append(ego.changes, change{time: now, cid: cid})
+	if ego.param.Retention > 0 {
+		ego.prune(now.Add(-ego.param.Retention))
+	}
+}
+
+/*
+Drops the revisions not needed for the states since the given time.
+The last revision of each record before the time is kept, it is the state of the record at the time.
+Has to be called under the write lock.
+
+Parameters:
+  - horizon - the oldest time whose state is kept.
+*/
+func (ego *RamCollection) prune(horizon time.Time) {
+	for len(ego.changes) > 0 && ego.changes[0].time.Before(horizon) {
+		cid := ego.changes[0].cid
+		ego.changes = ego.changes[1:]
+
+		revs, found := ego.history[cid]
+		if !found {
+			continue
+		}
+		for len(revs) > 1 && revs[1].time.Before(horizon) {
+			revs = revs[1:]
+		}
+		if len(revs) == 1 && revs[0].row == nil && revs[0].time.Before(horizon) {
+			delete(ego.history, cid)
+		} else {
+			ego.history[cid] = revs
+		}
+	}
+}
+
+/*
+Streams the retained revisions of the record, from the oldest one.
+
+Parameters:
+  - cid - CId of the record.
+
+Returns:
+  - stream of the revisions,
+  - error, if any.
+*/
+func (ego *RamCollection) History(cid CId) (stream.Producer[RevisionConf], error) {
+	if !ego.param.Versioned {
+		return nil, errors.NewMisappError(ego, "The collection is not versioned.")
+	}
+
+	ego.mutex.RLock()
+	revs, found := ego.history[cid]
+	revs = revs[:len(revs):len(revs)]
+	ego.mutex.RUnlock()
+
+	if !found {
+		return nil, errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
+	}
+
+	sbuf := stream.NewChanneledInput[RevisionConf](0)
+
+	go func() {
+		for _, rev := range revs {
+			out := RevisionConf{RecordConf: RecordConf{Id: cid}, Time: rev.time, Deleted: rev.row == nil}
+			if rev.row != nil {
+				rec, err := ego.DeinterpretRecord(rev.row)
+				if err != nil {
+					panic(err)
+				}
+				out.Cols = rec.Cols
+			}
+			if _, err := sbuf.Write(out); err != nil {
+				panic(err)
+			}
+		}
+		sbuf.Close()
+	}()
+
+	return sbuf, nil
+}
+
+// State of a collection at a point in time.
+type pastState struct {
+	current *RamCollection
+	changed CIdSet         // records changed since the time, nil for the current state
+	past    *RamCollection // unversioned collection with the states of the changed records at the time
+}
+
+/*
+Reconstructs the collection as it was at the given time.
+The records not changed since the time are taken from the collection itself, with its indexes,
+only the changed ones are reconstructed from their revisions.
+Has to be called under the read lock.
+
+Parameters:
+  - t - the point in time, zero means now.
+
+Returns:
+  - the state,
+  - error, if any.
+*/
+func (ego *RamCollection) asOf(t time.Time) (*pastState, error) {
+	if t.IsZero() {
+		return &pastState{current: ego}, nil
+	}
+	if !ego.param.Versioned {
+		return nil, errors.NewMisappError(ego, "The collection is not versioned.")
+	}
+	if ego.param.Retention > 0 && t.Before(time.Now().Add(-ego.param.Retention)) {
+		return nil, errors.NewValueError(ego, errors.LevelWarning, fmt.Sprintf("The states before %v are not retained.", ego.param.Retention))
+	}
+
+	conf := ego.param
+	conf.Versioned = false
+	conf.Indexes = nil
+	conf.ReapInterval = 0
+	// the rows are given by the revisions, they have been checked already
+	conf.Checks = nil
+	conf.References = nil
+
+	state := &pastState{current: ego, changed: make(CIdSet), past: NewRamCollection(conf)}
+
+	first, _ := slices.BinarySearchFunc(ego.changes, t, func(c change, t time.Time) int {
+		if c.time.After(t) {
+			return 1
+		}
+		return -1
+	})
+	for _, c := range ego.changes[first:] {
+		if state.changed[c.cid] {
+			continue
+		}
+		state.changed[c.cid] = true
+
+		var row []any
+		for _, rev := range ego.history[c.cid] {
+			if rev.time.After(t) {
+				break
+			}
+			row = rev.row
+		}
+		if row != nil {
+			state.past.rows[c.cid] = row
+			state.past.idIndex.Add(nil, c.cid)
+		}
+	}
+
+	return state, nil
+}
+
+/*
+Evaluates the query in the state.
+
+Parameters:
+  - q - Query.
+
+Returns:
+  - CIds of the matching records,
+  - error, if any.
+*/
+func (ego *pastState) filterQueryEval(q QueryConf) (CIdSet, error) {
+	ids, err := ego.current.filterQueryEval(q)
+	if err != nil || ego.changed == nil {
+		return ids, err
+	}
+
+	past, err := ego.past.filterQueryEval(q)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(CIdSet, len(ids)+len(past))
+	for id := range ids {
+		if !ego.changed[id] {
+			out[id] = true
+		}
+	}
+	for id := range past {
+		out[id] = true
+	}
+	return out, nil
+}
+
+/*
+Parameters:
+  - cid - CId of the record.
+
+Returns:
+  - the row in the state,
+  - version of the row, zero for the reconstructed rows.
+*/
+func (ego *pastState) row(cid CId) ([]any, uint64) {
+	if ego.changed[cid] {
+		return ego.past.rows[cid], 0
+	}
+	return ego.current.rows[cid], ego.current.versions[cid]
+}
@@ -0,0 +1,214 @@
+package collection
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// Events of the mutation hooks.
+const (
+	BEFORE_ADD = iota
+	AFTER_ADD
+	BEFORE_EDIT
+	AFTER_EDIT
+	BEFORE_DELETE
+	AFTER_DELETE
+)
+
+/*
+Mutation of a record passed to the hooks.
+Before-hooks may modify the new state of an added or edited record, its Id is kept.
+*/
+type MutationConf struct {
+	Event int
+	Old   RecordConf // State before the mutation, empty for additions.
+	New   RecordConf // State after the mutation, empty for deletions.
+}
+
+/*
+Function called on a mutation of a record.
+An error of a before-hook vetoes the mutation, errors of after-hooks are logged, as the mutation is already done.
+*/
+type MutationHook func(m *MutationConf) error
+
+// Identifier of a registered hook, unique within the collection.
+type HookId uint64
+
+// Hook registered for an event.
+type registeredHook struct {
+	id   HookId
+	hook MutationHook
+}
+
+/*
+Registers the hook of the mutation event.
+The hooks are called in the order of their registration, under the write lock of the collection,
+so they must not access the collection itself.
+Hooks are called on all mutations of the records, including the reaping of the expired ones.
+
+Parameters:
+  - event - the event (BEFORE_ADD, AFTER_ADD, ...),
+  - hook - the hook.
+
+Returns:
+  - identifier of the hook, for its removal,
+  - error, if any.
+*/
+func (ego *RamCollection) AddHook(event int, hook MutationHook) (HookId, error) {
+	if event < BEFORE_ADD || event > AFTER_DELETE {
+		return 0, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Unknown mutation event %d.", event))
+	}
+
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	ego.hookSeq++
+	ego.hooks[event] = append(ego.hooks[event], registeredHook{id: ego.hookSeq, hook: hook})
+	return ego.hookSeq, nil
+}
+
+/*
+Removes the registered hook. It must not be called from a hook of the collection.
+
+Parameters:
+  - id - identifier of the hook.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) RemoveHook(id HookId) error {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	for event, hooks := range ego.hooks {
+		for i, h := range hooks {
+			if h.id == id {
+				ego.hooks[event] = slices.Delete(hooks, i, i+1)
+				return nil
+			}
+		}
+	}
+
+	return errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Hook %d not found.", id))
+}
+
+/*
+Checks if any hook of the events is registered.
+Has to be called under the lock.
+
+Parameters:
+  - events - the events.
+
+Returns:
+  - true if there is a hook, false otherwise.
+*/
+func (ego *RamCollection) hooked(events ...int) bool {
+	for _, event := range events {
+		if len(ego.hooks[event]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Converts the row to the record passed to the hooks.
+Has to be called under the lock.
+
+Parameters:
+  - cid - CId of the row,
+  - row - the row.
+
+Returns:
+  - the record,
+  - error, if any.
+*/
+func (ego *RamCollection) hookRecord(cid CId, row []any) (RecordConf, error) {
+	rec, err := ego.DeinterpretRecord(row)
+	if err != nil {
+		return RecordConf{}, err
+	}
+	rec.Id = cid
+	rec.Version = ego.versions[cid]
+	return rec, nil
+}
+
+/*
+Calls the before-hooks of the mutation.
+Has to be called under the write lock.
+
+Parameters:
+  - m - the mutation, modified by the hooks.
+
+Returns:
+  - error of the first failed hook, vetoing the mutation.
+*/
+func (ego *RamCollection) runBeforeHooks(m *MutationConf) error {
+	cid := m.New.Id
+	for _, h := range ego.hooks[m.Event] {
+		if err := h.hook(m); err != nil {
+			return err
+		}
+	}
+	m.New.Id = cid
+	return nil
+}
+
+/*
+Calls the after-hooks of the mutation, their errors are logged.
+Has to be called under the write lock, after the row has been stored.
+
+Parameters:
+  - event - the event,
+  - cid - CId of the row,
+  - old - state before the mutation, empty for additions.
+*/
+func (ego *RamCollection) runAfterHooks(event int, cid CId, old RecordConf) {
+	if !ego.hooked(event) {
+		return
+	}
+
+	m := MutationConf{Event: event, Old: old}
+	if row, found := ego.rows[cid]; found {
+		var err error
+		if m.New, err = ego.hookRecord(cid, row); err != nil {
+			ego.Log().Error("Record for the hooks cannot be created.", "id", cid, "error", err)
+			return
+		}
+	}
+
+	for _, h := range ego.hooks[event] {
+		if err := h.hook(&m); err != nil {
+			ego.Log().Error("Hook after a mutation failed.", "event", event, "id", cid, "error", err)
+		}
+	}
+}
+
+/*
+Converts the new state of an edited record given by the before-hooks to the patched columns.
+
+Parameters:
+  - rec - the new state.
+
+Returns:
+  - values by the positions of the stored columns,
+  - error, if any.
+*/
+func (ego *RamCollection) hookPatch(rec RecordConf) (map[int]any, error) {
+	if !ego.validLength(len(rec.Cols)) {
+		return nil, errors.NewValueError(ego, errors.LevelWarning, "Wrong number of columns")
+	}
+
+	cols := make(map[int]any, ego.storedCols)
+	for i := 0; i < ego.storedCols; i++ {
+		val, err := ego.InterpretField(rec.Cols[i])
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = val
+	}
+
+	return cols, nil
+}
@@ -0,0 +1,109 @@
+package collection
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"reflect"
+	"time"
+)
+
+// Number of bits of the hash selecting the register, the standard error is 1.04 / sqrt(2^hllPrecision).
+const hllPrecision = 12
+
+/*
+HyperLogLog sketch estimating the number of distinct values.
+*/
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+/*
+Hashes the value of a column, equal values of the same type have equal hashes.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - the hash.
+*/
+func hashValue(v any) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+
+	switch val := v.(type) {
+	case string:
+		h.Write([]byte(val))
+	case time.Time:
+		h.Write(binary.LittleEndian.AppendUint64(buf[:0], uint64(val.Unix())))
+		h.Write(binary.LittleEndian.AppendUint64(buf[:0], uint64(val.Nanosecond())))
+	default:
+		// numbers by their bits, without formatting, the values of a column share the type
+		switch rv := reflect.ValueOf(v); rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			h.Write(binary.LittleEndian.AppendUint64(buf[:0], uint64(rv.Int())))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			h.Write(binary.LittleEndian.AppendUint64(buf[:0], rv.Uint()))
+		case reflect.Float32, reflect.Float64:
+			h.Write(binary.LittleEndian.AppendUint64(buf[:0], math.Float64bits(rv.Float())))
+		default:
+			fmt.Fprintf(h, "%T:%v", v, v)
+		}
+	}
+
+	// FNV is weak in the high bits, so they are mixed (splitmix64 finalizer)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+/*
+Adds the value to the sketch.
+
+Parameters:
+  - v - the value.
+*/
+func (ego *hyperLogLog) add(v any) {
+	x := hashValue(v)
+	reg := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > ego.registers[reg] {
+		ego.registers[reg] = rank
+	}
+}
+
+/*
+Returns:
+  - estimated number of the distinct values added.
+*/
+func (ego *hyperLogLog) estimate() uint64 {
+	m := float64(len(ego.registers))
+	sum := 0.0
+	zeros := 0
+
+	for _, r := range ego.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// linear counting is more precise for small cardinalities
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(est))
+}
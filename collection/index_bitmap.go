@@ -0,0 +1,130 @@
+package collection
+
+import (
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// BITMAP INDEX
+type bitmapIndexer[T comparable] struct {
+	ramCollectionIndexer
+	index map[T]*CIdBitmap
+}
+
+/*
+Creates new bitmapIndexer.
+
+Parameters:
+  - c - Configuration of BitmapIndexer.
+
+Returns:
+  - pointer to a new instance of bitmapIndexer.
+*/
+func bitmapIndexerNew[T comparable](c BitmapIndexConf[T]) *bitmapIndexer[T] {
+	ego := new(bitmapIndexer[T])
+	ego.index = make(map[T]*CIdBitmap)
+
+	return ego
+}
+
+/*
+Searches for rows that full match the parameter <v>.
+
+Parameters:
+  - v - Searched value.
+
+Returns:
+  - CIds of rows that match,
+  - error, if any.
+*/
+func (ego *bitmapIndexer[T]) Get(v any) ([]CId, error) {
+	bm, found := ego.index[v.(T)]
+	if !found {
+		return nil, nil
+	}
+
+	return bm.ToSlice(), nil
+}
+
+/*
+Searches for rows that full match the parameter <v>.
+The returned bitmap is owned by the index, it must not be modified
+and it is valid only until the next change of the index.
+
+Parameters:
+  - v - Searched value.
+
+Returns:
+  - Bitmap of CIds of rows that match.
+*/
+func (ego *bitmapIndexer[T]) bitmap(v any) *CIdBitmap {
+	bm, found := ego.index[v.(T)]
+	if !found {
+		return new(CIdBitmap)
+	}
+
+	return bm
+}
+
+/*
+Serializes bitmapIndexer.
+
+Returns:
+  - Configuration of the Gobject.
+*/
+func (ego *bitmapIndexer[T]) Serialize() gonatus.Conf {
+	return nil
+}
+
+/*
+Adds the row to the bitmap of the value.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *bitmapIndexer[T]) Add(v any, id CId) error {
+	s := v.(T)
+
+	bm, found := ego.index[s]
+	if !found {
+		bm = new(CIdBitmap)
+		ego.index[s] = bm
+	}
+	bm.Add(id)
+
+	return nil
+}
+
+/*
+Removes the row from the bitmap of the value.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *bitmapIndexer[T]) Del(v any, id CId) error {
+	s := v.(T)
+
+	bm, found := ego.index[s]
+	if !found {
+		return errors.NewNotFoundError(ego, errors.LevelWarning, "Index trouble - value not found")
+	}
+
+	if !bm.Contains(id) {
+		return errors.NewNotFoundError(ego, errors.LevelWarning, "Index trouble - row not found within index record")
+	}
+
+	bm.Remove(id)
+	if bm.Len() == 0 {
+		delete(ego.index, s)
+	}
+
+	return nil
+}
@@ -124,6 +124,25 @@ func (ego *fullmatchIndexer[T]) Add(v any, id CId) error {
 	return nil
 }
 
+/*
+Adds many rows at once. Unlike Add, it does not check the uniqueness
+of the ids, so the rows must not be present in the index yet.
+
+Parameters:
+  - vs - Values from the specific column,
+  - ids - CIds of the records, in the same order.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *fullmatchIndexer[T]) addBulk(vs []any, ids []CId) error {
+	for i, v := range vs {
+		s := v.(T)
+		ego.index[s] = append(ego.index[s], ids[i])
+	}
+	return nil
+}
+
 /*
 Removes the row passed by the <ididx> parameter from the row slice.
 
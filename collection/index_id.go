@@ -0,0 +1,127 @@
+package collection
+
+import (
+	"fmt"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// ID INDEX
+type idIndexer struct {
+	ramCollectionIndexer
+	index *CIdBitmap
+}
+
+/*
+Creates new idIndexer.
+
+Returns:
+  - pointer to a new instance of idIndexer.
+*/
+func idIndexerNew() *idIndexer {
+	ego := new(idIndexer)
+	ego.index = new(CIdBitmap)
+
+	return ego
+}
+
+/*
+Searches for the rows with the CIds given by the parameter <v>.
+
+Parameters:
+  - v - CId, []CId or QueryRange[CId].
+
+Returns:
+  - CIds of rows that match, sorted,
+  - error, if any.
+*/
+func (ego *idIndexer) Get(v any) ([]CId, error) {
+	bm := ego.bitmap(v)
+	if bm == nil {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid id query value %v.", v))
+	}
+
+	return bm.ToSlice(), nil
+}
+
+/*
+Searches for the rows with the CIds given by the parameter <v>.
+A zero upper bound of a range means no upper bound.
+
+Parameters:
+  - v - CId, []CId or QueryRange[CId].
+
+Returns:
+  - Bitmap of CIds of rows that match, nil if the value is not valid.
+*/
+func (ego *idIndexer) bitmap(v any) *CIdBitmap {
+	out := new(CIdBitmap)
+
+	switch val := v.(type) {
+	case CId:
+		if ego.index.Contains(val) {
+			out.Add(val)
+		}
+	case []CId:
+		for _, cid := range val {
+			if ego.index.Contains(cid) {
+				out.Add(cid)
+			}
+		}
+	case QueryRange[CId]:
+		higher := val.Higher
+		if higher == 0 {
+			higher = CId(MaxUint)
+		}
+		out = ego.index.Range(val.Lower, higher)
+	default:
+		return nil
+	}
+
+	return out
+}
+
+/*
+Serializes idIndexer.
+
+Returns:
+  - Configuration of the Gobject.
+*/
+func (ego *idIndexer) Serialize() gonatus.Conf {
+	return nil
+}
+
+/*
+Adds the row to the index.
+
+Parameters:
+  - v - ignored, the index holds the CIds only,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *idIndexer) Add(v any, id CId) error {
+	ego.index.Add(id)
+	return nil
+}
+
+/*
+Removes the row from the index.
+
+Parameters:
+  - v - ignored, the index holds the CIds only,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *idIndexer) Del(v any, id CId) error {
+	if !ego.index.Contains(id) {
+		return errors.NewNotFoundError(ego, errors.LevelWarning, "Index trouble - id not found")
+	}
+	ego.index.Remove(id)
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package collection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+const (
+	SUFFIX_MATCH = iota
+	CONTAINS_MATCH
+	GLOB_MATCH
+)
+
+const defaultNgramLength = 3
+
+// Markers of the beginning and the end of the indexed strings, so the n-grams can be anchored.
+const (
+	ngramStart = '\u0002'
+	ngramEnd   = '\u0003'
+)
+
+// NGRAM INDEX
+type ngramIndexer struct {
+	ramCollectionIndexer
+	n     int
+	index map[string]*CIdBitmap
+}
+
+/*
+Creates new ngramIndexer.
+
+Parameters:
+  - c - Configuration of NgramIndexer.
+
+Returns:
+  - pointer to a new instance of ngramIndexer.
+*/
+func ngramIndexerNew(c NgramIndexConf) *ngramIndexer {
+	ego := new(ngramIndexer)
+	ego.n = int(c.N)
+	if ego.n == 0 {
+		ego.n = defaultNgramLength
+	}
+	ego.index = make(map[string]*CIdBitmap)
+
+	return ego
+}
+
+/*
+Splits the text into distinct n-grams.
+Texts shorter than n form a single n-gram.
+
+Parameters:
+  - text - the text,
+  - n - length of the n-grams.
+
+Returns:
+  - the n-grams.
+*/
+func ngrams(text []rune, n int) []string {
+	if len(text) < n {
+		return []string{string(text)}
+	}
+
+	seen := make(map[string]bool, len(text)-n+1)
+	out := make([]string, 0, len(text)-n+1)
+	for i := 0; i+n <= len(text); i++ {
+		gram := string(text[i : i+n])
+		if !seen[gram] {
+			seen[gram] = true
+			out = append(out, gram)
+		}
+	}
+
+	return out
+}
+
+/*
+Splits the indexed value into its anchored n-grams.
+
+Parameters:
+  - v - value from the string column.
+
+Returns:
+  - the n-grams.
+*/
+func (ego *ngramIndexer) valueGrams(v any) []string {
+	text := append([]rune{ngramStart}, []rune(v.(string))...)
+	return ngrams(append(text, ngramEnd), ego.n)
+}
+
+/*
+Splits the pattern into the literal parts which every matching value has to contain.
+
+Parameters:
+  - kind - kind of the match,
+  - pattern - the searched pattern.
+
+Returns:
+  - the literal parts, anchored ones include the start or end marker.
+*/
+func patternSegments(kind int, pattern string) [][]rune {
+	switch kind {
+	case SUFFIX_MATCH:
+		return [][]rune{append([]rune(pattern), ngramEnd)}
+	case CONTAINS_MATCH:
+		return [][]rune{[]rune(pattern)}
+	case GLOB_MATCH:
+		return globLiterals(pattern)
+	default:
+		return nil
+	}
+}
+
+/*
+Splits the glob pattern into the literal parts between the wildcards.
+Parts at the beginning and the end of the pattern are anchored.
+
+Parameters:
+  - pattern - the glob pattern.
+
+Returns:
+  - the literal parts.
+*/
+func globLiterals(pattern string) [][]rune {
+	out := make([][]rune, 0)
+	current := []rune{ngramStart}
+
+	for _, r := range pattern {
+		if r == '*' || r == '?' {
+			out = append(out, current)
+			current = []rune{}
+			continue
+		}
+		current = append(current, r)
+	}
+
+	return append(out, append(current, ngramEnd))
+}
+
+/*
+Finds the rows which may match the pattern. The result has to be verified.
+
+Parameters:
+  - kind - kind of the match,
+  - pattern - the searched pattern.
+
+Returns:
+  - candidate rows, nil if the index cannot narrow the search.
+*/
+func (ego *ngramIndexer) candidates(kind int, pattern string) *CIdBitmap {
+	return ego.segmentCandidates(patternSegments(kind, pattern))
+}
+
+/*
+Finds the rows containing all n-grams of the given literal parts.
+
+Parameters:
+  - segments - literal parts, anchored ones include the start or end marker.
+
+Returns:
+  - candidate rows, nil if all parts are too short to use the index.
+*/
+func (ego *ngramIndexer) segmentCandidates(segments [][]rune) *CIdBitmap {
+	var out *CIdBitmap
+
+	for _, seg := range segments {
+		if len(seg) < ego.n {
+			continue
+		}
+		for _, gram := range ngrams(seg, ego.n) {
+			bm, found := ego.index[gram]
+			if !found {
+				return new(CIdBitmap)
+			}
+			if out == nil {
+				out = bm
+			} else {
+				out = out.Intersect(bm)
+			}
+		}
+	}
+
+	return out
+}
+
+/*
+Searches for rows containing all n-grams of the parameter <v>.
+The result may contain rows which do not contain <v> itself.
+
+Parameters:
+  - v - Searched substring.
+
+Returns:
+  - CIds of rows that may match,
+  - error, if any.
+*/
+func (ego *ngramIndexer) Get(v any) ([]CId, error) {
+	cands := ego.candidates(CONTAINS_MATCH, v.(string))
+	if cands == nil {
+		return nil, errors.NewValueError(ego, errors.LevelWarning, fmt.Sprintf("Substring is shorter than %d characters.", ego.n))
+	}
+	return cands.ToSlice(), nil
+}
+
+/*
+Adds the n-grams of the value to the index.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *ngramIndexer) Add(v any, id CId) error {
+	for _, gram := range ego.valueGrams(v) {
+		bm, found := ego.index[gram]
+		if !found {
+			bm = new(CIdBitmap)
+			ego.index[gram] = bm
+		}
+		bm.Add(id)
+	}
+	return nil
+}
+
+/*
+Removes the n-grams of the value from the index.
+
+Parameters:
+  - v - Value from specific row and column,
+  - id - CId of record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *ngramIndexer) Del(v any, id CId) error {
+	for _, gram := range ego.valueGrams(v) {
+		bm, found := ego.index[gram]
+		if !found || !bm.Contains(id) {
+			return errors.NewNotFoundError(ego, errors.LevelWarning, "Index trouble - row not found within index record")
+		}
+		bm.Remove(id)
+		if bm.Len() == 0 {
+			delete(ego.index, gram)
+		}
+	}
+	return nil
+}
+
+/*
+Serializes ngramIndexer.
+
+Returns:
+  - Configuration of the Gobject.
+*/
+func (ego *ngramIndexer) Serialize() gonatus.Conf {
+	return nil
+}
+
+/*
+Checks if the string matches the pattern.
+
+Parameters:
+  - kind - kind of the match,
+  - pattern - the pattern,
+  - s - the string.
+
+Returns:
+  - true if the string matches, false otherwise.
+*/
+func substringMatch(kind int, pattern string, s string) bool {
+	switch kind {
+	case SUFFIX_MATCH:
+		return strings.HasSuffix(s, pattern)
+	case CONTAINS_MATCH:
+		return strings.Contains(s, pattern)
+	case GLOB_MATCH:
+		return globMatch([]rune(pattern), []rune(s))
+	default:
+		return false
+	}
+}
+
+/*
+Matches the string against the glob pattern.
+On a mismatch, the last star is extended by one character.
+
+Parameters:
+  - pattern - the glob pattern,
+  - s - the string.
+
+Returns:
+  - true if the string matches, false otherwise.
+*/
+func globMatch(pattern []rune, s []rune) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case star != -1:
+			mark++
+			p, i = star+1, mark
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+/*
+Evaluates the suffix, contains or glob query atom.
+Candidates are taken from the n-gram index, if present, and verified.
+Has to be called under the lock.
+
+Parameters:
+  - col - position of the searched column,
+  - q - the query atom,
+  - kind - kind of the match.
+
+Returns:
+  - CId set of rows satisfying the query,
+  - error, if any.
+*/
+func (ego *RamCollection) substringEval(col int, q QueryAtomConf, kind int) (CIdSet, error) {
+	if _, isString := ego.param.Fields[col].(FieldConf[string]); !isString {
+		return nil, errors.NewMisappError(ego, fmt.Sprintf("Column %s is not a string column.", q.Name))
+	}
+
+	pattern, ok := q.Value.(string)
+	if !ok {
+		return nil, errors.NewValueError(ego, errors.LevelError, "Pattern has to be a string.")
+	}
+
+	out := make(CIdSet)
+	check := func(cid CId) {
+		if row, found := ego.rows[cid]; found && substringMatch(kind, pattern, row[col].(string)) {
+			out[cid] = true
+		}
+	}
+
+	if indexer, ok := ego.getIndex(q).(*ngramIndexer); ok {
+		if cands := indexer.candidates(kind, pattern); cands != nil {
+			cands.ForEach(check)
+			return out, nil
+		}
+	}
+
+	for cid := range ego.rows {
+		check(cid)
+	}
+	return out, nil
+}
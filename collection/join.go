@@ -0,0 +1,338 @@
+package collection
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/stream"
+)
+
+const (
+	INNER_JOIN = iota // Pairs of matching rows.
+	LEFT_JOIN         // Pairs of matching rows, left rows without a match are paired with an empty record.
+	SEMI_JOIN         // Left rows having at least one match.
+	ANTI_JOIN         // Left rows having no match.
+)
+
+type JoinConf struct {
+	Type       int
+	LeftOn     []string  // Columns of the left collection.
+	RightOn    []string  // Columns of the right collection, compared pairwise with LeftOn.
+	LeftQuery  QueryConf // Restricts the left side, nil means all rows.
+	RightQuery QueryConf // Restricts the right side, nil means all rows.
+}
+
+/*
+Result of a join. For semi and anti joins, and for left rows
+without a match in a left join, the Right record is empty (its Id is not valid).
+*/
+type JoinedRecordConf struct {
+	Left  RecordConf
+	Right RecordConf
+}
+
+/*
+Collection able to tell whether a fullmatch index exists for its column.
+Used by the join to choose between the index-nested-loop and the hash join.
+*/
+type fullmatchIndexed interface {
+	hasFullmatchIndex(name string) bool
+}
+
+type joinProducer struct {
+	gonatus.Gobject
+	stream.DefaultClosable
+	stream.DefaultProducer[JoinedRecordConf]
+	conf       JoinConf
+	left       stream.Producer[RecordConf]
+	right      Collection
+	leftCols   []int
+	rightCols  []int
+	rightTypes []FielderConf
+	probeCol   int                     // Position in RightOn of the indexed column, -1 for the hash join.
+	table      map[string][]RecordConf // Hashed right side.
+	pending    []JoinedRecordConf
+}
+
+/*
+Joins two collections on equality of the given columns.
+If the right collection has a fullmatch index on one of the join columns
+and no right query is given, the index-nested-loop join is used,
+otherwise the right side is loaded into a hash table.
+The output is ordered by the CId of the left record.
+
+Parameters:
+  - left - left collection,
+  - right - right collection,
+  - jc - configuration of the join.
+
+Returns:
+  - stream of the joined records,
+  - error, if any.
+*/
+func Join(left Collection, right Collection, jc JoinConf) (stream.Producer[JoinedRecordConf], error) {
+
+	if len(jc.LeftOn) == 0 || len(jc.LeftOn) != len(jc.RightOn) {
+		return nil, errors.NewMisappError(left, "Join columns do not pair up.")
+	}
+
+	if jc.Type < INNER_JOIN || jc.Type > ANTI_JOIN {
+		return nil, errors.NewMisappError(left, "Unknown join type.")
+	}
+
+	ego := &joinProducer{conf: jc, right: right, probeCol: -1}
+	ego.DefaultProducer = *stream.NewDefaultProducer[JoinedRecordConf](ego)
+
+	var err error
+	var leftTypes []FielderConf
+	if ego.leftCols, leftTypes, err = columnPositions(left, jc.LeftOn); err != nil {
+		return nil, err
+	}
+	if ego.rightCols, ego.rightTypes, err = columnPositions(right, jc.RightOn); err != nil {
+		return nil, err
+	}
+
+	for i := range leftTypes {
+		if reflect.TypeOf(leftTypes[i]) != reflect.TypeOf(ego.rightTypes[i]) {
+			return nil, errors.NewMisappError(left, fmt.Sprintf("Columns %s and %s differ in type.", jc.LeftOn[i], jc.RightOn[i]))
+		}
+	}
+
+	if idxd, ok := right.(fullmatchIndexed); ok && jc.RightQuery == nil {
+		for i, name := range jc.RightOn {
+			if idxd.hasFullmatchIndex(name) {
+				ego.probeCol = i
+				break
+			}
+		}
+	}
+
+	if ego.probeCol == -1 {
+		if err := ego.buildTable(); err != nil {
+			return nil, err
+		}
+	}
+
+	ego.left, err = left.Filter(FilterArgument{QueryConf: queryOrAll(jc.LeftQuery), Limit: NO_LIMIT})
+	if err != nil {
+		return nil, err
+	}
+
+	return ego, nil
+}
+
+/*
+Finds the positions of the named columns within the schema of the collection.
+
+Parameters:
+  - c - collection,
+  - names - names of the columns.
+
+Returns:
+  - positions of the columns,
+  - field configurations of the columns,
+  - error, if any.
+*/
+func columnPositions(c Collection, names []string) ([]int, []FielderConf, error) {
+	schema := c.Schema()
+	pos := make([]int, len(names))
+	types := make([]FielderConf, len(names))
+
+	for i, name := range names {
+		pos[i] = -1
+		for j, n := range schema.FieldsNaming {
+			if n == name {
+				pos[i] = j
+				types[i] = schema.Fields[j]
+				break
+			}
+		}
+		if pos[i] == -1 {
+			return nil, nil, errors.NewNotFoundError(c, errors.LevelError, fmt.Sprintf("Column %s not found.", name))
+		}
+	}
+
+	return pos, types, nil
+}
+
+/*
+Returns:
+  - the query itself, or a query matching all rows if it is nil.
+*/
+func queryOrAll(q QueryConf) QueryConf {
+	if q == nil {
+		return QueryAndConf{}
+	}
+	return q
+}
+
+/*
+Creates a hashable key from the values of the given columns.
+Keys of equal values are equal regardless of the side of the join.
+
+Parameters:
+  - rec - record,
+  - cols - positions of the columns.
+
+Returns:
+  - the key,
+  - error, if any.
+*/
+func (ego *joinProducer) joinKey(rec RecordConf, cols []int) (string, error) {
+	var sb strings.Builder
+
+	for _, c := range cols {
+		if c >= len(rec.Cols) {
+			return "", errors.NewStateError(ego, errors.LevelError, "Record has too few columns.")
+		}
+		val, ok := fieldValue(rec.Cols[c])
+		if !ok {
+			return "", errors.NewStateError(ego, errors.LevelError, "Unsupported column type.")
+		}
+		if t, isTime := val.(time.Time); isTime {
+			val = t.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(&sb, "%T:%v\x00", val, val)
+	}
+
+	return sb.String(), nil
+}
+
+/*
+Loads the right side of the join into the hash table.
+
+Returns:
+  - error, if any.
+*/
+func (ego *joinProducer) buildTable() error {
+	s, err := ego.right.Filter(FilterArgument{QueryConf: queryOrAll(ego.conf.RightQuery), Limit: NO_LIMIT})
+	if err != nil {
+		return err
+	}
+
+	ego.table = make(map[string][]RecordConf)
+
+	return s.ForEach(func(rec RecordConf) error {
+		key, err := ego.joinKey(rec, ego.rightCols)
+		if err != nil {
+			return err
+		}
+		ego.table[key] = append(ego.table[key], rec)
+		return nil
+	})
+}
+
+/*
+Finds the right records matching the given left record.
+
+Parameters:
+  - rec - left record.
+
+Returns:
+  - matching right records,
+  - error, if any.
+*/
+func (ego *joinProducer) matches(rec RecordConf) ([]RecordConf, error) {
+	key, err := ego.joinKey(rec, ego.leftCols)
+	if err != nil {
+		return nil, err
+	}
+
+	if ego.table != nil {
+		return ego.table[key], nil
+	}
+
+	val, _ := fieldValue(rec.Cols[ego.leftCols[ego.probeCol]])
+	s, err := ego.right.Filter(FilterArgument{
+		QueryConf: QueryAtomConf{
+			Name:      ego.conf.RightOn[ego.probeCol],
+			Value:     val,
+			MatchType: fullmatchConfOf(ego.rightTypes[ego.probeCol], ego.conf.RightOn[ego.probeCol]),
+		},
+		Limit: NO_LIMIT,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := s.Collect()
+	if err != nil {
+		return nil, err
+	}
+
+	// the index covers one column only, the rest has to be checked
+	out := make([]RecordConf, 0, len(candidates))
+	for _, c := range candidates {
+		ckey, err := ego.joinKey(c, ego.rightCols)
+		if err != nil {
+			return nil, err
+		}
+		if ckey == key {
+			out = append(out, c)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Serializes the join.
+
+Returns:
+  - configuration of the join.
+*/
+func (ego *joinProducer) Serialize() gonatus.Conf {
+	return ego.conf
+}
+
+/*
+Acquires the next joined record.
+
+Returns:
+  - the joined record,
+  - true if the record is present, false otherwise,
+  - error, if any.
+*/
+func (ego *joinProducer) Get() (value JoinedRecordConf, valid bool, err error) {
+
+	for len(ego.pending) == 0 {
+
+		var rec RecordConf
+		rec, valid, err = ego.left.Get()
+		if err != nil || !valid {
+			ego.Close()
+			return
+		}
+
+		var found []RecordConf
+		if found, err = ego.matches(rec); err != nil {
+			return JoinedRecordConf{}, false, err
+		}
+
+		switch ego.conf.Type {
+		case INNER_JOIN, LEFT_JOIN:
+			for _, r := range found {
+				ego.pending = append(ego.pending, JoinedRecordConf{Left: rec, Right: r})
+			}
+			if len(found) == 0 && ego.conf.Type == LEFT_JOIN {
+				ego.pending = append(ego.pending, JoinedRecordConf{Left: rec})
+			}
+		case SEMI_JOIN:
+			if len(found) > 0 {
+				ego.pending = append(ego.pending, JoinedRecordConf{Left: rec})
+			}
+		case ANTI_JOIN:
+			if len(found) == 0 {
+				ego.pending = append(ego.pending, JoinedRecordConf{Left: rec})
+			}
+		}
+	}
+
+	value = ego.pending[0]
+	ego.pending = ego.pending[1:]
+	return value, true, nil
+}
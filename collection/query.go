@@ -16,11 +16,31 @@ Returns:
 */
 func (ego *QueryAtomConf) eval(rc *RamCollection) (CIdSet, error) {
 
+	if _, ok := ego.MatchType.(IdMatchConf); ok {
+		rows, err := rc.idIndex.Get(ego.Value)
+		if err != nil {
+			return nil, err
+		}
+		return CIdSetFromSlice(rows), nil
+	}
+
 	idx := rc.getFieldIndex(ego.Name)
 	if idx == -1 {
 		return nil, errors.New("column not found")
 	}
 
+	if kind, ok := substringKindOf(ego.MatchType); ok {
+		return rc.substringEval(idx, *ego, kind)
+	}
+
+	if _, ok := ego.MatchType.(RegexMatchConf); ok {
+		return rc.regexEval(idx, *ego)
+	}
+
+	if fm, ok := ego.MatchType.(FuzzyMatchConf); ok {
+		return rc.fuzzyEval(idx, *ego, fm)
+	}
+
 	indexer := rc.getIndex(*ego)
 
 	var rows []CId
@@ -28,7 +48,10 @@ func (ego *QueryAtomConf) eval(rc *RamCollection) (CIdSet, error) {
 
 	if indexer == nil {
 		pi := rc.primaryIndex
-		if ego.isPrefix(rc, idx) {
+		collation, _ := collationOf(ego.MatchType)
+		if collation != (CollationConf{}) && (ego.isPrefix(rc, idx) || ego.isFullmatch(rc, idx)) {
+			rows, err = rc.collatedScan(idx, ego.Value, collation, ego.isPrefix(rc, idx))
+		} else if ego.isPrefix(rc, idx) {
 			rows, err = pi.getPrefix(rc.primaryValue(*ego, idx))
 		} else if ego.isFullmatch(rc, idx) {
 			rows, err = pi.Get(rc.primaryValue(*ego, idx))
@@ -57,26 +80,32 @@ Returns:
   - error, if any.
 */
 func (ego *QueryAndConf) eval(rc *RamCollection) (CIdSet, error) {
-	accum := make(CIdSet, 0)
+	var accum CIdSet
 	ctxlen := len(ego.QueryContextConf.Context)
 
 	if ctxlen == 0 {
 		return rc.setAllRows(), nil // Returns whole space
 	}
 
-	for i := 0; i < ctxlen; i++ {
-		acc, err := rc.filterQueryEval(QueryConf(ego.QueryContextConf.Context[0]))
-		if i > 0 {
-			accum = accum.Intersect(acc)
-		} else {
-			accum = acc
+	bm, rest := bitmapEvalContext(rc, ego.QueryContextConf.Context, true)
+	if bm != nil {
+		accum = bm.ToSet()
+	}
+
+	for i := 0; i < len(rest); i++ {
+		if accum != nil && len(accum) == 0 {
+			break
 		}
 
+		acc, err := rc.filterQueryEval(rest[i])
 		if err != nil {
 			return nil, err
 		}
-		if len(accum) == 0 {
-			return make(CIdSet), nil
+
+		if accum != nil {
+			accum = accum.Intersect(acc)
+		} else {
+			accum = acc
 		}
 	}
 
@@ -101,17 +130,22 @@ func (ego *QueryOrConf) eval(rc *RamCollection) (CIdSet, error) {
 		return rc.noRowsSet(), nil // Returns empty set
 	}
 
-	for i := 0; i < ctxlen; i++ {
-		acc, err := rc.filterQueryEval(QueryConf(ego.QueryContextConf.Context[i]))
+	bm, rest := bitmapEvalContext(rc, ego.QueryContextConf.Context, false)
+	if bm != nil {
+		accum = bm.ToSet()
+	}
+
+	for i := 0; i < len(rest); i++ {
+		if len(accum) == len(rc.rows) {
+			break
+		}
+
+		acc, err := rc.filterQueryEval(rest[i])
 		if err != nil {
 			return nil, err
 		}
 
 		accum.Merge(acc)
-
-		if len(accum) == len(rc.rows) {
-			break
-		}
 	}
 
 	return accum, nil
@@ -155,3 +189,78 @@ func (ego *QueryImplicationConf) eval(rc *RamCollection) (CIdSet, error) {
 
 	return rws, nil
 }
+
+/*
+Evaluates the query using bitmap indexes only.
+Atoms are served if their column has a bitmap index,
+and- and or-queries if all their subqueries are served.
+
+Parameters:
+  - rc - Ram Collection,
+  - q - Query.
+
+Returns:
+  - Bitmap of rows satisfying the query, nil if the query cannot be served.
+*/
+func bitmapEval(rc *RamCollection, q QueryConf) *CIdBitmap {
+	var bm *CIdBitmap
+	var rest []QueryConf
+
+	switch v := q.(type) {
+	case QueryAtomConf:
+		if _, ok := v.MatchType.(IdMatchConf); ok {
+			return rc.idIndex.bitmap(v.Value)
+		}
+		if rc.getFieldIndex(v.Name) == -1 {
+			return nil
+		}
+		if indexer, ok := rc.getIndex(v).(ramCollectionBitmapIndexer); ok {
+			return indexer.bitmap(v.Value)
+		}
+		return nil
+	case QueryAndConf:
+		bm, rest = bitmapEvalContext(rc, v.Context, true)
+	case QueryOrConf:
+		bm, rest = bitmapEvalContext(rc, v.Context, false)
+	default:
+		return nil
+	}
+
+	if len(rest) > 0 {
+		return nil
+	}
+	return bm
+}
+
+/*
+Evaluates the subqueries which can be served by bitmap indexes and combines their bitmaps.
+
+Parameters:
+  - rc - Ram Collection,
+  - ctx - subqueries,
+  - and - true to intersect the bitmaps, false to unite them.
+
+Returns:
+  - Combined bitmap, nil if no subquery can be served,
+  - subqueries which cannot be served.
+*/
+func bitmapEvalContext(rc *RamCollection, ctx []QueryConf, and bool) (*CIdBitmap, []QueryConf) {
+	var accum *CIdBitmap
+	rest := make([]QueryConf, 0)
+
+	for _, q := range ctx {
+		bm := bitmapEval(rc, q)
+		switch {
+		case bm == nil:
+			rest = append(rest, q)
+		case accum == nil:
+			accum = bm
+		case and:
+			accum = accum.Intersect(bm)
+		default:
+			accum = accum.Merge(bm)
+		}
+	}
+
+	return accum, rest
+}
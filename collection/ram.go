@@ -1,10 +1,10 @@
 package collection
 
 import (
-	"cmp"
 	"fmt"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/DanielSvub/gonatus"
 	"github.com/DanielSvub/gonatus/errors"
@@ -23,20 +23,53 @@ type ramCollectionIndexer interface {
 	Del(any, CId) error
 }
 
+// Indexer able to add many rows at once, the rows have to be new to the index.
+type ramCollectionBulkIndexer interface {
+	addBulk([]any, []CId) error
+}
+
+// Indexer keeping the CIds in bitmaps, so they can be combined without conversions.
+type ramCollectionBitmapIndexer interface {
+	bitmap(any) *CIdBitmap
+}
+
 // RAM COLLECTION IMPL
 type RamCollectionConf struct {
 	SchemaConf
-	MaxMemory uint64
+	MaxMemory    uint64
+	Versioned    bool          // Keeps all revisions of the records.
+	Retention    time.Duration // Age of the oldest past state of a versioned collection kept, zero means all of them.
+	ReapInterval time.Duration // Period of the removal of the expired records, zero means no background removal.
+	Data         RamDataConf   // Records loaded on the creation, filled by SerializeData.
 }
 
 type RamCollection struct {
 	gonatus.Gobject
 	param         RamCollectionConf
 	autoincrement CId
-	rows          map[CId][]any
+	rows          map[CId][]any                     // Rows are never modified in place, so they can be read without locking once obtained.
+	versions      map[CId]uint64                    // Incremented on every edit of the row.
 	indexes       map[string][]ramCollectionIndexer // FIXME: make array of indexes for fields not one index as max
 	primaryIndex  *primaryIndexer
+	idIndex       *idIndexer // implicit index of the CIds, present in every collection
+	computed      []computedColumn
+	storedCols    int // number of the columns preceding the computed ones
+	checks        []columnCheck
+	references    []*reference // references of the columns of this collection
+	referrers     []*reference // references to the records of this collection
+	unchecked     bool         // the rows are not checked, they come from a checked collection
+	hooks         [AFTER_DELETE + 1][]registeredHook
+	hookSeq       HookId // identifier of the last registered hook
+	history       map[CId][]revision
+	changes       []change // revisions of all records in the order of their times
+	expiryCol     int      // -1 if the records do not expire
+	stopReaper    chan struct{}
+	oplog         *oplog         // log of the mutations shipped to the replicas, nil if the collection is not replicated
+	sketches      []*hyperLogLog // sketches of the distinct values of the columns, nil until the first Stats
+	sketchStale   int            // number of the rows removed or replaced since the sketches were built
+	sketchMutex   sync.Mutex     // serializes the building of the sketches by the readers
 	mutex         *sync.RWMutex
+	seq           uint64 // order of the creation, more collections are locked in this order
 }
 
 /*
@@ -62,14 +95,42 @@ func NewRamCollection(rc RamCollectionConf) *RamCollection {
 	}
 
 	ego.param = rc
+	ego.seq = collectionSeq.Add(1)
+	if err := ego.registerComputed(); err != nil {
+		return nil // Fatal log || panic?
+	}
+	if err := ego.registerConstraints(); err != nil {
+		return nil // Fatal log || panic?
+	}
+
+	ego.expiryCol = -1
+	if rc.ExpiryColumn != "" {
+		ego.expiryCol = ego.getFieldIndex(rc.ExpiryColumn)
+		if ego.expiryCol == -1 {
+			return nil // Fatal log || panic?
+		}
+		if _, ok := ego.param.Fields[ego.expiryCol].(FieldConf[time.Time]); !ok {
+			return nil // Fatal log || panic?
+		}
+	}
+
 	ego.mutex = new(sync.RWMutex)
 	ego.rows = make(map[CId][]any, 0)
 	ego.indexes = make(map[string][]ramCollectionIndexer, 0)
-	// TODO: implement id index as default one ego.indexes["id"] = idIndexerNew() // must be present in every collection
-
+	ego.versions = make(map[CId]uint64)
+	ego.history = make(map[CId][]revision)
 	if err := ego.registerIndexes(); err != nil {
 		return nil // Fatal log || panic?
 	}
+	if err := ego.load(rc.Data); err != nil {
+		return nil // Fatal log || panic?
+	}
+	// the loaded records are held by the rows only
+	ego.param.Data = RamDataConf{}
+
+	if rc.ReapInterval > 0 && ego.expiryCol != -1 {
+		ego.startReaper()
+	}
 	return ego
 }
 
@@ -95,7 +156,7 @@ func (ego *RamCollection) InterpretRecord(rc RecordConf) ([]any, error) {
 		ret = append(ret, rf)
 	}
 
-	return ret, nil
+	return ego.compute(ret)
 }
 
 /*
@@ -138,9 +199,23 @@ Returns:
   - error, if any.
 */
 func (ego *RamCollection) AddRecord(rc RecordConf) (CId, error) {
-	ego.mutex.Lock()
-	defer ego.mutex.Unlock()
+	defer ego.lockWrite(false)()
+
+	return ego.addRow(rc)
+}
 
+/*
+Adds the record to the RamCollection and to the lookup indexes.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - rc - Configuration of the Record.
+
+Returns:
+  - CId of newly added record,
+  - error, if any.
+*/
+func (ego *RamCollection) addRow(rc RecordConf) (CId, error) {
 	cid := rc.Id
 	if !cid.ValidP() {
 		// need to generate a new one
@@ -166,6 +241,15 @@ func (ego *RamCollection) AddRecord(rc RecordConf) (CId, error) {
 	// TODO: Check mandatory fields
 	// TODO: Update default fields
 
+	if ego.hooked(BEFORE_ADD) {
+		rc.Id = cid
+		m := MutationConf{Event: BEFORE_ADD, New: rc}
+		if err := ego.runBeforeHooks(&m); err != nil {
+			return 0, err
+		}
+		rc = m.New
+	}
+
 	// Add to main index
 	record, err := ego.InterpretRecord(rc)
 
@@ -173,7 +257,15 @@ func (ego *RamCollection) AddRecord(rc RecordConf) (CId, error) {
 		return 0, err
 	}
 
+	if err := ego.checkRow(cid, record, nil); err != nil {
+		return 0, err
+	}
+
 	ego.rows[cid] = record
+	ego.versions[cid] = 1
+	ego.idIndex.Add(nil, cid)
+	ego.addRevision(cid, record)
+	ego.sketchRow(nil, record)
 
 	// Add to lookup indexes
 	for i, name := range ego.param.FieldsNaming {
@@ -185,9 +277,230 @@ func (ego *RamCollection) AddRecord(rc RecordConf) (CId, error) {
 			}
 		}
 	}
+
+	ego.runAfterHooks(AFTER_ADD, cid, RecordConf{})
 	return cid, nil
 }
 
+/*
+Adds many records at once. The records are validated first,
+so either all of them are added or none. The lookup indexes
+are updated only after all rows are stored.
+
+Parameters:
+  - rcs - Configurations of the Records.
+
+Returns:
+  - CIds of the added records, in the order of the parameter,
+  - error, if any.
+*/
+func (ego *RamCollection) BulkLoad(rcs []RecordConf) ([]CId, error) {
+	defer ego.lockWrite(false)()
+
+	autoincrement := ego.autoincrement
+	cids := make([]CId, len(rcs))
+	records := make([][]any, len(rcs))
+	used := make(map[CId]bool)
+
+	for i, rc := range rcs {
+		cid := rc.Id
+		if !cid.ValidP() {
+			autoincrement++
+			cid = autoincrement
+		} else if cid >= autoincrement {
+			autoincrement = cid + 1
+		} else if _, found := ego.rows[cid]; found || used[cid] {
+			return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Can not reuse id %d!", cid))
+		}
+
+		if cid == CId(MaxUint) {
+			return nil, errors.NewValueError(ego, errors.LevelFatal, "Id pool depleted!")
+		}
+
+		if ego.hooked(BEFORE_ADD) {
+			rc.Id = cid
+			m := MutationConf{Event: BEFORE_ADD, New: rc}
+			if err := ego.runBeforeHooks(&m); err != nil {
+				return nil, err
+			}
+			rc = m.New
+		}
+
+		if !ego.validLength(len(rc.Cols)) {
+			return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Wrong number of columns in record %d.", i))
+		}
+
+		record, err := ego.InterpretRecord(rc)
+		if err != nil {
+			return nil, err
+		}
+
+		used[cid] = true
+		cids[i] = cid
+		records[i] = record
+	}
+
+	// after all CIds are known, so the records can reference each other
+	for i, cid := range cids {
+		if err := ego.checkRow(cid, records[i], used); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ego.indexRows(cids, records); err != nil {
+		return nil, err
+	}
+
+	for i, cid := range cids {
+		ego.rows[cid] = records[i]
+		ego.versions[cid] = 1
+		ego.idIndex.Add(nil, cid)
+		ego.addRevision(cid, records[i])
+		ego.sketchRow(nil, records[i])
+	}
+	ego.autoincrement = autoincrement
+
+	for _, cid := range cids {
+		ego.runAfterHooks(AFTER_ADD, cid, RecordConf{})
+	}
+
+	return cids, nil
+}
+
+/*
+Adds the rows to the lookup indexes, in bulk where the indexes support it.
+If any addition fails, the rows are removed from the indexes again.
+Has to be called under the write lock.
+
+Parameters:
+  - cids - CIds of the rows,
+  - records - the rows, in the order of the CIds.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) indexRows(cids []CId, records [][]any) error {
+	var journal indexJournal
+
+	for col, name := range ego.param.FieldsNaming {
+		colidx, found := ego.indexes[name]
+		if !found {
+			continue
+		}
+
+		vals := make([]any, len(records))
+		for i, record := range records {
+			vals[i] = record[col]
+		}
+
+		for _, idx := range colidx {
+			// the rows added before a failure are not known, all of them are removed, missing ones are ignored
+			journal = append(journal, func() {
+				for i, cid := range cids {
+					idx.Del(vals[i], cid)
+				}
+			})
+
+			if bidx, ok := idx.(ramCollectionBulkIndexer); ok {
+				if err := bidx.addBulk(vals, cids); err != nil {
+					journal.rollback()
+					return err
+				}
+				continue
+			}
+			for i, cid := range cids {
+				if err := idx.Add(vals[i], cid); err != nil {
+					journal.rollback()
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Undo steps of the changes of the lookup indexes.
+type indexJournal []func()
+
+/*
+Undoes the journaled changes, the latest first.
+*/
+func (ego *indexJournal) rollback() {
+	for i := len(*ego) - 1; i >= 0; i-- {
+		(*ego)[i]()
+	}
+	*ego = nil
+}
+
+/*
+Moves the row from its old values to the new ones in the lookup indexes of the changed columns.
+The changes are journaled, on a failure the caller rolls the journal back.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the row,
+  - from - the old row,
+  - to - the new row,
+  - journal - journal of the changes.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) reindex(cid CId, from []any, to []any, journal *indexJournal) error {
+	for col, name := range ego.param.FieldsNaming {
+		if cmpFullmatchValues(from[col], to[col]) == 0 {
+			continue
+		}
+
+		for _, idx := range ego.indexes[name] {
+			idx := idx
+			oldVal, newVal := from[col], to[col]
+
+			if err := idx.Del(oldVal, cid); err != nil {
+				return err
+			}
+			*journal = append(*journal, func() { idx.Add(oldVal, cid) })
+
+			if err := idx.Add(newVal, cid); err != nil {
+				return err
+			}
+			*journal = append(*journal, func() { idx.Del(newVal, cid) })
+		}
+	}
+
+	return nil
+}
+
+/*
+Removes the row from the lookup indexes.
+The changes are journaled, on a failure the caller rolls the journal back.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the row,
+  - row - the row,
+  - journal - journal of the changes.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) unindex(cid CId, row []any, journal *indexJournal) error {
+	for col, name := range ego.param.FieldsNaming {
+		for _, idx := range ego.indexes[name] {
+			idx := idx
+			val := row[col]
+
+			if err := idx.Del(val, cid); err != nil {
+				return err
+			}
+			*journal = append(*journal, func() { idx.Add(val, cid) })
+		}
+	}
+
+	return nil
+}
+
 /*
 Checks, if CId is valid.
 
@@ -215,35 +528,96 @@ func (ego *RamCollection) DeleteRecord(rc RecordConf) error {
 		return errors.NewMisappError(ego, "Invalid Id field in record.")
 	}
 
-	ego.mutex.Lock()
-	defer ego.mutex.Unlock()
+	defer ego.lockWrite(true)()
+
+	plan := newDeletePlan()
+	if err := plan.delete(ego, cid); err != nil {
+		return err
+	}
+	return plan.apply()
+}
+
+/*
+Deletes the row from the RamCollection and from the lookup indexes.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the row.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *RamCollection) deleteRow(cid CId) error {
+	old, err := ego.prepareDelete(cid)
+	if err != nil {
+		return err
+	}
+
+	var journal indexJournal
+	if err := ego.unindex(cid, ego.rows[cid], &journal); err != nil {
+		journal.rollback()
+		return err
+	}
+
+	ego.commitDelete(cid, old)
+	return nil
+}
+
+/*
+Prepares the deletion of the row: runs the before-hooks. The collection is not changed.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the row.
 
+Returns:
+  - state of the row passed to the hooks, empty if there are no deletion hooks,
+  - error, if any.
+*/
+func (ego *RamCollection) prepareDelete(cid CId) (RecordConf, error) {
 	record, found := ego.rows[cid]
 
 	if !found {
-		return errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
+		return RecordConf{}, errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
 	}
 
-	// Delete from lookup indices
-	for i, name := range ego.param.FieldsNaming {
-		if colidx, found := ego.indexes[name]; found {
-			for _, idx := range colidx {
-				if err := idx.Del(record[i], cid); err != nil {
-					return err //FIXME: inconsitent state if any call of Del fails
-				}
-			}
+	var old RecordConf
+	if ego.hooked(BEFORE_DELETE, AFTER_DELETE) {
+		var err error
+		if old, err = ego.hookRecord(cid, record); err != nil {
+			return old, err
+		}
+		m := MutationConf{Event: BEFORE_DELETE, Old: old}
+		if err := ego.runBeforeHooks(&m); err != nil {
+			return old, err
 		}
 	}
 
-	delete(ego.rows, cid)
+	return old, nil
+}
 
-	return nil
+/*
+Removes the row, which has been removed from the lookup indexes already, and runs the after-hooks.
+Has to be called under the write lock.
+
+Parameters:
+  - cid - CId of the row,
+  - old - state of the row passed to the hooks.
+*/
+func (ego *RamCollection) commitDelete(cid CId, old RecordConf) {
+	ego.sketchRow(ego.rows[cid], nil)
+	delete(ego.rows, cid)
+	delete(ego.versions, cid)
+	ego.idIndex.Del(nil, cid)
+	ego.addRevision(cid, nil)
+	ego.runAfterHooks(AFTER_DELETE, cid, old)
 }
 
 /*
 Filters the matching rows according to the given query.
 It then deletes them from the RamCollection and deletes
-them from the search indexers.
+them from the search indexers. Each record is deleted together with the on-delete
+actions of the references to it, or not at all, the deletion stops at the first failure.
 
 Parameters:
   - q - Configuration of the query.
@@ -252,34 +626,59 @@ Returns:
   - Error, if any.
 */
 func (ego *RamCollection) DeleteByFilter(fa FilterArgument) error {
-	if qq, ok := fa.QueryConf.(QueryAndConf); ok && len(qq.Context) == 0 {
+	defer ego.lockWrite(true)()
+
+	// checked under the lock, so no hook or reference added meanwhile is bypassed
+	if ego.clearsAll(fa) && len(ego.referrers) == 0 && !ego.hooked(BEFORE_DELETE, AFTER_DELETE) {
+		for cid := range ego.rows {
+			ego.addRevision(cid, nil)
+		}
 		ego.rows = make(map[CId][]any)
+		ego.versions = make(map[CId]uint64)
 		ego.indexes = make(map[string][]ramCollectionIndexer)
 		ego.registerIndexes()
-		ego.autoincrement = 1
+		ego.sketches, ego.sketchStale = nil, 0
+		// the CIds are not reused, they identify the records in the history and in the oplog
 		return nil
 	}
 
-	if stream, err := ego.Filter(fa); err != nil {
+	rows, err := ego.capture(fa.QueryConf, fa.AsOf)
+	if err != nil {
 		return err
-	} else {
-		for !stream.Closed() {
-			s := make([]RecordConf, 1)
-			if _, err := stream.Read(s); err != nil {
-				return err
-			}
-			rec := s[0]
+	}
 
-			err = ego.DeleteRecord(RecordConf{Id: rec.Id})
-			if err != nil {
-				return err
-			}
+	if rows, err = ego.makeItSorted(rows, fa); err != nil {
+		return err
+	}
 
+	for _, r := range rows {
+		// the record may have been deleted meanwhile, e.g. by an on-delete cascade of a previous one
+		if _, found := ego.rows[r.id]; !found {
+			continue
+		}
+		plan := newDeletePlan()
+		if err := plan.delete(ego, r.id); err != nil {
+			return err
+		}
+		if err := plan.apply(); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+/*
+Parameters:
+  - fa - filter argument of the deletion.
+
+Returns:
+  - true if the filter matches all current records, false otherwise.
+*/
+func (ego *RamCollection) clearsAll(fa FilterArgument) bool {
+	qq, ok := fa.QueryConf.(QueryAndConf)
+	return ok && len(qq.Context) == 0 && fa.AsOf.IsZero() && fa.Skip == 0 && (fa.Limit == 0 || fa.Limit == NO_LIMIT) && fa.After == ""
+}
+
 /*
 Edits the RamCollection record whose configuration is passed by the parameter
 and modifies it in the lookup indexes.
@@ -297,45 +696,43 @@ func (ego *RamCollection) EditRecord(rc RecordConf) error {
 		return errors.NewMisappError(ego, "Invalid Id field in record.")
 	}
 
-	ego.mutex.Lock()
-	defer ego.mutex.Unlock()
+	defer ego.lockWrite(false)()
 
-	record, found := ego.rows[cid]
-	if !found {
+	return ego.editRow(rc)
+}
+
+/*
+Edits the record in the RamCollection and modifies it in the lookup indexes.
+If the version of the record is set, it has to match the current version of the row.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - rc - Configuration of Record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *RamCollection) editRow(rc RecordConf) error {
+	cid := rc.Id
+
+	if _, found := ego.rows[cid]; !found {
 		return errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
 	}
 
-	if len(rc.Cols) != len(record) {
+	if !ego.validLength(len(rc.Cols)) {
 		return errors.NewNotFoundError(ego, errors.LevelWarning, "Wrong number of columns")
 	}
 
+	cols := make(map[int]any, len(rc.Cols))
 	for col, fc := range rc.Cols {
-
 		val, err := ego.InterpretField(fc)
 		if err != nil {
 			return err
 		}
-		if cmpFullmatchValues(val, record[col]) == 0 {
-			continue
-		}
-
-		name := ego.param.FieldsNaming[col]
-		// Modify lookup indexes
-		if colidx, found := ego.indexes[name]; found {
-			for _, idx := range colidx {
-				if err = idx.Del(record[col], cid); err != nil {
-					return err //FIXME: inconsitent state if any call of Del fails
-				}
-				if err = idx.Add(record[col], cid); err != nil {
-					return err //FIXME: inconsitent state if any call of Del fails
-				}
-			}
-
-		}
-		ego.rows[cid][col] = val
+		cols[col] = val
 	}
 
-	return nil
+	return ego.patchRow(cid, cols, rc.Version)
 }
 
 type CIdSet map[CId]bool
@@ -442,8 +839,16 @@ func (ego *RamCollection) getIndex(q QueryAtomConf) ramCollectionIndexer {
 	if idxcol, found := ego.indexes[q.Name]; found {
 		// index for that name found
 		// try cast to the required index
+		collation, _ := collationOf(q.MatchType)
 		for _, idx := range idxcol {
-			if cmpIndexKind(q.MatchType, idx) {
+			if indexerCollation(idx) != collation {
+				continue
+			}
+			inner := idx
+			if ci, ok := idx.(*collatedIndexer); ok {
+				inner = ci.ramCollectionIndexer
+			}
+			if cmpIndexKind(q.MatchType, inner) {
 				return idx
 			}
 		}
@@ -543,6 +948,36 @@ func (ego *RamCollection) filterQueryEval(q QueryConf) (CIdSet, error) {
 	}
 }
 
+/*
+Returns:
+  - RamCollection's schema.
+*/
+func (ego *RamCollection) Schema() SchemaConf {
+	return ego.param.SchemaConf
+}
+
+/*
+Checks if a fullmatch indexer is bound to the column.
+
+Parameters:
+  - name - name of the column.
+
+Returns:
+  - True, if the column has a fullmatch index, false otherwise.
+*/
+func (ego *RamCollection) hasFullmatchIndex(name string) bool {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	idx := ego.getFieldIndex(name)
+	if idx == -1 {
+		return false
+	}
+
+	mt := fullmatchConfOf(ego.param.Fields[idx], name)
+	return mt != nil && ego.getIndex(QueryAtomConf{Name: name, MatchType: mt}) != nil
+}
+
 /*
 Returns:
   - RamCollection's rows
@@ -571,9 +1006,112 @@ func (ego *RamCollection) Inspect() {
 	print("\n")
 }
 
+// Row captured by a filter together with its id.
+type rowSnapshot struct {
+	id      CId
+	version uint64
+	row     []any
+}
+
+/*
+Evaluates the query and captures the matching rows.
+Since rows are never modified in place, the captured rows
+stay consistent no matter what happens to the collection later.
+
+Parameters:
+  - q - Query,
+  - t - time to evaluate the query at, zero means now.
+
+Returns:
+  - Captured rows in no particular order,
+  - error, if any.
+*/
+func (ego *RamCollection) snapshot(q QueryConf, t time.Time) ([]rowSnapshot, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	return ego.capture(q, t)
+}
+
+/*
+Evaluates the query and captures the matching rows.
+Has to be called under the lock.
+
+Parameters:
+  - q - Query,
+  - t - time to evaluate the query at, zero means now.
+
+Returns:
+  - Captured rows in no particular order,
+  - error, if any.
+*/
+func (ego *RamCollection) capture(q QueryConf, t time.Time) ([]rowSnapshot, error) {
+	src, err := ego.asOf(t)
+	if err != nil {
+		return nil, err
+	}
+
+	retFilter, err := src.filterQueryEval(q)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	out := make([]rowSnapshot, 0, len(retFilter))
+	for id := range retFilter {
+		if row, version := src.row(id); !ego.expired(row, now) {
+			out = append(out, rowSnapshot{id: id, version: version, row: row})
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Looks up the records by their CIds.
+Missing and expired records are skipped.
+
+Parameters:
+  - ids - CIds of the records.
+
+Returns:
+  - the found records in the order of their CIds in the parameter,
+  - error, if any.
+*/
+func (ego *RamCollection) Get(ids ...CId) ([]RecordConf, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	now := time.Now()
+	out := make([]RecordConf, 0, len(ids))
+
+	for _, cid := range ids {
+		row, found := ego.rows[cid]
+		if !found || ego.expired(row, now) {
+			continue
+		}
+
+		rec, err := ego.DeinterpretRecord(row)
+		if err != nil {
+			return nil, err
+		}
+		rec.Id = cid
+		rec.Version = ego.versions[cid]
+		out = append(out, rec)
+	}
+
+	return out, nil
+}
+
 /*
 Filters rows based on the type and content of the query
 and writes them to the stream.
+The stream reflects the state of the collection at the moment of the call,
+the collection is not locked while the stream is being read.
 
 Parameters:
   - fa - Filter argument.
@@ -583,15 +1121,12 @@ Returns:
   - error, if any.
 */
 func (ego *RamCollection) Filter(fa FilterArgument) (stream.Producer[RecordConf], error) {
-	defer ego.mutex.RUnlock()
-	ego.mutex.RLock()
-
-	retFilter, err := ego.filterQueryEval(fa.QueryConf)
+	rows, err := ego.snapshot(fa.QueryConf, fa.AsOf)
 	if err != nil {
 		return nil, err
 	}
 
-	ret, err := ego.makeItSorted(retFilter, fa)
+	ret, err := ego.makeItSorted(rows, fa)
 	if err != nil {
 		return nil, err
 	}
@@ -599,11 +1134,10 @@ func (ego *RamCollection) Filter(fa FilterArgument) (stream.Producer[RecordConf]
 	sbuf := stream.NewChanneledInput[RecordConf](0)
 
 	fetchRows := func() {
-		for _, i := range ret { // i == CId
-			ego.mutex.RLock()
-			rec, err := ego.DeinterpretRecord(ego.rows[i.Id])
-			ego.mutex.RUnlock()
-			rec.Id = i.Id
+		for _, r := range ret {
+			rec, err := ego.DeinterpretRecord(r.row)
+			rec.Id = r.id
+			rec.Version = r.version
 
 			if err != nil {
 				// FIXME: sbuf.SetError() pass error! return nil, err
@@ -625,58 +1159,19 @@ func (ego *RamCollection) Filter(fa FilterArgument) (stream.Producer[RecordConf]
 Sorts the results according to the specifications given in FilterArgument.
 If it is not specified which column to sort by, the results are sorted by CId.
 Unless otherwise stated, results will be listed in ascending order.
+If a cursor is given, the results up to the row it points to are dropped.
 
 Parameters:
-  - retFilter - Results to sort,
+  - rows - Results to sort,
   - fa - filter arguments.
 
 Returns:
   - Sorted results,
   - error, if any.
 */
-func (ego *RamCollection) makeItSorted(retFilter CIdSet, fa FilterArgument) ([]RecordConf, error) {
-
-	ret := []RecordConf{}
-
-	if len(retFilter) == 0 {
-		return ret, nil
-	}
-
-	for i := range retFilter {
-		rec, err := ego.DeinterpretRecord(ego.rows[i])
-		if err != nil {
-			return ret, err
-		}
-		rec.Id = i
-		ret = append(ret, rec)
-	}
-
-	if len(fa.Sort) == 0 {
-		slices.SortStableFunc(ret, func(a, b RecordConf) int {
-			if fa.SortOrder == DESC {
-				return cmp.Compare(b.Id, a.Id)
-			}
-			return cmp.Compare(a.Id, b.Id)
-		})
-	} else {
-		idx := ego.getFieldIndex(fa.Sort[0])
-		slices.SortStableFunc(ret, func(a, b RecordConf) int {
-			fieldA, _ := ego.InterpretField(a.Cols[idx])
-			fieldB, _ := ego.InterpretField(b.Cols[idx])
-			if fa.SortOrder == DESC {
-				return cmpFullmatchValues(fieldB, fieldA)
-			}
-			return cmpFullmatchValues(fieldA, fieldB)
-		})
-	}
-
-	start := fa.Skip
-	end := len(ret)
-	if (fa.Limit != -1) && (fa.Skip+fa.Limit) < len(ret) {
-		end = fa.Skip + fa.Limit
-	}
-
-	return ret[start:end], nil
+func (ego *RamCollection) makeItSorted(rows []rowSnapshot, fa FilterArgument) ([]rowSnapshot, error) {
+	page, _, _, err := ego.paginate(rows, fa)
+	return page, err
 }
 
 // Mapping columns names to a structure containing fielders and indexers.
@@ -689,6 +1184,8 @@ type colTuple struct {
 
 const prefixIndexBit = 0    // 0th bit
 const fullmatchIndexBit = 1 // 1st bit
+const bitmapIndexBit = 2    // 2nd bit
+const ngramIndexBit = 3     // 3rd bit
 
 /*
 Checks if a column with this name exists.
@@ -729,7 +1226,7 @@ func (ego *RamCollection) checkName(name string) bool {
 }
 
 /*
-Serializes RamCollection.
+Serializes RamCollection, without its records (see SerializeData).
 
 Returns:
   - configuration of the Gobject.
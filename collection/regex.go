@@ -0,0 +1,141 @@
+package collection
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+/*
+Extracts the literal parts which every value matching the regular expression has to contain.
+Only the top-level concatenation is examined, parts under repetitions,
+alternations or case folding break the literals.
+
+Parameters:
+  - re - parsed regular expression.
+
+Returns:
+  - the literal parts, anchored ones include the start or end marker,
+  - literal prefix of the values, empty if the expression is not anchored at the start.
+*/
+func regexLiterals(re *syntax.Regexp) ([][]rune, string) {
+	items := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		items = re.Sub
+	}
+
+	segments := make([][]rune, 0)
+	current := []rune{}
+	flush := func() {
+		if len(current) > 0 {
+			segments = append(segments, current)
+		}
+		current = []rune{}
+	}
+
+	for i, sub := range items {
+		for sub.Op == syntax.OpCapture {
+			sub = sub.Sub[0]
+		}
+		switch {
+		case sub.Op == syntax.OpBeginText && i == 0:
+			current = append(current, ngramStart)
+		case sub.Op == syntax.OpEndText && i == len(items)-1:
+			current = append(current, ngramEnd)
+		case sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0:
+			current = append(current, sub.Rune...)
+		case sub.Op == syntax.OpEmptyMatch:
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	prefix := ""
+	if len(segments) > 0 && segments[0][0] == ngramStart {
+		first := segments[0][1:]
+		if len(first) > 0 && first[len(first)-1] == ngramEnd {
+			first = first[:len(first)-1]
+		}
+		prefix = string(first)
+	}
+
+	return segments, prefix
+}
+
+/*
+Evaluates the regular expression query atom.
+Candidates are narrowed by the prefix index of the column, if the expression
+has a literal prefix, and by the n-gram index, if the expression has long enough literals.
+The candidates are then matched against the expression.
+Has to be called under the lock.
+
+Parameters:
+  - col - position of the searched column,
+  - q - the query atom.
+
+Returns:
+  - CId set of rows satisfying the query,
+  - error, if any.
+*/
+func (ego *RamCollection) regexEval(col int, q QueryAtomConf) (CIdSet, error) {
+	if _, isString := ego.param.Fields[col].(FieldConf[string]); !isString {
+		return nil, errors.NewMisappError(ego, fmt.Sprintf("Column %s is not a string column.", q.Name))
+	}
+
+	pattern, ok := q.Value.(string)
+	if !ok {
+		return nil, errors.NewValueError(ego, errors.LevelError, "Pattern has to be a string.")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid regular expression: %s", err.Error()))
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid regular expression: %s", err.Error()))
+	}
+	segments, prefix := regexLiterals(parsed.Simplify())
+
+	var cands *CIdBitmap
+
+	if prefix != "" {
+		if indexer := ego.getIndex(QueryAtomConf{Name: q.Name, MatchType: PrefixIndexConf[string]{}}); indexer != nil {
+			rows, err := indexer.Get(prefix)
+			if err != nil {
+				return nil, err
+			}
+			cands = CIdBitmapFromSlice(rows)
+		}
+	}
+
+	if indexer, ok := ego.getIndex(QueryAtomConf{Name: q.Name, MatchType: ContainsMatchConf{}}).(*ngramIndexer); ok {
+		if grams := indexer.segmentCandidates(segments); grams != nil {
+			if cands == nil {
+				cands = grams
+			} else {
+				cands = cands.Intersect(grams)
+			}
+		}
+	}
+
+	out := make(CIdSet)
+	check := func(cid CId) {
+		if row, found := ego.rows[cid]; found && re.MatchString(row[col].(string)) {
+			out[cid] = true
+		}
+	}
+
+	if cands != nil {
+		cands.ForEach(check)
+		return out, nil
+	}
+
+	for cid := range ego.rows {
+		check(cid)
+	}
+	return out, nil
+}
@@ -0,0 +1,1012 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/stream"
+)
+
+const defaultLogSize = 10000
+const defaultRetryInterval = 100 * time.Millisecond
+
+// Kinds of the replication messages.
+const (
+	replSnapshot    = iota // start of the snapshot
+	replRecord             // record of the snapshot
+	replSnapshotEnd        // end of the snapshot, the replica switches to it
+	replMutation           // mutation of the primary
+	replResume             // the replica follows the current log, mutations continue after its last applied one
+)
+
+type PrimaryConf struct {
+	Network string // "tcp" or "unix".
+	Address string
+	LogSize int // Number of the retained mutations, replicas lagging behind more catch up from a snapshot. Zero means 10000.
+}
+
+type ReplicaConf struct {
+	RamCollectionConf // Collection holding the replicated records, its columns have to match the primary.
+	Network           string
+	Address           string        // Address of the primary.
+	RetryInterval     time.Duration // Delay before reconnecting to the primary, zero means 100 ms.
+}
+
+/*
+State of the replication of a single replica.
+Sequence numbers count the mutations of the primary since it was created.
+*/
+type ReplicationStatusConf struct {
+	Address   string // Address of the replica on the primary, address of the primary on the replica.
+	Connected bool
+	Applied   uint64 // Sequence number of the last mutation applied by the replica.
+	Head      uint64 // Sequence number of the last mutation of the primary.
+	Lag       uint64 // Number of the mutations not applied yet.
+}
+
+// Message sent from the primary to the replica.
+type replicationMessage struct {
+	Kind    int               `json:"kind"`
+	Seq     uint64            `json:"seq,omitempty"`
+	Head    uint64            `json:"head"`
+	Epoch   uint64            `json:"epoch,omitempty"`
+	Columns []string          `json:"columns,omitempty"`
+	Id      CId               `json:"id,omitempty"`
+	Version uint64            `json:"version,omitempty"`
+	Cols    []json.RawMessage `json:"cols,omitempty"`  // nil for a deletion
+	Flush   bool              `json:"flush,omitempty"` // last message of a batch, acknowledged by the replica
+}
+
+// Message sent from the replica to the primary, both on connecting and as an acknowledgement.
+type replicationAck struct {
+	Epoch   uint64 `json:"epoch"`
+	Applied uint64 `json:"applied"`
+}
+
+// Mutation of a replicated collection.
+type oplogEntry struct {
+	seq     uint64
+	id      CId
+	row     []any // nil for a deletion
+	version uint64
+}
+
+// Retained tail of the mutations of a replicated collection.
+type oplog struct {
+	entries []oplogEntry
+	head    uint64
+	size    int
+	closed  bool
+	mutex   sync.Mutex
+	cond    *sync.Cond
+}
+
+/*
+Creates new oplog.
+
+Parameters:
+  - size - minimal number of the retained mutations.
+
+Returns:
+  - pointer to a new instance of oplog.
+*/
+func newOplog(size int) *oplog {
+	ego := &oplog{size: size}
+	ego.cond = sync.NewCond(&ego.mutex)
+	return ego
+}
+
+/*
+Appends the mutation to the log.
+Has to be called under the write lock of the collection.
+
+Parameters:
+  - cid - CId of the record,
+  - row - new state of the row, nil for a deletion,
+  - version - new version of the record.
+*/
+func (ego *oplog) append(cid CId, row []any, version uint64) {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	ego.head++
+	ego.entries = append(ego.entries, oplogEntry{seq: ego.head, id: cid, row: row, version: version})
+
+	// trimmed in batches, so the entries are not moved on every append
+	if len(ego.entries) > 2*ego.size {
+		ego.entries = slices.Clone(ego.entries[len(ego.entries)-ego.size:])
+	}
+
+	ego.cond.Broadcast()
+}
+
+/*
+Returns:
+  - sequence number of the last mutation.
+*/
+func (ego *oplog) last() uint64 {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+	return ego.head
+}
+
+/*
+Waits for the mutations following the given one.
+
+Parameters:
+  - from - sequence number of the last mutation already sent,
+  - done - set when the connection is closed.
+
+Returns:
+  - the following mutations, nil if they are not retained anymore,
+  - false if the log or the connection has been closed, true otherwise.
+*/
+func (ego *oplog) wait(from uint64, done *atomic.Bool) ([]oplogEntry, bool) {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	for ego.head == from && !ego.closed && !done.Load() {
+		ego.cond.Wait()
+	}
+
+	if ego.closed || done.Load() {
+		return nil, false
+	}
+
+	if from > ego.head || ego.entries[0].seq > from+1 {
+		return nil, true
+	}
+
+	// entries are never modified, appends do not touch the returned part
+	start := from + 1 - ego.entries[0].seq
+	return ego.entries[start:len(ego.entries):len(ego.entries)], true
+}
+
+/*
+Wakes up all waiting senders, so they can check their connections.
+*/
+func (ego *oplog) wake() {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+	ego.cond.Broadcast()
+}
+
+/*
+Closes the log and wakes up all waiting senders.
+*/
+func (ego *oplog) close() {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+	ego.closed = true
+	ego.cond.Broadcast()
+}
+
+/*
+Encodes the values of the row for the replication.
+
+Parameters:
+  - row - values of the row.
+
+Returns:
+  - JSON encoded values,
+  - error, if any.
+*/
+func (ego *RamCollection) encodeRow(row []any) ([]json.RawMessage, error) {
+	rec, err := ego.DeinterpretRecord(row)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]json.RawMessage, len(rec.Cols))
+	for i, fc := range rec.Cols {
+		val, _ := fieldValue(fc)
+		if out[i], err = json.Marshal(val); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Applies the replicated state of the record, adding, editing or deleting it as needed.
+
+Parameters:
+  - rc - the record, nil Cols means a deletion,
+  - version - version of the record on the primary.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) replicate(rc RecordConf, version uint64) error {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	_, found := ego.rows[rc.Id]
+
+	var err error
+	switch {
+	case rc.Cols == nil:
+		if found {
+			err = ego.deleteRow(rc.Id)
+		}
+		return err
+	case found:
+		err = ego.editRow(rc)
+	default:
+		_, err = ego.addRow(rc)
+	}
+
+	if err == nil {
+		ego.versions[rc.Id] = version
+	}
+	return err
+}
+
+// PRIMARY
+type Primary struct {
+	gonatus.Gobject
+	param      PrimaryConf
+	collection *RamCollection
+	log        *oplog
+	epoch      uint64 // Identifies the log, replicas of other logs have to catch up from a snapshot.
+	listener   net.Listener
+	replicas   map[*replicaLink]bool
+	mutex      sync.Mutex
+	wg         sync.WaitGroup
+}
+
+// Connection of a replica to the primary.
+type replicaLink struct {
+	conn    net.Conn
+	applied atomic.Uint64
+	closed  atomic.Bool
+}
+
+/*
+Creates new Primary shipping the mutations of the collection to the replicas connecting to the given address.
+The collection stays fully usable, a collection can have one primary at a time.
+
+Parameters:
+  - pc - Primary Conf,
+  - rc - the replicated collection.
+
+Returns:
+  - pointer to a new instance of Primary, nil if the address cannot be listened on or the collection is already replicated.
+*/
+func NewPrimary(pc PrimaryConf, rc *RamCollection) *Primary {
+	ego := &Primary{param: pc, collection: rc, epoch: rand.Uint64() | 1, replicas: make(map[*replicaLink]bool)}
+
+	size := pc.LogSize
+	if size <= 0 {
+		size = defaultLogSize
+	}
+
+	listener, err := net.Listen(pc.Network, pc.Address)
+	if err != nil {
+		ego.Log().Error("Primary cannot listen.", "error", err)
+		return nil
+	}
+
+	rc.mutex.Lock()
+	if rc.oplog != nil {
+		rc.mutex.Unlock()
+		listener.Close()
+		ego.Log().Error("Collection is already replicated.")
+		return nil
+	}
+	ego.log = newOplog(size)
+	rc.oplog = ego.log
+	rc.mutex.Unlock()
+
+	ego.listener = listener
+	ego.wg.Add(1)
+	go ego.accept()
+
+	return ego
+}
+
+/*
+Returns:
+  - address the primary listens on.
+*/
+func (ego *Primary) Addr() string {
+	return ego.listener.Addr().String()
+}
+
+/*
+Returns:
+  - sequence number of the last mutation.
+*/
+func (ego *Primary) Head() uint64 {
+	return ego.log.last()
+}
+
+/*
+Reports the state of the connected replicas, as acknowledged by them.
+
+Returns:
+  - states of the replicas, ordered by their addresses.
+*/
+func (ego *Primary) Replicas() []ReplicationStatusConf {
+	head := ego.log.last()
+
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	out := make([]ReplicationStatusConf, 0, len(ego.replicas))
+	for link := range ego.replicas {
+		applied := link.applied.Load()
+		out = append(out, ReplicationStatusConf{
+			Address:   link.conn.RemoteAddr().String(),
+			Connected: !link.closed.Load(),
+			Applied:   applied,
+			Head:      head,
+			Lag:       head - min(applied, head),
+		})
+	}
+
+	slices.SortFunc(out, func(a, b ReplicationStatusConf) int { return cmpFullmatchValues(a.Address, b.Address) })
+	return out
+}
+
+/*
+Accepts the connections of the replicas until the primary is closed.
+*/
+func (ego *Primary) accept() {
+	defer ego.wg.Done()
+
+	for {
+		conn, err := ego.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		link := &replicaLink{conn: conn}
+		ego.mutex.Lock()
+		ego.replicas[link] = true
+		ego.mutex.Unlock()
+
+		ego.wg.Add(1)
+		go ego.serve(link)
+	}
+}
+
+/*
+Ships the mutations to the connected replica.
+A replica of another log, or lagging behind the retained mutations, gets a snapshot first.
+
+Parameters:
+  - link - connection of the replica.
+*/
+func (ego *Primary) serve(link *replicaLink) {
+	defer ego.wg.Done()
+	defer func() {
+		link.closed.Store(true)
+		link.conn.Close()
+		ego.mutex.Lock()
+		delete(ego.replicas, link)
+		ego.mutex.Unlock()
+	}()
+
+	dec := json.NewDecoder(link.conn)
+	enc := json.NewEncoder(link.conn)
+
+	var hello replicationAck
+	if err := dec.Decode(&hello); err != nil {
+		return
+	}
+	link.applied.Store(hello.Applied)
+
+	ego.wg.Add(1)
+	go func() {
+		defer ego.wg.Done()
+		for {
+			var ack replicationAck
+			if err := dec.Decode(&ack); err != nil {
+				link.closed.Store(true)
+				ego.log.wake()
+				return
+			}
+			link.applied.Store(ack.Applied)
+		}
+	}()
+
+	from := hello.Applied
+	var err error
+	if hello.Epoch != ego.epoch {
+		from, err = ego.sendSnapshot(enc)
+	} else {
+		err = enc.Encode(replicationMessage{Kind: replResume, Head: ego.log.last(), Flush: true})
+	}
+	if err != nil {
+		return
+	}
+
+	for {
+		entries, open := ego.log.wait(from, &link.closed)
+		if !open {
+			return
+		}
+
+		if entries == nil {
+			if from, err = ego.sendSnapshot(enc); err != nil {
+				return
+			}
+			continue
+		}
+
+		for i, e := range entries {
+			msg, err := ego.message(replMutation, e.id, e.row, e.version)
+			if err != nil {
+				ego.Log().Error("Mutation cannot be replicated.", "error", err)
+				return
+			}
+			msg.Seq = e.seq
+			msg.Flush = i == len(entries)-1
+			if err := enc.Encode(msg); err != nil {
+				return
+			}
+		}
+		from = entries[len(entries)-1].seq
+	}
+}
+
+/*
+Creates the message carrying the state of the record.
+
+Parameters:
+  - kind - kind of the message,
+  - cid - CId of the record,
+  - row - values of the row, nil for a deletion,
+  - version - version of the record.
+
+Returns:
+  - the message,
+  - error, if any.
+*/
+func (ego *Primary) message(kind int, cid CId, row []any, version uint64) (replicationMessage, error) {
+	msg := replicationMessage{Kind: kind, Head: ego.log.last(), Id: cid, Version: version}
+
+	if row != nil {
+		var err error
+		if msg.Cols, err = ego.collection.encodeRow(row); err != nil {
+			return replicationMessage{}, err
+		}
+	}
+
+	return msg, nil
+}
+
+/*
+Sends the snapshot of the collection to the replica.
+
+Parameters:
+  - enc - encoder of the connection.
+
+Returns:
+  - sequence number of the last mutation included in the snapshot,
+  - error, if any.
+*/
+func (ego *Primary) sendSnapshot(enc *json.Encoder) (uint64, error) {
+	c := ego.collection
+
+	// rows are never modified in place, so references are enough
+	c.mutex.RLock()
+	rows := make(map[CId][]any, len(c.rows))
+	versions := make(map[CId]uint64, len(c.rows))
+	for cid, row := range c.rows {
+		rows[cid] = row
+		versions[cid] = c.versions[cid]
+	}
+	seq := ego.log.last()
+	c.mutex.RUnlock()
+
+	if err := enc.Encode(replicationMessage{Kind: replSnapshot, Seq: seq, Head: seq, Epoch: ego.epoch, Columns: c.param.FieldsNaming}); err != nil {
+		return 0, err
+	}
+
+	for cid, row := range rows {
+		msg, err := ego.message(replRecord, cid, row, versions[cid])
+		if err != nil {
+			return 0, err
+		}
+		if err := enc.Encode(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	return seq, enc.Encode(replicationMessage{Kind: replSnapshotEnd, Seq: seq, Head: ego.log.last(), Flush: true})
+}
+
+/*
+Stops the replication and disconnects the replicas.
+The collection stays usable, its mutations are not logged anymore.
+*/
+func (ego *Primary) Close() {
+	ego.listener.Close()
+
+	ego.collection.mutex.Lock()
+	if ego.collection.oplog == ego.log {
+		ego.collection.oplog = nil
+	}
+	ego.collection.mutex.Unlock()
+
+	ego.log.close()
+
+	ego.mutex.Lock()
+	for link := range ego.replicas {
+		link.conn.Close()
+	}
+	ego.mutex.Unlock()
+
+	ego.wg.Wait()
+}
+
+/*
+Serializes Primary.
+
+Returns:
+  - configuration of the Gobject.
+*/
+func (ego *Primary) Serialize() gonatus.Conf {
+	return ego.param
+}
+
+// REPLICA
+type Replica struct {
+	gonatus.Gobject
+	param      ReplicaConf
+	collection atomic.Pointer[RamCollection]
+	epoch      uint64 // Epoch of the primary log the collection follows, zero before the first snapshot.
+	applied    atomic.Uint64
+	head       atomic.Uint64
+	connected  atomic.Bool
+	conn       net.Conn
+	stop       chan struct{}
+	mutex      sync.Mutex // Guards the connection.
+	wg         sync.WaitGroup
+}
+
+/*
+Creates new Replica following the primary at the given address.
+The replica connects in the background and reconnects whenever the connection is lost.
+It serves the reads, all writes are refused.
+
+Parameters:
+  - rc - Replica Conf.
+
+Returns:
+  - pointer to a new instance of Replica, nil if the collection cannot be created.
+*/
+func NewReplica(rc ReplicaConf) *Replica {
+	ego := &Replica{param: rc, stop: make(chan struct{})}
+
+	c := ego.newCollection()
+	if c == nil {
+		return nil
+	}
+	ego.collection.Store(c)
+
+	ego.wg.Add(1)
+	go ego.run()
+
+	return ego
+}
+
+/*
+Creates an empty collection for the replicated records.
+Expired records are not reaped by the replica, the reaping of the primary is replicated.
+
+Returns:
+  - pointer to a new instance of RamCollection.
+*/
+func (ego *Replica) newCollection() *RamCollection {
+	conf := ego.param.RamCollectionConf
+	conf.ReapInterval = 0
+	c := NewRamCollection(conf)
+	if c != nil {
+		// the records have been checked by the primary, references to other collections are not linked
+		c.unchecked = true
+	}
+	return c
+}
+
+/*
+Returns:
+  - true if the replica has been closed, false otherwise.
+*/
+func (ego *Replica) stopped() bool {
+	select {
+	case <-ego.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Follows the primary, reconnecting until the replica is closed.
+*/
+func (ego *Replica) run() {
+	defer ego.wg.Done()
+
+	retry := ego.param.RetryInterval
+	if retry <= 0 {
+		retry = defaultRetryInterval
+	}
+
+	for {
+		if err := ego.follow(); err != nil && !ego.stopped() {
+			ego.Log().Warn("Replication from the primary interrupted.", "address", ego.param.Address, "error", err)
+		}
+		ego.connected.Store(false)
+
+		select {
+		case <-ego.stop:
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+/*
+Connects to the primary and applies the received mutations until the connection is lost.
+
+Returns:
+  - error, if any, nil if the primary is not available.
+*/
+func (ego *Replica) follow() error {
+	conn, err := net.Dial(ego.param.Network, ego.param.Address)
+	if err != nil {
+		return nil
+	}
+
+	ego.mutex.Lock()
+	if ego.stopped() {
+		ego.mutex.Unlock()
+		conn.Close()
+		return nil
+	}
+	ego.conn = conn
+	ego.mutex.Unlock()
+
+	defer func() {
+		ego.mutex.Lock()
+		ego.conn = nil
+		ego.mutex.Unlock()
+		conn.Close()
+	}()
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	if err := enc.Encode(replicationAck{Epoch: ego.epoch, Applied: ego.applied.Load()}); err != nil {
+		return err
+	}
+
+	var staged *RamCollection
+	var stagedEpoch uint64
+
+	for {
+		var msg replicationMessage
+		if err := dec.Decode(&msg); err != nil {
+			if ego.stopped() {
+				return nil
+			}
+			return err
+		}
+		ego.head.Store(msg.Head)
+
+		switch msg.Kind {
+		case replSnapshot:
+			// the snapshot is built aside, so the reads see the old state until it is complete
+			staged = ego.newCollection()
+			if !slices.Equal(msg.Columns, staged.param.FieldsNaming) {
+				return errors.NewStateError(ego, errors.LevelError, "Columns of the replica do not match the primary.")
+			}
+			stagedEpoch = msg.Epoch
+		case replRecord:
+			if staged == nil {
+				return errors.NewStateError(ego, errors.LevelError, "Record outside of a snapshot.")
+			}
+			if err := ego.apply(staged, msg); err != nil {
+				return err
+			}
+		case replSnapshotEnd:
+			if staged == nil {
+				return errors.NewStateError(ego, errors.LevelError, "End of a snapshot which has not started.")
+			}
+			ego.collection.Swap(staged).Close()
+			ego.epoch = stagedEpoch
+			ego.applied.Store(msg.Seq)
+			ego.connected.Store(true)
+			staged = nil
+		case replResume:
+			ego.connected.Store(true)
+		case replMutation:
+			if err := ego.apply(ego.collection.Load(), msg); err != nil {
+				return err
+			}
+			ego.applied.Store(msg.Seq)
+		default:
+			return errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("Unknown replication message %d.", msg.Kind))
+		}
+
+		if msg.Flush {
+			if err := enc.Encode(replicationAck{Epoch: ego.epoch, Applied: ego.applied.Load()}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/*
+Applies the state of the record received from the primary.
+
+Parameters:
+  - c - target collection,
+  - msg - the message.
+
+Returns:
+  - error, if any.
+*/
+func (ego *Replica) apply(c *RamCollection, msg replicationMessage) error {
+	rec := RecordConf{Id: msg.Id}
+
+	if msg.Cols != nil {
+		// fields of the collection, including the computed ones
+		fields := c.param.Fields
+		if len(msg.Cols) != len(fields) {
+			return errors.NewValueError(ego, errors.LevelError, "Wrong number of columns")
+		}
+		rec.Cols = make([]FielderConf, len(msg.Cols))
+		for i, raw := range msg.Cols {
+			var err error
+			if rec.Cols[i], err = decodeField(fields[i], raw); err != nil {
+				return err
+			}
+		}
+	}
+
+	return c.replicate(rec, msg.Version)
+}
+
+/*
+Reports the state of the replication, as known to the replica.
+
+Returns:
+  - the state.
+*/
+func (ego *Replica) Status() ReplicationStatusConf {
+	applied, head := ego.applied.Load(), ego.head.Load()
+	return ReplicationStatusConf{
+		Address:   ego.param.Address,
+		Connected: ego.connected.Load(),
+		Applied:   applied,
+		Head:      head,
+		Lag:       head - min(applied, head),
+	}
+}
+
+/*
+Disconnects from the primary and stops the replication.
+The replicated records stay readable.
+*/
+func (ego *Replica) Close() {
+	ego.mutex.Lock()
+	if !ego.stopped() {
+		close(ego.stop)
+	}
+	if ego.conn != nil {
+		ego.conn.Close()
+	}
+	ego.mutex.Unlock()
+
+	ego.wg.Wait()
+}
+
+/*
+Returns:
+  - an error, the replica is read-only.
+*/
+func (ego *Replica) readOnly() error {
+	return errors.NewStateError(ego, errors.LevelError, "Replica is read-only.")
+}
+
+/*
+Returns:
+  - schema of the replicated collection.
+*/
+func (ego *Replica) Schema() SchemaConf {
+	return ego.collection.Load().Schema()
+}
+
+/*
+Filters the replicated records.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - the stream of the records,
+  - error, if any.
+*/
+func (ego *Replica) Filter(fa FilterArgument) (stream.Producer[RecordConf], error) {
+	return ego.collection.Load().Filter(fa)
+}
+
+/*
+Filters one page of the replicated records.
+
+Parameters:
+  - fa - filter arguments.
+
+Returns:
+  - the page,
+  - error, if any.
+*/
+func (ego *Replica) FilterPage(fa FilterArgument) (PageConf, error) {
+	return ego.collection.Load().FilterPage(fa)
+}
+
+/*
+Counts the replicated records satisfying the query.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - number of the records,
+  - error, if any.
+*/
+func (ego *Replica) Count(q QueryConf) (int, error) {
+	return ego.collection.Load().Count(q)
+}
+
+/*
+Draws a uniform sample of the replicated records satisfying the query.
+
+Parameters:
+  - fa - Filter argument,
+  - n - size of the sample.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *Replica) Sample(fa FilterArgument, n int) ([]RecordConf, error) {
+	return ego.collection.Load().Sample(fa, n)
+}
+
+/*
+Draws a uniform sample of the replicated records satisfying the query from each stratum of the column.
+
+Parameters:
+  - fa - Filter argument,
+  - column - column determining the strata,
+  - n - size of the sample of each stratum.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *Replica) StratifiedSample(fa FilterArgument, column string, n int) ([]RecordConf, error) {
+	return ego.collection.Load().StratifiedSample(fa, column, n)
+}
+
+/*
+Finds the distinct values of the column among the replicated records satisfying the query, with their frequencies.
+
+Parameters:
+  - column - name of the column,
+  - q - the query.
+
+Returns:
+  - the values with their frequencies, the most frequent first,
+  - error, if any.
+*/
+func (ego *Replica) Distinct(column string, q QueryConf) ([]DistinctConf, error) {
+	return ego.collection.Load().Distinct(column, q)
+}
+
+/*
+Looks up the replicated records by their CIds.
+
+Parameters:
+  - ids - CIds of the records.
+
+Returns:
+  - the found records in the order of their CIds in the parameter,
+  - error, if any.
+*/
+func (ego *Replica) Get(ids ...CId) ([]RecordConf, error) {
+	return ego.collection.Load().Get(ids...)
+}
+
+/*
+Checks if any replicated record satisfies the query.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - true if there is such a record, false otherwise,
+  - error, if any.
+*/
+func (ego *Replica) Exists(q QueryConf) (bool, error) {
+	return ego.collection.Load().Exists(q)
+}
+
+/*
+Computes the statistics of the replicated records.
+
+Returns:
+  - the statistics,
+  - error, if any.
+*/
+func (ego *Replica) Stats() (StatsConf, error) {
+	return ego.collection.Load().Stats()
+}
+
+func (ego *Replica) AddRecord(RecordConf) (CId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *Replica) DeleteRecord(RecordConf) error {
+	return ego.readOnly()
+}
+
+func (ego *Replica) DeleteByFilter(FilterArgument) error {
+	return ego.readOnly()
+}
+
+func (ego *Replica) EditRecord(RecordConf) error {
+	return ego.readOnly()
+}
+
+func (ego *Replica) PatchRecord(CId, PatchConf) error {
+	return ego.readOnly()
+}
+
+func (ego *Replica) Upsert(RecordConf, []string) (CId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *Replica) UpdateWhere(FilterArgument, PatchConf) (int, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *Replica) AddHook(int, MutationHook) (HookId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *Replica) RemoveHook(HookId) error {
+	return ego.readOnly()
+}
+
+/*
+Returns:
+  - error, if any.
+*/
+func (ego *Replica) Commit() error {
+	return nil
+}
+
+/*
+Serializes Replica.
+
+Returns:
+  - configuration of the Gobject.
+*/
+func (ego *Replica) Serialize() gonatus.Conf {
+	return ego.param
+}
@@ -0,0 +1,247 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+// Uniform sample of a stream of CIds of unknown length (reservoir sampling, algorithm R).
+type reservoir struct {
+	size int
+	seen int
+	ids  []CId
+}
+
+/*
+Offers the CId to the sample.
+
+Parameters:
+  - cid - the CId.
+*/
+func (ego *reservoir) offer(cid CId) {
+	ego.seen++
+	if len(ego.ids) < ego.size {
+		ego.ids = append(ego.ids, cid)
+		return
+	}
+	if j := rand.Intn(ego.seen); j < ego.size {
+		ego.ids[j] = cid
+	}
+}
+
+/*
+Draws a uniform sample of the records satisfying the query, without replacement.
+The matching records are not sorted nor deinterpreted, only the sample is.
+Only the query, AsOf and the order (Sort, SortOrder, Collation) of the filter argument are used,
+the sample is ordered as Filter would order it.
+
+Parameters:
+  - fa - Filter argument,
+  - n - size of the sample, all matching records are returned if there are not more of them.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *RamCollection) Sample(fa FilterArgument, n int) ([]RecordConf, error) {
+	return ego.sample(fa, -1, n)
+}
+
+/*
+Draws a uniform sample of the records satisfying the query from each group of the records
+with the same value of the column (stratum), without replacement.
+Only the query, AsOf and the order (Sort, SortOrder, Collation) of the filter argument are used,
+the samples of all strata are ordered together, as Filter would order them.
+
+Parameters:
+  - fa - Filter argument,
+  - column - column determining the strata,
+  - n - size of the sample of each stratum.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *RamCollection) StratifiedSample(fa FilterArgument, column string, n int) ([]RecordConf, error) {
+	col := ego.getFieldIndex(column)
+	if col == -1 {
+		return nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Stratum column %s not found.", column))
+	}
+	return ego.sample(fa, col, n)
+}
+
+/*
+Draws the sample and orders it.
+
+Parameters:
+  - fa - Filter argument,
+  - col - position of the stratum column, -1 for a simple sample,
+  - n - size of the sample (of each stratum).
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *RamCollection) sample(fa FilterArgument, col int, n int) ([]RecordConf, error) {
+	if n < 0 {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid sample size %d.", n))
+	}
+
+	rows, err := ego.sampleRows(fa.QueryConf, fa.AsOf, col, n)
+	if err != nil {
+		return nil, err
+	}
+
+	order := fa
+	order.Skip = 0
+	order.Limit = NO_LIMIT
+	order.After = ""
+	if rows, err = ego.makeItSorted(rows, order); err != nil {
+		return nil, err
+	}
+
+	out := make([]RecordConf, len(rows))
+	for i, r := range rows {
+		if out[i], err = ego.DeinterpretRecord(r.row); err != nil {
+			return nil, err
+		}
+		out[i].Id = r.id
+		out[i].Version = r.version
+	}
+
+	return out, nil
+}
+
+/*
+Evaluates the query and captures a sample of the matching rows.
+Queries served by bitmap indexes are sampled from the bitmaps directly.
+
+Parameters:
+  - q - Query,
+  - t - time to evaluate the query at, zero means now,
+  - col - position of the stratum column, -1 for a simple sample,
+  - n - size of the sample (of each stratum).
+
+Returns:
+  - Captured rows in no particular order,
+  - error, if any.
+*/
+func (ego *RamCollection) sampleRows(q QueryConf, t time.Time, col int, n int) ([]rowSnapshot, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	src, err := ego.asOf(t)
+	if err != nil {
+		return nil, err
+	}
+
+	strata := make(map[string]*reservoir)
+	offer := func(cid CId) {
+		key := ""
+		if col != -1 {
+			row, _ := src.row(cid)
+			encoded, e := json.Marshal(row[col])
+			if e != nil {
+				err = e
+				return
+			}
+			key = string(encoded)
+		}
+		r, found := strata[key]
+		if !found {
+			r = &reservoir{size: n}
+			strata[key] = r
+		}
+		r.offer(cid)
+	}
+
+	if t.IsZero() && ego.expiryCol == -1 {
+		if bm := bitmapEval(ego, q); bm != nil {
+			bm.ForEach(offer)
+			return src.sampled(strata), err
+		}
+	}
+
+	ids, err := src.filterQueryEval(q)
+	if err != nil {
+		return nil, err
+	}
+
+	now := t
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	for id := range ids {
+		if row, _ := src.row(id); !ego.expired(row, now) {
+			offer(id)
+		}
+	}
+
+	return src.sampled(strata), err
+}
+
+/*
+Captures the sampled rows.
+Has to be called under the lock.
+
+Parameters:
+  - strata - samples of the strata.
+
+Returns:
+  - the rows.
+*/
+func (ego *pastState) sampled(strata map[string]*reservoir) []rowSnapshot {
+	out := make([]rowSnapshot, 0)
+	for _, r := range strata {
+		for _, id := range r.ids {
+			row, version := ego.row(id)
+			out = append(out, rowSnapshot{id: id, version: version, row: row})
+		}
+	}
+	return out
+}
+
+/*
+Splits the sample among the parts of the population, so the whole sample is uniform
+(multivariate hypergeometric distribution).
+
+Parameters:
+  - counts - sizes of the parts,
+  - n - size of the sample.
+
+Returns:
+  - sizes of the samples of the parts.
+*/
+func allocateSample(counts []int, n int) []int {
+	remaining := make([]int, len(counts))
+	copy(remaining, counts)
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if n >= total {
+		return remaining
+	}
+
+	out := make([]int, len(counts))
+	for ; n > 0; n-- {
+		r := rand.Intn(total)
+		for i, c := range remaining {
+			if r < c {
+				out[i]++
+				remaining[i]--
+				break
+			}
+			r -= c
+		}
+		total--
+	}
+
+	return out
+}
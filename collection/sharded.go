@@ -0,0 +1,1279 @@
+package collection
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/stream"
+)
+
+const (
+	HASH_SHARDING  = iota // The shard is selected by the hash of the shard key.
+	RANGE_SHARDING        // The shard is selected by the range the shard key falls into.
+)
+
+type ShardedCollectionConf struct {
+	ShardKey string
+	Sharding int
+	Bounds   []FielderConf // Range sharding only, ascending exclusive upper bounds of the ranges of all shards but the last one.
+}
+
+/*
+Collection distributing its records across the inner collections (shards) by the shard key column.
+CIds are unique across the shards, the shard of a record is encoded in its CId.
+The shards have to share the schema and should not be modified other than through the ShardedCollection.
+The shard key of a record cannot be changed.
+*/
+type ShardedCollection struct {
+	gonatus.Gobject
+	param     ShardedCollectionConf
+	schema    SchemaConf
+	shards    []Collection
+	keyCol    int
+	bounds    []any
+	seq       atomic.Uint64       // Sequence number of the next CId, the CId is seq * len(shards) + shard + 1.
+	hooks     map[HookId][]HookId // Identifiers of the hooks in the shards, by the identifiers of the hooks of the collection.
+	hookSeq   HookId
+	hookMutex sync.Mutex
+}
+
+/*
+Creates new ShardedCollection.
+CIds continue after the records already present in the shards.
+
+Parameters:
+  - sc - ShardedCollection Conf,
+  - shards - the inner collections.
+
+Returns:
+  - pointer to a new instance of ShardedCollection, nil if the configuration does not fit the shards.
+*/
+func NewShardedCollection(sc ShardedCollectionConf, shards ...Collection) *ShardedCollection {
+	if len(shards) == 0 {
+		return nil
+	}
+
+	ego := &ShardedCollection{param: sc, schema: shards[0].Schema(), shards: shards, hooks: make(map[HookId][]HookId)}
+
+	for _, shard := range shards[1:] {
+		if !sameFields(ego.schema, shard.Schema()) {
+			return nil
+		}
+	}
+
+	// the key has to be a stored column, computed ones may be missing in the added records
+	if ego.keyCol = slices.Index(ego.schema.FieldsNaming, sc.ShardKey); ego.keyCol == -1 || ego.keyCol >= ego.storedCols() {
+		return nil
+	}
+
+	switch sc.Sharding {
+	case HASH_SHARDING:
+		if len(sc.Bounds) > 0 {
+			return nil
+		}
+	case RANGE_SHARDING:
+		if len(sc.Bounds) != len(shards)-1 {
+			return nil
+		}
+		for i, bound := range sc.Bounds {
+			if reflect.TypeOf(bound) != reflect.TypeOf(ego.schema.Fields[ego.keyCol]) {
+				return nil
+			}
+			v, _ := fieldValue(bound)
+			if _, ordered := cmpOrderedValues(v, v); !ordered {
+				return nil
+			}
+			if i > 0 {
+				if c, _ := cmpOrderedValues(v, ego.bounds[i-1]); c <= 0 {
+					return nil
+				}
+			}
+			ego.bounds = append(ego.bounds, v)
+		}
+	default:
+		return nil
+	}
+
+	for _, shard := range shards {
+		last, err := shard.FilterPage(FilterArgument{QueryConf: QueryAndConf{}, SortOrder: DESC, Limit: 1})
+		if err != nil {
+			return nil
+		}
+		if len(last.Records) > 0 {
+			if seq := uint64(last.Records[0].Id-1)/uint64(len(shards)) + 1; seq > ego.seq.Load() {
+				ego.seq.Store(seq)
+			}
+		}
+	}
+
+	return ego
+}
+
+/*
+Checks if two schemas have the same columns.
+
+Parameters:
+  - a - the first schema,
+  - b - the second schema.
+
+Returns:
+  - true if the names and the types of the columns match, false otherwise.
+*/
+func sameFields(a SchemaConf, b SchemaConf) bool {
+	if !slices.Equal(a.FieldsNaming, b.FieldsNaming) || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if reflect.TypeOf(a.Fields[i]) != reflect.TypeOf(b.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Selects the shard for the value of the shard key.
+
+Parameters:
+  - fc - value of the shard key.
+
+Returns:
+  - position of the shard,
+  - error, if any.
+*/
+func (ego *ShardedCollection) shardOfKey(fc FielderConf) (int, error) {
+	if reflect.TypeOf(fc) != reflect.TypeOf(ego.schema.Fields[ego.keyCol]) {
+		return 0, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Shard key %s has a wrong type.", ego.param.ShardKey))
+	}
+
+	v, _ := fieldValue(fc)
+
+	if ego.param.Sharding == HASH_SHARDING {
+		return int(hashValue(v) % uint64(len(ego.shards))), nil
+	}
+
+	for i, bound := range ego.bounds {
+		if c, _ := cmpOrderedValues(v, bound); c < 0 {
+			return i, nil
+		}
+	}
+	return len(ego.bounds), nil
+}
+
+/*
+Selects the shard for the record.
+
+Parameters:
+  - rc - Configuration of the Record.
+
+Returns:
+  - position of the shard,
+  - error, if any.
+*/
+func (ego *ShardedCollection) shardOfRecord(rc RecordConf) (int, error) {
+	if len(rc.Cols) != len(ego.schema.Fields) && len(rc.Cols) != ego.storedCols() {
+		return 0, errors.NewValueError(ego, errors.LevelWarning, "Wrong number of columns")
+	}
+	return ego.shardOfKey(rc.Cols[ego.keyCol])
+}
+
+/*
+Returns:
+  - number of the columns preceding the computed ones.
+*/
+func (ego *ShardedCollection) storedCols() int {
+	return len(ego.schema.Fields) - len(ego.schema.Computed)
+}
+
+/*
+Returns:
+  - position of the shard holding the record with the given CId.
+*/
+func (ego *ShardedCollection) shardOfId(cid CId) int {
+	return int(uint64(cid-1) % uint64(len(ego.shards)))
+}
+
+/*
+Runs the function on all shards in parallel.
+
+Parameters:
+  - fn - the function, gets the position of the shard and the shard itself.
+
+Returns:
+  - the first error of the shards in their order, if any.
+*/
+func (ego *ShardedCollection) fanOut(fn func(int, Collection) error) error {
+	errs := make([]error, len(ego.shards))
+
+	var wg sync.WaitGroup
+	for i, shard := range ego.shards {
+		wg.Add(1)
+		go func(i int, shard Collection) {
+			defer wg.Done()
+			errs[i] = fn(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Returns:
+  - schema of the shards.
+*/
+func (ego *ShardedCollection) Schema() SchemaConf {
+	return ego.schema
+}
+
+/*
+Adds the record to the shard selected by its shard key.
+If the CId is given, it has to belong to that shard.
+
+Parameters:
+  - rc - Configuration of the Record.
+
+Returns:
+  - CId of newly added record,
+  - error, if any.
+*/
+func (ego *ShardedCollection) AddRecord(rc RecordConf) (CId, error) {
+	shard, err := ego.shardOfRecord(rc)
+	if err != nil {
+		return 0, err
+	}
+
+	if rc.Id, err = ego.assignId(rc.Id, shard); err != nil {
+		return 0, err
+	}
+
+	return ego.shards[shard].AddRecord(rc)
+}
+
+/*
+Generates the CId of a new record of the shard, or checks the given one and moves the sequence behind it.
+
+Parameters:
+  - cid - CId given by the user, invalid if a new one should be generated,
+  - shard - position of the shard of the record.
+
+Returns:
+  - the CId,
+  - error, if any.
+*/
+func (ego *ShardedCollection) assignId(cid CId, shard int) (CId, error) {
+	n := uint64(len(ego.shards))
+
+	if !cid.ValidP() {
+		return CId((ego.seq.Add(1)-1)*n + uint64(shard) + 1), nil
+	}
+
+	if ego.shardOfId(cid) != shard {
+		return 0, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Id %d does not belong to shard %d of the record.", cid, shard))
+	}
+	// move the sequence behind the user defined CId
+	seq := uint64(cid-1)/n + 1
+	for current := ego.seq.Load(); current < seq && !ego.seq.CompareAndSwap(current, seq); current = ego.seq.Load() {
+	}
+
+	return cid, nil
+}
+
+/*
+Deletes the record from its shard.
+
+Parameters:
+  - rc - Configuration of the Record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *ShardedCollection) DeleteRecord(rc RecordConf) error {
+	if !rc.Id.ValidP() {
+		return errors.NewMisappError(ego, "Invalid Id field in record.")
+	}
+	return ego.shards[ego.shardOfId(rc.Id)].DeleteRecord(rc)
+}
+
+/*
+Edits the record in its shard. The shard key cannot be changed.
+
+Parameters:
+  - rc - Configuration of Record.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *ShardedCollection) EditRecord(rc RecordConf) error {
+	if !rc.Id.ValidP() {
+		return errors.NewMisappError(ego, "Invalid Id field in record.")
+	}
+
+	shard, err := ego.shardOfRecord(rc)
+	if err != nil {
+		return err
+	}
+	if shard != ego.shardOfId(rc.Id) {
+		return errors.NewMisappError(ego, fmt.Sprintf("Shard key of record %d cannot be changed.", rc.Id))
+	}
+
+	return ego.shards[shard].EditRecord(rc)
+}
+
+/*
+Updates the given columns of the record in its shard. The shard key cannot be changed.
+
+Parameters:
+  - cid - CId of the record,
+  - patch - new values and the optional expected version.
+
+Returns:
+  - error, if any.
+*/
+func (ego *ShardedCollection) PatchRecord(cid CId, patch PatchConf) error {
+	if !cid.ValidP() {
+		return errors.NewMisappError(ego, "Invalid Id field in record.")
+	}
+
+	if fc, found := patch.Cols[ego.param.ShardKey]; found {
+		shard, err := ego.shardOfKey(fc)
+		if err != nil {
+			return err
+		}
+		if shard != ego.shardOfId(cid) {
+			return errors.NewMisappError(ego, fmt.Sprintf("Shard key of record %d cannot be changed.", cid))
+		}
+	}
+
+	return ego.shards[ego.shardOfId(cid)].PatchRecord(cid, patch)
+}
+
+/*
+Adds the record, or edits the existing one with the same values in the key columns.
+The key columns have to include the shard key, so the record is upserted by its shard atomically.
+The CId of the added record is generated before the lookup, so it is skipped if the record is edited.
+
+Parameters:
+  - rc - Configuration of the Record, its Id is used for the added record if valid,
+  - keys - names of the columns which identify the record.
+
+Returns:
+  - CId of the added or edited record,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Upsert(rc RecordConf, keys []string) (CId, error) {
+	if !slices.Contains(keys, ego.param.ShardKey) {
+		return 0, errors.NewMisappError(ego, fmt.Sprintf("Key columns have to include the shard key %s.", ego.param.ShardKey))
+	}
+
+	shard, err := ego.shardOfRecord(rc)
+	if err != nil {
+		return 0, err
+	}
+
+	if rc.Id, err = ego.assignId(rc.Id, shard); err != nil {
+		return 0, err
+	}
+
+	return ego.shards[shard].Upsert(rc, keys)
+}
+
+/*
+Filters the records of all shards in parallel and merges the sorted results.
+The shards are asked for the first Skip + Limit records only.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - the stream of the records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Filter(fa FilterArgument) (stream.Producer[RecordConf], error) {
+	return ego.merge(fa)
+}
+
+/*
+Looks up the records by their CIds in their shards.
+Missing and expired records are skipped.
+
+Parameters:
+  - ids - CIds of the records.
+
+Returns:
+  - the found records in the order of their CIds in the parameter,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Get(ids ...CId) ([]RecordConf, error) {
+	perShard := make([][]CId, len(ego.shards))
+	for _, cid := range ids {
+		if cid.ValidP() {
+			shard := ego.shardOfId(cid)
+			perShard[shard] = append(perShard[shard], cid)
+		}
+	}
+
+	found := make([]map[CId]RecordConf, len(ego.shards))
+	err := ego.fanOut(func(i int, shard Collection) error {
+		if len(perShard[i]) == 0 {
+			return nil
+		}
+		recs, err := shard.Get(perShard[i]...)
+		found[i] = make(map[CId]RecordConf, len(recs))
+		for _, rec := range recs {
+			found[i][rec.Id] = rec
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]RecordConf, 0, len(ids))
+	for _, cid := range ids {
+		if !cid.ValidP() {
+			continue
+		}
+		if rec, ok := found[ego.shardOfId(cid)][cid]; ok {
+			out = append(out, rec)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Filters one page of the records, together with the cursor of the next page.
+
+Parameters:
+  - fa - filter arguments, the cursor of the previous page is given in After.
+
+Returns:
+  - the page,
+  - error, if any.
+*/
+func (ego *ShardedCollection) FilterPage(fa FilterArgument) (PageConf, error) {
+	out := PageConf{Records: make([]RecordConf, 0)}
+	if fa.Limit == 0 {
+		return out, nil
+	}
+
+	limit := fa.Limit
+	if limit != NO_LIMIT {
+		// one more record tells if there is a next page
+		fa.Limit++
+	}
+
+	m, err := ego.merge(fa)
+	if err != nil {
+		return PageConf{}, err
+	}
+
+	var lastKey any
+	for {
+		rec, valid, err := m.Get()
+		if err != nil {
+			return PageConf{}, err
+		}
+		if !valid {
+			return out, nil
+		}
+		if len(out.Records) == limit {
+			m.finish()
+			if out.Next, err = m.order.encodeCursor(lastKey, out.Records[limit-1].Id); err != nil {
+				return PageConf{}, err
+			}
+			return out, nil
+		}
+		out.Records = append(out.Records, rec)
+		lastKey = m.lastKey
+	}
+}
+
+/*
+Counts the records satisfying the query in all shards.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - number of the records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Count(q QueryConf) (int, error) {
+	counts := make([]int, len(ego.shards))
+
+	err := ego.fanOut(func(i int, shard Collection) (err error) {
+		counts[i], err = shard.Count(q)
+		return
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	return total, nil
+}
+
+/*
+Checks if any record in any shard satisfies the query.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - true if there is such a record, false otherwise,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Exists(q QueryConf) (bool, error) {
+	var found atomic.Bool
+
+	err := ego.fanOut(func(_ int, shard Collection) error {
+		exists, err := shard.Exists(q)
+		if exists {
+			found.Store(true)
+		}
+		return err
+	})
+
+	return found.Load(), err
+}
+
+/*
+Draws a uniform sample of the records satisfying the query, without replacement.
+The sample is split among the shards by their counts of the matching records.
+Only the query and the order (Sort, SortOrder, Collation) of the filter argument are used,
+the past state is not sampled.
+
+Parameters:
+  - fa - Filter argument,
+  - n - size of the sample, all matching records are returned if there are not more of them.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Sample(fa FilterArgument, n int) ([]RecordConf, error) {
+	if n < 0 {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid sample size %d.", n))
+	}
+	if !fa.AsOf.IsZero() {
+		return nil, errors.NewNotImplError(ego)
+	}
+
+	counts := make([]int, len(ego.shards))
+	err := ego.fanOut(func(i int, shard Collection) (err error) {
+		counts[i], err = shard.Count(fa.QueryConf)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := allocateSample(counts, n)
+	samples := make([][]RecordConf, len(ego.shards))
+	err = ego.fanOut(func(i int, shard Collection) (err error) {
+		if sizes[i] > 0 {
+			samples[i], err = shard.Sample(FilterArgument{QueryConf: fa.QueryConf}, sizes[i])
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]RecordConf, 0, n)
+	for _, sample := range samples {
+		out = append(out, sample...)
+	}
+
+	return ego.sampleOrder(fa, out)
+}
+
+/*
+Draws a uniform sample of the records satisfying the query from each group of the records
+with the same value of the column (stratum), without replacement.
+Each shard samples its strata, strata spanning more shards are split among them by their counts.
+Only the query and the order (Sort, SortOrder, Collation) of the filter argument are used,
+the past state is not sampled.
+
+Parameters:
+  - fa - Filter argument,
+  - column - column determining the strata,
+  - n - size of the sample of each stratum.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) StratifiedSample(fa FilterArgument, column string, n int) ([]RecordConf, error) {
+	col := slices.Index(ego.schema.FieldsNaming, column)
+	if col == -1 {
+		return nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Stratum column %s not found.", column))
+	}
+	if n < 0 {
+		return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Invalid sample size %d.", n))
+	}
+	if !fa.AsOf.IsZero() {
+		return nil, errors.NewNotImplError(ego)
+	}
+
+	samples := make([][]RecordConf, len(ego.shards))
+	err := ego.fanOut(func(i int, shard Collection) (err error) {
+		samples[i], err = shard.StratifiedSample(FilterArgument{QueryConf: fa.QueryConf}, column, n)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// samples of the strata by the shards
+	strata := make(map[string][][]RecordConf)
+	for i, sample := range samples {
+		for _, rec := range sample {
+			v, _ := fieldValue(rec.Cols[col])
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			key := string(encoded)
+			if strata[key] == nil {
+				strata[key] = make([][]RecordConf, len(ego.shards))
+			}
+			strata[key][i] = append(strata[key][i], rec)
+		}
+	}
+
+	out := make([]RecordConf, 0)
+	for _, parts := range strata {
+		counts := make([]int, len(parts))
+		total := 0
+		for i, part := range parts {
+			counts[i] = len(part)
+			total += len(part)
+		}
+		if total <= n {
+			// either all records of the stratum, or a sample of a single shard
+			for _, part := range parts {
+				out = append(out, part...)
+			}
+			continue
+		}
+
+		// a full sample of a shard may come from a larger stratum
+		for i, part := range parts {
+			if len(part) < n {
+				continue
+			}
+			v, _ := fieldValue(part[0].Cols[col])
+			atom := QueryAtomConf{Name: column, Value: v, MatchType: fullmatchConfOf(ego.schema.Fields[col], column)}
+			q := QueryAndConf{QueryContextConf{Context: []QueryConf{fa.QueryConf, atom}}}
+			if counts[i], err = ego.shards[i].Count(q); err != nil {
+				return nil, err
+			}
+		}
+
+		for i, size := range allocateSample(counts, n) {
+			for _, j := range rand.Perm(len(parts[i]))[:size] {
+				out = append(out, parts[i][j])
+			}
+		}
+	}
+
+	return ego.sampleOrder(fa, out)
+}
+
+/*
+Orders the sampled records as Filter would order them.
+
+Parameters:
+  - fa - Filter argument,
+  - sample - the sampled records.
+
+Returns:
+  - the ordered records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) sampleOrder(fa FilterArgument, sample []RecordConf) ([]RecordConf, error) {
+	if len(sample) == 0 {
+		return []RecordConf{}, nil
+	}
+
+	ids := make([]CId, len(sample))
+	for i, rec := range sample {
+		ids[i] = rec.Id
+	}
+
+	return ego.collect(FilterArgument{
+		QueryConf: QueryAtomConf{Value: ids, MatchType: IdMatchConf{}},
+		Sort:      fa.Sort,
+		SortOrder: fa.SortOrder,
+		Collation: fa.Collation,
+		Limit:     NO_LIMIT,
+	})
+}
+
+/*
+Finds the distinct values of the column among the records satisfying the query, with their frequencies summed across the shards.
+
+Parameters:
+  - column - name of the column,
+  - q - the query.
+
+Returns:
+  - the values with their frequencies, the most frequent first,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Distinct(column string, q QueryConf) ([]DistinctConf, error) {
+	parts := make([][]DistinctConf, len(ego.shards))
+	err := ego.fanOut(func(i int, shard Collection) (err error) {
+		parts[i], err = shard.Distinct(column, q)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]DistinctConf, 0)
+	positions := make(map[string]int)
+	for _, part := range parts {
+		for _, dc := range part {
+			v, _ := fieldValue(dc.Value)
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			if i, found := positions[string(encoded)]; found {
+				out[i].Count += dc.Count
+				continue
+			}
+			positions[string(encoded)] = len(out)
+			out = append(out, dc)
+		}
+	}
+	sortDistinct(out)
+
+	return out, nil
+}
+
+/*
+Collects the records of the filter, in the order of the filter.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - the records,
+  - error, if any.
+*/
+func (ego *ShardedCollection) collect(fa FilterArgument) ([]RecordConf, error) {
+	m, err := ego.merge(fa)
+	if err != nil {
+		return nil, err
+	}
+	return m.Collect()
+}
+
+/*
+Deletes the records matching the filter.
+Without Skip and Limit, the shards delete their records in parallel.
+The deletion is not atomic across the shards, the records deleted before a failure stay deleted.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - Error, if any.
+*/
+func (ego *ShardedCollection) DeleteByFilter(fa FilterArgument) error {
+	if fa.Skip == 0 && fa.Limit == NO_LIMIT {
+		return ego.fanOut(func(_ int, shard Collection) error {
+			return shard.DeleteByFilter(fa)
+		})
+	}
+
+	// the records to delete have to be selected across the shards
+	recs, err := ego.collect(fa)
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		if err := ego.DeleteRecord(RecordConf{Id: rec.Id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Updates the given columns of all records matching the filter. The shard key cannot be updated.
+Without Skip and Limit, each shard updates its records atomically.
+Otherwise, the records are selected across the shards first and each of them
+is updated only if it has not been modified since.
+The update is not atomic across the shards, the records updated before a failure stay updated.
+
+Parameters:
+  - fa - filter selecting the records,
+  - patch - new values and the optional expected versions.
+
+Returns:
+  - number of updated records, including those updated before a failure,
+  - error, if any.
+*/
+func (ego *ShardedCollection) UpdateWhere(fa FilterArgument, patch PatchConf) (int, error) {
+	if _, found := patch.Cols[ego.param.ShardKey]; found {
+		return 0, errors.NewMisappError(ego, fmt.Sprintf("Shard key %s cannot be updated.", ego.param.ShardKey))
+	}
+
+	if fa.Skip == 0 && fa.Limit == NO_LIMIT {
+		counts := make([]int, len(ego.shards))
+		err := ego.fanOut(func(i int, shard Collection) (err error) {
+			counts[i], err = shard.UpdateWhere(fa, patch)
+			return
+		})
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		return total, err
+	}
+
+	recs, err := ego.collect(fa)
+	if err != nil {
+		return 0, err
+	}
+
+	if patch.Versions != nil {
+		for _, rec := range recs {
+			if expected, found := patch.Versions[rec.Id]; !found || expected != rec.Version {
+				return 0, errors.NewStateError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d has been modified.", rec.Id))
+			}
+		}
+	}
+
+	for i, rec := range recs {
+		p := PatchConf{Cols: patch.Cols, Versions: map[CId]uint64{rec.Id: rec.Version}}
+		if err := ego.shards[ego.shardOfId(rec.Id)].PatchRecord(rec.Id, p); err != nil {
+			return i, err
+		}
+	}
+
+	return len(recs), nil
+}
+
+/*
+Computes the statistics of all shards and combines them.
+Distinct counts are summed, so they are exact for the shard key
+and an upper bound for the other columns. Histograms are rebuilt
+over the combined range from the centers of the buckets of the shards.
+
+Returns:
+  - the statistics,
+  - error, if any.
+*/
+func (ego *ShardedCollection) Stats() (StatsConf, error) {
+	parts := make([]StatsConf, len(ego.shards))
+
+	err := ego.fanOut(func(i int, shard Collection) (err error) {
+		parts[i], err = shard.Stats()
+		return
+	})
+	if err != nil {
+		return StatsConf{}, err
+	}
+
+	out := StatsConf{Columns: make([]ColumnStatsConf, len(ego.schema.Fields)), Indexes: make([]IndexStatsConf, 0)}
+	for i, name := range ego.schema.FieldsNaming {
+		out.Columns[i].Name = name
+	}
+
+	positions := make(map[[2]string]int)
+	for _, part := range parts {
+		out.Rows += part.Rows
+
+		for i, col := range part.Columns {
+			merged := &out.Columns[i]
+			merged.Distinct += col.Distinct
+			if col.Min == nil {
+				continue
+			}
+			if merged.Min == nil || cmpFields(col.Min, merged.Min) < 0 {
+				merged.Min = col.Min
+			}
+			if merged.Max == nil || cmpFields(col.Max, merged.Max) > 0 {
+				merged.Max = col.Max
+			}
+		}
+
+		for _, idx := range part.Indexes {
+			key := [2]string{idx.Column, idx.Kind}
+			if pos, found := positions[key]; found {
+				out.Indexes[pos].Keys += idx.Keys
+				out.Indexes[pos].Memory += idx.Memory
+				continue
+			}
+			positions[key] = len(out.Indexes)
+			out.Indexes = append(out.Indexes, idx)
+		}
+	}
+
+	for i := range out.Columns {
+		merged := &out.Columns[i]
+		merged.Distinct = min(merged.Distinct, uint64(out.Rows))
+
+		if merged.Min == nil {
+			continue
+		}
+		lowerValue, _ := fieldValue(merged.Min)
+		upperValue, _ := fieldValue(merged.Max)
+		lower, isNum := numericValue(lowerValue)
+		if !isNum {
+			continue
+		}
+		upper, _ := numericValue(upperValue)
+
+		merged.Histogram = newBuckets(lower, upper)
+		for _, part := range parts {
+			for _, b := range part.Columns[i].Histogram {
+				merged.Histogram[bucketOf(merged.Histogram, (b.Lower+b.Upper)/2)].Count += b.Count
+			}
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Compares the values of two fields of an ordered column.
+
+Returns:
+  - negative number if a < b, positive number if a > b, zero otherwise.
+*/
+func cmpFields(a FielderConf, b FielderConf) int {
+	va, _ := fieldValue(a)
+	vb, _ := fieldValue(b)
+	c, _ := cmpOrderedValues(va, vb)
+	return c
+}
+
+/*
+Registers the hook of the mutation event on all shards.
+The hooks get the records with their global CIds, they may be called from several shards concurrently.
+
+Parameters:
+  - event - the event (BEFORE_ADD, AFTER_ADD, ...),
+  - hook - the hook.
+
+Returns:
+  - identifier of the hook, for its removal,
+  - error, if any.
+*/
+func (ego *ShardedCollection) AddHook(event int, hook MutationHook) (HookId, error) {
+	ids := make([]HookId, 0, len(ego.shards))
+	for _, shard := range ego.shards {
+		id, err := shard.AddHook(event, hook)
+		if err != nil {
+			// the hook is registered on all shards or on none
+			for j, id := range ids {
+				ego.shards[j].RemoveHook(id)
+			}
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+
+	ego.hookMutex.Lock()
+	defer ego.hookMutex.Unlock()
+
+	ego.hookSeq++
+	ego.hooks[ego.hookSeq] = ids
+	return ego.hookSeq, nil
+}
+
+/*
+Removes the registered hook from all shards. It must not be called from a hook of the collection.
+
+Parameters:
+  - id - identifier of the hook.
+
+Returns:
+  - error, if any.
+*/
+func (ego *ShardedCollection) RemoveHook(id HookId) error {
+	ego.hookMutex.Lock()
+	ids, found := ego.hooks[id]
+	delete(ego.hooks, id)
+	ego.hookMutex.Unlock()
+
+	if !found {
+		return errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Hook %d not found.", id))
+	}
+
+	for i, shard := range ego.shards {
+		if err := shard.RemoveHook(ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Commits all shards.
+
+Returns:
+  - error, if any.
+*/
+func (ego *ShardedCollection) Commit() error {
+	return ego.fanOut(func(_ int, shard Collection) error {
+		return shard.Commit()
+	})
+}
+
+/*
+Serializes ShardedCollection.
+
+Returns:
+  - configuration of the Gobject.
+*/
+func (ego *ShardedCollection) Serialize() gonatus.Conf {
+	return ego.param
+}
+
+/*
+Determines the order of the merged records, the same one the shards use.
+
+Parameters:
+  - fa - filter arguments.
+
+Returns:
+  - the order,
+  - function computing the uncollated sort key of a record, nil if the records are ordered by CId only,
+  - error, if any.
+*/
+func (ego *ShardedCollection) recordOrder(fa FilterArgument) (*rowOrder, func(RecordConf) any, error) {
+	order := &rowOrder{col: -1, desc: fa.SortOrder == DESC, conf: fa}
+
+	if len(fa.Sort) > 0 {
+		order.sort = fa.Sort[0]
+		if order.col = slices.Index(ego.schema.FieldsNaming, order.sort); order.col == -1 {
+			return nil, nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Sort column %s not found.", order.sort))
+		}
+		order.keys = make(map[CId]any)
+		return order, func(rec RecordConf) any {
+			v, _ := fieldValue(rec.Cols[order.col])
+			return v
+		}, nil
+	}
+
+	order.tieDesc = order.desc
+
+	// results of a fuzzy query are ordered by the distance first
+	if targets := fuzzyTargets(fa.QueryConf, ego.schema.FieldsNaming); len(targets) > 0 {
+		order.keys = make(map[CId]any)
+		return order, func(rec RecordConf) any {
+			return fuzzyRank(targets, func(col int) any {
+				v, _ := fieldValue(rec.Cols[col])
+				return v
+			})
+		}, nil
+	}
+
+	return order, nil, nil
+}
+
+/*
+Filters all shards in parallel and creates the stream merging their results.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - the merging stream,
+  - error, if any.
+*/
+func (ego *ShardedCollection) merge(fa FilterArgument) (*shardMerger, error) {
+	order, key, err := ego.recordOrder(fa)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := fa
+	inner.Skip = 0
+	if fa.Limit != NO_LIMIT {
+		inner.Limit = fa.Skip + fa.Limit
+	}
+
+	sources := make([]stream.Producer[RecordConf], len(ego.shards))
+	err = ego.fanOut(func(i int, shard Collection) (err error) {
+		sources[i], err = shard.Filter(inner)
+		return
+	})
+	if err != nil {
+		drainAll(sources)
+		return nil, err
+	}
+
+	m := &shardMerger{order: order, key: key, sources: sources, skip: fa.Skip, limit: fa.Limit}
+	m.DefaultProducer = *stream.NewDefaultProducer[RecordConf](m)
+	return m, nil
+}
+
+/*
+Reads the streams to their ends, so the goroutines writing them can finish.
+
+Parameters:
+  - sources - the streams, nil ones are skipped.
+*/
+func drainAll(sources []stream.Producer[RecordConf]) {
+	for _, s := range sources {
+		if s == nil {
+			continue
+		}
+		for {
+			if _, valid, err := s.Get(); err != nil || !valid {
+				break
+			}
+		}
+	}
+}
+
+// Stream merging the sorted results of the shards.
+type shardMerger struct {
+	gonatus.Gobject
+	stream.DefaultClosable
+	stream.DefaultProducer[RecordConf]
+	order   *rowOrder
+	key     func(RecordConf) any
+	sources []stream.Producer[RecordConf]
+	heads   *shardHeap
+	skip    int
+	limit   int
+	lastKey any // Uncollated sort key of the last returned record.
+}
+
+// Next record of a shard.
+type shardHead struct {
+	rec RecordConf
+	raw any // uncollated sort key
+	key any // collated sort key
+	src int
+}
+
+// Min-heap of the next records of the shards, the first record in the order is on the top.
+type shardHeap struct {
+	order *rowOrder
+	heads []shardHead
+}
+
+func (ego *shardHeap) Len() int { return len(ego.heads) }
+func (ego *shardHeap) Less(i, j int) bool {
+	a, b := ego.heads[i], ego.heads[j]
+	return ego.order.compareKeys(a.key, a.rec.Id, b.key, b.rec.Id) < 0
+}
+func (ego *shardHeap) Swap(i, j int) { ego.heads[i], ego.heads[j] = ego.heads[j], ego.heads[i] }
+func (ego *shardHeap) Push(x any)    { ego.heads = append(ego.heads, x.(shardHead)) }
+func (ego *shardHeap) Pop() any {
+	last := ego.heads[len(ego.heads)-1]
+	ego.heads = ego.heads[:len(ego.heads)-1]
+	return last
+}
+
+/*
+Reads the next record of the shard into the heap.
+
+Parameters:
+  - src - position of the shard.
+
+Returns:
+  - error, if any.
+*/
+func (ego *shardMerger) pull(src int) error {
+	rec, valid, err := ego.sources[src].Get()
+	if err != nil || !valid {
+		return err
+	}
+
+	head := shardHead{rec: rec, src: src}
+	if ego.key != nil {
+		head.raw = ego.key(rec)
+		head.key = ego.order.conf.Collation.applyValue(head.raw)
+	}
+	heap.Push(ego.heads, head)
+	return nil
+}
+
+/*
+Drains the streams of the shards and closes the merger.
+*/
+func (ego *shardMerger) finish() {
+	drainAll(ego.sources)
+	ego.Close()
+}
+
+/*
+Serializes the merger.
+
+Returns:
+  - the filter arguments.
+*/
+func (ego *shardMerger) Serialize() gonatus.Conf {
+	return ego.order.conf
+}
+
+/*
+Acquires the next record in the order.
+
+Returns:
+  - the record,
+  - true if the record is present, false otherwise,
+  - error, if any.
+*/
+func (ego *shardMerger) Get() (value RecordConf, valid bool, err error) {
+
+	if ego.heads == nil {
+		ego.heads = &shardHeap{order: ego.order}
+		for i := range ego.sources {
+			if err = ego.pull(i); err != nil {
+				ego.finish()
+				return
+			}
+		}
+	}
+
+	for ego.limit != 0 && ego.heads.Len() > 0 {
+		head := heap.Pop(ego.heads).(shardHead)
+		if err = ego.pull(head.src); err != nil {
+			ego.finish()
+			return
+		}
+		if ego.skip > 0 {
+			ego.skip--
+			continue
+		}
+		if ego.limit > 0 {
+			ego.limit--
+		}
+		ego.lastKey = head.raw
+		return head.rec, true, nil
+	}
+
+	ego.finish()
+	return
+}
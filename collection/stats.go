@@ -0,0 +1,358 @@
+package collection
+
+import (
+	"reflect"
+	"time"
+)
+
+// Number of the buckets of the histograms of numeric columns.
+const histogramBuckets = 10
+
+type BucketConf struct {
+	Lower float64
+	Upper float64 // The last bucket includes its upper bound.
+	Count int
+}
+
+type ColumnStatsConf struct {
+	Name      string
+	Distinct  uint64       // Estimated number of distinct values.
+	Min       FielderConf  // Nil for empty collections and columns without an order (slices).
+	Max       FielderConf  // Nil for empty collections and columns without an order (slices).
+	Histogram []BucketConf // Equi-width histogram, only for numeric columns.
+}
+
+type IndexStatsConf struct {
+	Column string
+	Kind   string
+	Keys   int    // Number of distinct keys (values, trie nodes, n-grams) of the index.
+	Memory uint64 // Approximate memory usage in bytes.
+}
+
+type StatsConf struct {
+	Rows    int
+	Columns []ColumnStatsConf
+	Indexes []IndexStatsConf
+}
+
+// Indexer able to report its size.
+type ramCollectionSizedIndexer interface {
+	stats() (kind string, keys int, memory uint64)
+}
+
+/*
+Approximates the memory occupied by the value.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - size in bytes.
+*/
+func valueSize(v any) uint64 {
+	switch val := v.(type) {
+	case string:
+		return 16 + uint64(len(val))
+	case []string:
+		size := uint64(24)
+		for _, s := range val {
+			size += 16 + uint64(len(s))
+		}
+		return size
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice {
+		return 24 + uint64(rv.Len())*uint64(rv.Type().Elem().Size())
+	}
+	return uint64(rv.Type().Size())
+}
+
+/*
+Computes statistics of the live rows of the collection:
+row count, estimates of distinct values, minimums, maximums and histograms of the columns,
+and sizes of the indexes.
+The sketches estimating the distinct values are kept up to date by the mutations,
+they may count the values of expired records not reaped yet and of the records removed or replaced
+since they were built, at most as many as there are records.
+
+Returns:
+  - the statistics,
+  - error, if any.
+*/
+func (ego *RamCollection) Stats() (StatsConf, error) {
+	ego.mutex.RLock()
+	defer ego.mutex.RUnlock()
+
+	fields := ego.param.Fields
+	now := time.Now()
+
+	sketches := ego.distinctSketches()
+	mins := make([]any, len(fields))
+	maxs := make([]any, len(fields))
+
+	live := make([][]any, 0, len(ego.rows))
+	for _, row := range ego.rows {
+		if ego.expired(row, now) {
+			continue
+		}
+		live = append(live, row)
+		for i, v := range row {
+			if mins[i] == nil {
+				if _, ordered := cmpOrderedValues(v, v); ordered {
+					mins[i], maxs[i] = v, v
+				}
+				continue
+			}
+			if c, _ := cmpOrderedValues(v, mins[i]); c < 0 {
+				mins[i] = v
+			}
+			if c, _ := cmpOrderedValues(v, maxs[i]); c > 0 {
+				maxs[i] = v
+			}
+		}
+	}
+
+	out := StatsConf{Rows: len(live), Columns: make([]ColumnStatsConf, len(fields)), Indexes: make([]IndexStatsConf, 0)}
+
+	for i, name := range ego.param.FieldsNaming {
+		col := ColumnStatsConf{Name: name, Distinct: sketches[i].estimate()}
+		if len(live) == 0 {
+			col.Distinct = 0
+		}
+
+		if mins[i] != nil {
+			var err error
+			if col.Min, err = ego.DeinterpretField(mins[i], i); err != nil {
+				return StatsConf{}, err
+			}
+			if col.Max, err = ego.DeinterpretField(maxs[i], i); err != nil {
+				return StatsConf{}, err
+			}
+			col.Histogram = histogram(live, i, mins[i], maxs[i])
+		}
+
+		out.Columns[i] = col
+
+		for _, idx := range ego.indexes[name] {
+			if sized, ok := idx.(ramCollectionSizedIndexer); ok {
+				kind, keys, memory := sized.stats()
+				out.Indexes = append(out.Indexes, IndexStatsConf{Column: name, Kind: kind, Keys: keys, Memory: memory})
+			}
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Provides the sketches of the distinct values of the columns, they are built on the first call
+and rebuilt when there are more removed or replaced rows than the rows.
+Has to be called under the lock.
+
+Returns:
+  - the sketches.
+*/
+func (ego *RamCollection) distinctSketches() []*hyperLogLog {
+	// readers share the lock, so they serialize the building
+	ego.sketchMutex.Lock()
+	defer ego.sketchMutex.Unlock()
+
+	if ego.sketches != nil && ego.sketchStale <= len(ego.rows) {
+		return ego.sketches
+	}
+
+	sketches := make([]*hyperLogLog, len(ego.param.Fields))
+	for i := range sketches {
+		sketches[i] = newHyperLogLog()
+	}
+	for _, row := range ego.rows {
+		for i, v := range row {
+			sketches[i].add(v)
+		}
+	}
+	ego.sketches, ego.sketchStale = sketches, 0
+
+	return sketches
+}
+
+/*
+Keeps the sketches of the distinct values up to date with the mutation of a row.
+The sketches cannot forget a value, so the removed and replaced rows are only counted.
+Has to be called under the write lock.
+
+Parameters:
+  - old - the row before the mutation, nil for additions,
+  - row - the row after the mutation, nil for deletions.
+*/
+func (ego *RamCollection) sketchRow(old []any, row []any) {
+	if ego.sketches == nil {
+		return
+	}
+
+	if old != nil {
+		ego.sketchStale++
+	}
+	for i, v := range row {
+		if old == nil || cmpFullmatchValues(old[i], v) != 0 {
+			ego.sketches[i].add(v)
+		}
+	}
+}
+
+/*
+Builds the equi-width histogram of the numeric column.
+
+Parameters:
+  - rows - the rows,
+  - col - position of the column,
+  - minimum - minimal value of the column,
+  - maximum - maximal value of the column.
+
+Returns:
+  - buckets of the histogram, nil if the column is not numeric.
+*/
+func histogram(rows [][]any, col int, minimum any, maximum any) []BucketConf {
+	lower, ok := numericValue(minimum)
+	if !ok {
+		return nil
+	}
+	upper, _ := numericValue(maximum)
+
+	out := newBuckets(lower, upper)
+	for _, row := range rows {
+		v, _ := numericValue(row[col])
+		out[bucketOf(out, v)].Count++
+	}
+
+	return out
+}
+
+/*
+Creates empty equi-width buckets covering the interval.
+A single bucket is created if the bounds are equal.
+
+Parameters:
+  - lower - lower bound of the interval,
+  - upper - upper bound of the interval.
+
+Returns:
+  - the buckets.
+*/
+func newBuckets(lower float64, upper float64) []BucketConf {
+	width := (upper - lower) / histogramBuckets
+	n := histogramBuckets
+	if width == 0 {
+		n = 1
+	}
+
+	out := make([]BucketConf, n)
+	for i := range out {
+		out[i] = BucketConf{Lower: lower + float64(i)*width, Upper: lower + float64(i+1)*width}
+	}
+	out[n-1].Upper = upper
+
+	return out
+}
+
+/*
+Finds the bucket the value falls into.
+
+Parameters:
+  - buckets - equi-width buckets,
+  - v - the value.
+
+Returns:
+  - position of the bucket.
+*/
+func bucketOf(buckets []BucketConf, v float64) int {
+	width := buckets[0].Upper - buckets[0].Lower
+	if width <= 0 {
+		return 0
+	}
+	return max(0, min(int((v-buckets[0].Lower)/width), len(buckets)-1))
+}
+
+/*
+Returns:
+  - kind, number of keys and approximate memory usage of the index.
+*/
+func (ego *fullmatchIndexer[T]) stats() (string, int, uint64) {
+	memory := uint64(0)
+	for k, ids := range ego.index {
+		memory += valueSize(k) + 24 + 8*uint64(cap(ids))
+	}
+	return "fullmatch", len(ego.index), memory
+}
+
+/*
+Returns:
+  - kind, number of keys and approximate memory usage of the index.
+*/
+func (ego *bitmapIndexer[T]) stats() (string, int, uint64) {
+	memory := uint64(0)
+	for k, bm := range ego.index {
+		memory += valueSize(k) + bm.memory()
+	}
+	return "bitmap", len(ego.index), memory
+}
+
+/*
+Returns:
+  - kind, number of keys and approximate memory usage of the index.
+*/
+func (ego *ngramIndexer) stats() (string, int, uint64) {
+	memory := uint64(0)
+	for gram, bm := range ego.index {
+		memory += valueSize(gram) + bm.memory()
+	}
+	return "ngram", len(ego.index), memory
+}
+
+/*
+Returns:
+  - kind, number of trie nodes and approximate memory usage of the index.
+*/
+func (ego *prefixIndexer[T]) stats() (string, int, uint64) {
+	var elem T
+	nodes, memory := 0, uint64(0)
+
+	var walk func(n *trieNode[T])
+	walk = func(n *trieNode[T]) {
+		nodes++
+		memory += 48 + 24 + 8*uint64(cap(n.cids)) + uint64(len(n.children))*(valueSize(elem)+8)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(ego.index)
+
+	if ego.ignoreChildren {
+		return "fullmatch", nodes, memory
+	}
+	return "prefix", nodes, memory
+}
+
+/*
+Returns:
+  - kind, number of keys and approximate memory usage of the wrapped index.
+*/
+func (ego *collatedIndexer) stats() (string, int, uint64) {
+	if sized, ok := ego.ramCollectionIndexer.(ramCollectionSizedIndexer); ok {
+		kind, keys, memory := sized.stats()
+		return "collated " + kind, keys, memory
+	}
+	return "collated", 0, 0
+}
+
+/*
+Returns:
+  - approximate memory usage of the bitmap in bytes.
+*/
+func (ego *CIdBitmap) memory() uint64 {
+	memory := 48 + 8*uint64(cap(ego.keys)) + 8*uint64(cap(ego.containers))
+	for _, c := range ego.containers {
+		memory += 64 + 2*uint64(cap(c.array)) + 8*uint64(cap(c.words))
+	}
+	return memory
+}
@@ -4,6 +4,8 @@ package collection
 
 import (
 	"cmp"
+	"encoding/json"
+	"reflect"
 	"strings"
 	"time"
 
@@ -23,66 +25,173 @@ Returns:
   - error, if any.
 */
 func (ego *RamCollection) InterpretField(fc FielderConf) (any, error) {
+	if val, ok := fieldValue(fc); ok {
+		return val, nil
+	}
+	return nil, errors.NewNotImplError(ego)
+}
+
+/*
+Extracts the value of the Field passed in the parameter.
+
+Parameters:
+  - fc - FielderConf.
+
+Returns:
+  - Value of FieldConf,
+  - true, if the type of the field is supported, false otherwise.
+*/
+func fieldValue(fc FielderConf) (any, bool) {
 	// TODO: need to copy return value <<v.Value>>
 
 	switch v := fc.(type) {
 
 	case FieldConf[[]string]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]int]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]int8]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]int16]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]int32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]int64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]uint]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]uint8]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]uint16]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]uint32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]uint64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]float32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[[]float64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[string]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[int]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[int8]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[int16]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[int32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[int64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[uint]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[uint8]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[uint16]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[uint32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[uint64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[float32]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[float64]:
-		return v.Value, nil
+		return v.Value, true
 	case FieldConf[time.Time]:
-		return v.Value, nil
+		return v.Value, true
 	default:
-		return nil, errors.NewNotImplError(ego)
+		return nil, false
+	}
+}
+
+/*
+Decodes a JSON value into the Field of type T.
+
+Parameters:
+  - raw - JSON encoded value.
+
+Returns:
+  - decoded FielderConf,
+  - error, if any.
+*/
+func decodeFieldAs[T any](raw []byte) (FielderConf, error) {
+	var val T
+	if err := json.Unmarshal(raw, &val); err != nil {
+		return nil, err
+	}
+	return FieldConf[T]{Value: val}, nil
+}
+
+/*
+Decodes a JSON value into the Field of the same type as the given one.
+
+Parameters:
+  - fc - FielderConf determining the type,
+  - raw - JSON encoded value.
+
+Returns:
+  - decoded FielderConf,
+  - error, if any.
+*/
+func decodeField(fc FielderConf, raw []byte) (FielderConf, error) {
+	switch fc.(type) {
+	case FieldConf[[]string]:
+		return decodeFieldAs[[]string](raw)
+	case FieldConf[[]int]:
+		return decodeFieldAs[[]int](raw)
+	case FieldConf[[]int8]:
+		return decodeFieldAs[[]int8](raw)
+	case FieldConf[[]int16]:
+		return decodeFieldAs[[]int16](raw)
+	case FieldConf[[]int32]:
+		return decodeFieldAs[[]int32](raw)
+	case FieldConf[[]int64]:
+		return decodeFieldAs[[]int64](raw)
+	case FieldConf[[]uint]:
+		return decodeFieldAs[[]uint](raw)
+	case FieldConf[[]uint8]:
+		return decodeFieldAs[[]uint8](raw)
+	case FieldConf[[]uint16]:
+		return decodeFieldAs[[]uint16](raw)
+	case FieldConf[[]uint32]:
+		return decodeFieldAs[[]uint32](raw)
+	case FieldConf[[]uint64]:
+		return decodeFieldAs[[]uint64](raw)
+	case FieldConf[[]float32]:
+		return decodeFieldAs[[]float32](raw)
+	case FieldConf[[]float64]:
+		return decodeFieldAs[[]float64](raw)
+	case FieldConf[string]:
+		return decodeFieldAs[string](raw)
+	case FieldConf[int]:
+		return decodeFieldAs[int](raw)
+	case FieldConf[int8]:
+		return decodeFieldAs[int8](raw)
+	case FieldConf[int16]:
+		return decodeFieldAs[int16](raw)
+	case FieldConf[int32]:
+		return decodeFieldAs[int32](raw)
+	case FieldConf[int64]:
+		return decodeFieldAs[int64](raw)
+	case FieldConf[uint]:
+		return decodeFieldAs[uint](raw)
+	case FieldConf[uint8]:
+		return decodeFieldAs[uint8](raw)
+	case FieldConf[uint16]:
+		return decodeFieldAs[uint16](raw)
+	case FieldConf[uint32]:
+		return decodeFieldAs[uint32](raw)
+	case FieldConf[uint64]:
+		return decodeFieldAs[uint64](raw)
+	case FieldConf[float32]:
+		return decodeFieldAs[float32](raw)
+	case FieldConf[float64]:
+		return decodeFieldAs[float64](raw)
+	case FieldConf[time.Time]:
+		return decodeFieldAs[time.Time](raw)
+	default:
+		return nil, errors.New(errors.ErrorConf{Type: errors.TypeNotImpl, Level: errors.LevelError, Msg: "Unsupported field type."})
 	}
 }
 
@@ -169,6 +278,7 @@ Returns:
 */
 func (ego *RamCollection) registerIndexes() error {
 	ego.primaryIndex = primaryIndexerCreate(ego.rows)
+	ego.idIndex = idIndexerNew()
 
 	columns := cols{}
 	for i, name := range ego.param.FieldsNaming {
@@ -349,6 +459,90 @@ func (ego *RamCollection) registerIndexes() error {
 				}
 				ego.indexes[v.Name] = append(ego.indexes[v.Name], fullmatchIndexerNew[time.Time](v))
 				name = v.Name
+			case BitmapIndexConf[string]:
+				if _, found := columns[v.Name].fc.(FieldConf[string]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[string](v))
+				name = v.Name
+			case BitmapIndexConf[int]:
+				if _, found := columns[v.Name].fc.(FieldConf[int]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[int](v))
+				name = v.Name
+			case BitmapIndexConf[int8]:
+				if _, found := columns[v.Name].fc.(FieldConf[int8]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[int8](v))
+				name = v.Name
+			case BitmapIndexConf[int16]:
+				if _, found := columns[v.Name].fc.(FieldConf[int16]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[int16](v))
+				name = v.Name
+			case BitmapIndexConf[int32]:
+				if _, found := columns[v.Name].fc.(FieldConf[int32]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[int32](v))
+				name = v.Name
+			case BitmapIndexConf[int64]:
+				if _, found := columns[v.Name].fc.(FieldConf[int64]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[int64](v))
+				name = v.Name
+			case BitmapIndexConf[uint]:
+				if _, found := columns[v.Name].fc.(FieldConf[uint]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[uint](v))
+				name = v.Name
+			case BitmapIndexConf[uint8]:
+				if _, found := columns[v.Name].fc.(FieldConf[uint8]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[uint8](v))
+				name = v.Name
+			case BitmapIndexConf[uint16]:
+				if _, found := columns[v.Name].fc.(FieldConf[uint16]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[uint16](v))
+				name = v.Name
+			case BitmapIndexConf[uint32]:
+				if _, found := columns[v.Name].fc.(FieldConf[uint32]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[uint32](v))
+				name = v.Name
+			case BitmapIndexConf[uint64]:
+				if _, found := columns[v.Name].fc.(FieldConf[uint64]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[uint64](v))
+				name = v.Name
+			case BitmapIndexConf[float32]:
+				if _, found := columns[v.Name].fc.(FieldConf[float32]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[float32](v))
+				name = v.Name
+			case BitmapIndexConf[float64]:
+				if _, found := columns[v.Name].fc.(FieldConf[float64]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[float64](v))
+				name = v.Name
+			case BitmapIndexConf[time.Time]:
+				if _, found := columns[v.Name].fc.(FieldConf[time.Time]); !found || !columns.checkNum(v.Name, bitmapIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], bitmapIndexerNew[time.Time](v))
+				name = v.Name
 			case FullmatchIndexConf[[]string]:
 				if _, found := columns[v.Name].fc.(FieldConf[[]string]); !found || !columns.checkNum(v.Name, fullmatchIndexBit) {
 					return errors.NewNotImplError(ego)
@@ -427,9 +621,23 @@ func (ego *RamCollection) registerIndexes() error {
 				}
 				ego.indexes[v.Name] = append(ego.indexes[v.Name], prefixIndexerNewIgnore[float64](v))
 				name = v.Name
+			case NgramIndexConf:
+				if _, found := columns[v.Name].fc.(FieldConf[string]); !found || !columns.checkNum(v.Name, ngramIndexBit) {
+					return errors.NewNotImplError(ego)
+				}
+				ego.indexes[v.Name] = append(ego.indexes[v.Name], ngramIndexerNew(v))
+				name = v.Name
 			default:
 				return errors.NewNotImplError(ego)
 			}
+
+			if collation, collatable := collationOf(idx); collation != (CollationConf{}) {
+				if !collatable {
+					return errors.NewNotImplError(ego)
+				}
+				last := len(ego.indexes[name]) - 1
+				ego.indexes[name][last] = &collatedIndexer{ramCollectionIndexer: ego.indexes[name][last], collation: collation}
+			}
 		}
 	}
 	if len(indexes) > 0 && len(indexes[0]) > 0 && !ego.checkName(name) {
@@ -439,6 +647,60 @@ func (ego *RamCollection) registerIndexes() error {
 	return nil
 }
 
+/*
+Reads the collation from the configuration of the index.
+
+Parameters:
+  - conf - configuration of the index (or the match type of the query).
+
+Returns:
+  - the collation,
+  - true if the index is of a string column, so it can be collated, false otherwise.
+*/
+func collationOf(conf IndexerConf) (CollationConf, bool) {
+	switch v := conf.(type) {
+	case PrefixIndexConf[string]:
+		return v.Collation, true
+	case PrefixIndexConf[[]string]:
+		return v.Collation, true
+	case FullmatchIndexConf[string]:
+		return v.Collation, true
+	case FullmatchIndexConf[[]string]:
+		return v.Collation, true
+	}
+
+	// confs of other types may have the collation set by mistake
+	if rv := reflect.ValueOf(conf); rv.Kind() == reflect.Struct {
+		if f := rv.FieldByName("Collation"); f.IsValid() {
+			return f.Interface().(CollationConf), false
+		}
+	}
+	return CollationConf{}, false
+}
+
+/*
+Determines the kind of the substring match of the query.
+
+Parameters:
+  - mt - match type of the query.
+
+Returns:
+  - the kind of the match,
+  - true if the query is a substring match, false otherwise.
+*/
+func substringKindOf(mt IndexerConf) (int, bool) {
+	switch mt.(type) {
+	case SuffixMatchConf:
+		return SUFFIX_MATCH, true
+	case ContainsMatchConf:
+		return CONTAINS_MATCH, true
+	case GlobMatchConf:
+		return GLOB_MATCH, true
+	default:
+		return 0, false
+	}
+}
+
 /*
 Compares the indexer kind specified in the query
 and the indexers specified in the RamCollection.
@@ -524,71 +786,160 @@ func cmpIndexKind(qIdx IndexerConf, iidx ramCollectionIndexer) bool {
 		}
 	case FullmatchIndexConf[string]:
 		_, ok := iidx.(*fullmatchIndexer[string])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[string])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[int]:
 		_, ok := iidx.(*fullmatchIndexer[int])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[int])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[int8]:
 		_, ok := iidx.(*fullmatchIndexer[int8])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[int8])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[int16]:
 		_, ok := iidx.(*fullmatchIndexer[int16])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[int16])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[int32]:
 		_, ok := iidx.(*fullmatchIndexer[int32])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[int32])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[int64]:
 		_, ok := iidx.(*fullmatchIndexer[int64])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[int64])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[uint]:
 		_, ok := iidx.(*fullmatchIndexer[uint])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[uint])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[uint8]:
 		_, ok := iidx.(*fullmatchIndexer[uint8])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[uint8])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[uint16]:
 		_, ok := iidx.(*fullmatchIndexer[uint16])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[uint16])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[uint32]:
 		_, ok := iidx.(*fullmatchIndexer[uint32])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[uint32])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[uint64]:
 		_, ok := iidx.(*fullmatchIndexer[uint64])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[uint64])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[float32]:
 		_, ok := iidx.(*fullmatchIndexer[float32])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[float32])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[float64]:
 		_, ok := iidx.(*fullmatchIndexer[float64])
-		if ok {
+		_, okBitmap := iidx.(*bitmapIndexer[float64])
+		if ok || okBitmap {
 			return true
 		}
 	case FullmatchIndexConf[time.Time]:
 		_, ok := iidx.(*fullmatchIndexer[time.Time])
+		_, okBitmap := iidx.(*bitmapIndexer[time.Time])
+		if ok || okBitmap {
+			return true
+		}
+	case BitmapIndexConf[string]:
+		_, ok := iidx.(*bitmapIndexer[string])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[int]:
+		_, ok := iidx.(*bitmapIndexer[int])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[int8]:
+		_, ok := iidx.(*bitmapIndexer[int8])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[int16]:
+		_, ok := iidx.(*bitmapIndexer[int16])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[int32]:
+		_, ok := iidx.(*bitmapIndexer[int32])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[int64]:
+		_, ok := iidx.(*bitmapIndexer[int64])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[uint]:
+		_, ok := iidx.(*bitmapIndexer[uint])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[uint8]:
+		_, ok := iidx.(*bitmapIndexer[uint8])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[uint16]:
+		_, ok := iidx.(*bitmapIndexer[uint16])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[uint32]:
+		_, ok := iidx.(*bitmapIndexer[uint32])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[uint64]:
+		_, ok := iidx.(*bitmapIndexer[uint64])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[float32]:
+		_, ok := iidx.(*bitmapIndexer[float32])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[float64]:
+		_, ok := iidx.(*bitmapIndexer[float64])
+		if ok {
+			return true
+		}
+	case BitmapIndexConf[time.Time]:
+		_, ok := iidx.(*bitmapIndexer[time.Time])
+		if ok {
+			return true
+		}
+	case SuffixMatchConf, ContainsMatchConf, GlobMatchConf:
+		_, ok := iidx.(*ngramIndexer)
 		if ok {
 			return true
 		}
@@ -661,6 +1012,77 @@ func cmpIndexKind(qIdx IndexerConf, iidx ramCollectionIndexer) bool {
 	return false
 }
 
+/*
+Creates the fullmatch index configuration matching the type of the given field.
+
+Parameters:
+  - fc - FielderConf of the column,
+  - name - name of the column.
+
+Returns:
+  - FullmatchIndexConf of the corresponding type, nil if the type is not supported.
+*/
+func fullmatchConfOf(fc FielderConf, name string) IndexerConf {
+	switch fc.(type) {
+	case FieldConf[[]string]:
+		return FullmatchIndexConf[[]string]{Name: name}
+	case FieldConf[[]int]:
+		return FullmatchIndexConf[[]int]{Name: name}
+	case FieldConf[[]int8]:
+		return FullmatchIndexConf[[]int8]{Name: name}
+	case FieldConf[[]int16]:
+		return FullmatchIndexConf[[]int16]{Name: name}
+	case FieldConf[[]int32]:
+		return FullmatchIndexConf[[]int32]{Name: name}
+	case FieldConf[[]int64]:
+		return FullmatchIndexConf[[]int64]{Name: name}
+	case FieldConf[[]uint]:
+		return FullmatchIndexConf[[]uint]{Name: name}
+	case FieldConf[[]uint8]:
+		return FullmatchIndexConf[[]uint8]{Name: name}
+	case FieldConf[[]uint16]:
+		return FullmatchIndexConf[[]uint16]{Name: name}
+	case FieldConf[[]uint32]:
+		return FullmatchIndexConf[[]uint32]{Name: name}
+	case FieldConf[[]uint64]:
+		return FullmatchIndexConf[[]uint64]{Name: name}
+	case FieldConf[[]float32]:
+		return FullmatchIndexConf[[]float32]{Name: name}
+	case FieldConf[[]float64]:
+		return FullmatchIndexConf[[]float64]{Name: name}
+	case FieldConf[string]:
+		return FullmatchIndexConf[string]{Name: name}
+	case FieldConf[int]:
+		return FullmatchIndexConf[int]{Name: name}
+	case FieldConf[int8]:
+		return FullmatchIndexConf[int8]{Name: name}
+	case FieldConf[int16]:
+		return FullmatchIndexConf[int16]{Name: name}
+	case FieldConf[int32]:
+		return FullmatchIndexConf[int32]{Name: name}
+	case FieldConf[int64]:
+		return FullmatchIndexConf[int64]{Name: name}
+	case FieldConf[uint]:
+		return FullmatchIndexConf[uint]{Name: name}
+	case FieldConf[uint8]:
+		return FullmatchIndexConf[uint8]{Name: name}
+	case FieldConf[uint16]:
+		return FullmatchIndexConf[uint16]{Name: name}
+	case FieldConf[uint32]:
+		return FullmatchIndexConf[uint32]{Name: name}
+	case FieldConf[uint64]:
+		return FullmatchIndexConf[uint64]{Name: name}
+	case FieldConf[float32]:
+		return FullmatchIndexConf[float32]{Name: name}
+	case FieldConf[float64]:
+		return FullmatchIndexConf[float64]{Name: name}
+	case FieldConf[time.Time]:
+		return FullmatchIndexConf[time.Time]{Name: name}
+	default:
+		return nil
+	}
+}
+
 // QUERY
 
 /*
@@ -911,6 +1333,76 @@ func (ego *QueryAtomConf) isFullmatch(rc *RamCollection, idx int) bool {
 			return false
 		}
 		return true
+	case BitmapIndexConf[string]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[string]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[int]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[int]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[int8]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[int8]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[int16]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[int16]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[int32]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[int32]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[int64]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[int64]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[uint]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[uint]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[uint8]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[uint8]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[uint16]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[uint16]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[uint32]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[uint32]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[uint64]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[uint64]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[float32]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[float32]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[float64]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[float64]); !isMatch {
+			return false
+		}
+		return true
+	case BitmapIndexConf[time.Time]:
+		if _, isMatch := rc.param.Fields[idx].(FieldConf[time.Time]); !isMatch {
+			return false
+		}
+		return true
 	default:
 		return false
 	}
@@ -1092,7 +1584,7 @@ func cmpFullmatchValues(tableValue any, queryValue any) int {
 			return 0
 		}
 	case []int:
-		if qValue, isMatch := queryValue.([]int); isMatch && (len(qValue) == len(tValue)) && (len(qValue) == len(tValue)) {
+		if qValue, isMatch := queryValue.([]int); isMatch && (len(qValue) == len(tValue)) {
 			for i, elem := range tValue {
 				if elem != qValue[i] {
 					return -1
@@ -1202,3 +1694,72 @@ func cmpFullmatchValues(tableValue any, queryValue any) int {
 	}
 	return -1
 }
+
+/*
+Converts the value of a numeric column to float64.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - the number,
+  - true if the value is numeric, false otherwise.
+*/
+func numericValue(v any) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case int8:
+		return float64(val), true
+	case int16:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint8:
+		return float64(val), true
+	case uint16:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case float32:
+		return float64(val), true
+	case float64:
+		return val, true
+	default:
+		return 0, false
+	}
+}
+
+/*
+Compares two values of an ordered column (numbers, strings and times).
+
+Parameters:
+  - a - the first value,
+  - b - the second value.
+
+Returns:
+  - negative number if a < b, positive number if a > b, zero if they are equal,
+  - false if the values have no order, true otherwise.
+*/
+func cmpOrderedValues(a any, b any) (int, bool) {
+	switch aValue := a.(type) {
+	case time.Time:
+		if bValue, isMatch := b.(time.Time); isMatch {
+			return aValue.Compare(bValue), true
+		}
+		return 0, false
+	case string:
+		return cmpFullmatchValues(a, b), true
+	default:
+		if _, isNum := numericValue(a); isNum {
+			return cmpFullmatchValues(a, b), true
+		}
+		return 0, false
+	}
+}
@@ -0,0 +1,374 @@
+package collection
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/DanielSvub/gonatus/errors"
+)
+
+type PatchConf struct {
+	Cols     map[string]FielderConf // New values of the columns by name, other columns stay untouched.
+	Versions map[CId]uint64         // Expected versions of the rows, if set, nothing is updated when any of the matching rows differs.
+}
+
+/*
+Adds the record, or edits the existing one with the same values in the key columns.
+The lookup and the modification are done atomically.
+
+Parameters:
+  - rc - Configuration of the Record, its Id is used for the added record if valid,
+  - keys - names of the columns which identify the record.
+
+Returns:
+  - CId of the added or edited record,
+  - error, if any.
+*/
+func (ego *RamCollection) Upsert(rc RecordConf, keys []string) (CId, error) {
+	if len(keys) == 0 {
+		return 0, errors.NewMisappError(ego, "No key columns given.")
+	}
+
+	if !ego.validLength(len(rc.Cols)) {
+		return 0, errors.NewValueError(ego, errors.LevelWarning, "Wrong number of columns")
+	}
+
+	keyCols := make([]int, len(keys))
+	for i, name := range keys {
+		if keyCols[i] = ego.getFieldIndex(name); keyCols[i] == -1 {
+			return 0, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Column %s not found.", name))
+		}
+	}
+
+	record, err := ego.InterpretRecord(rc)
+	if err != nil {
+		return 0, err
+	}
+
+	defer ego.lockWrite(false)()
+
+	found, err := ego.findByKey(record, keyCols)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(found) {
+	case 0:
+		return ego.addRow(rc)
+	case 1:
+		rc.Id = found[0]
+		return rc.Id, ego.editRow(rc)
+	default:
+		return 0, errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("Key %v is not unique.", keys))
+	}
+}
+
+/*
+Finds the live rows having the same values in the key columns as the given row.
+A fullmatch index of the first key column is used if available.
+Has to be called under the lock.
+
+Parameters:
+  - record - values of the row,
+  - keyCols - positions of the key columns.
+
+Returns:
+  - CIds of the matching rows,
+  - error, if any.
+*/
+func (ego *RamCollection) findByKey(record []any, keyCols []int) ([]CId, error) {
+	var candidates []CId
+	var err error
+
+	first := keyCols[0]
+	name := ego.param.FieldsNaming[first]
+	if idx := ego.getIndex(QueryAtomConf{Name: name, MatchType: fullmatchConfOf(ego.param.Fields[first], name)}); idx != nil {
+		candidates, err = idx.Get(record[first])
+	} else {
+		arg := make([]any, len(record))
+		arg[first] = record[first]
+		candidates, err = ego.primaryIndex.Get(arg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]CId, 0, 1)
+
+	for _, cid := range candidates {
+		row := ego.rows[cid]
+		if ego.expired(row, now) {
+			continue
+		}
+		match := true
+		for _, col := range keyCols[1:] {
+			if cmpFullmatchValues(row[col], record[col]) != 0 {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, cid)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+Updates the given columns of all rows matching the filter.
+The evaluation of the filter and the update are done atomically,
+so the filter works as the condition of a compare-and-set.
+Either all rows are updated or none, the before-hooks, computed columns
+and checks of all rows are processed before any row is changed.
+
+Parameters:
+  - fa - filter selecting the rows,
+  - patch - new values and the optional expected versions.
+
+Returns:
+  - number of updated rows,
+  - error, if any.
+*/
+func (ego *RamCollection) UpdateWhere(fa FilterArgument, patch PatchConf) (int, error) {
+	cols, err := ego.interpretPatch(patch)
+	if err != nil {
+		return 0, err
+	}
+
+	defer ego.lockWrite(false)()
+
+	retFilter, err := ego.filterQueryEval(fa.QueryConf)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	rows := make([]rowSnapshot, 0, len(retFilter))
+	for id := range retFilter {
+		if row := ego.rows[id]; !ego.expired(row, now) {
+			rows = append(rows, rowSnapshot{id: id, version: ego.versions[id], row: row})
+		}
+	}
+
+	if rows, err = ego.makeItSorted(rows, fa); err != nil {
+		return 0, err
+	}
+
+	if patch.Versions != nil {
+		for _, r := range rows {
+			if expected, found := patch.Versions[r.id]; !found || expected != r.version {
+				return 0, errors.NewStateError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d has been modified.", r.id))
+			}
+		}
+	}
+
+	// all rows are prepared first, so a failure of any row leaves all of them untouched
+	patches := make([]rowPatch, len(rows))
+	for i, r := range rows {
+		if patches[i], err = ego.preparePatch(r.id, cols, 0); err != nil {
+			return 0, err
+		}
+	}
+
+	var journal indexJournal
+	for _, p := range patches {
+		if err := ego.reindex(p.cid, p.row, p.patched, &journal); err != nil {
+			journal.rollback()
+			return 0, err
+		}
+	}
+
+	for _, p := range patches {
+		ego.commitPatch(p)
+	}
+
+	return len(rows), nil
+}
+
+/*
+Updates the given columns of the record, other columns stay untouched.
+If the expected version of the record is given in the patch, it has to match.
+
+Parameters:
+  - cid - CId of the record,
+  - patch - new values and the optional expected version.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) PatchRecord(cid CId, patch PatchConf) error {
+	cols, err := ego.interpretPatch(patch)
+	if err != nil {
+		return err
+	}
+
+	defer ego.lockWrite(false)()
+
+	if _, found := ego.rows[cid]; !found {
+		return errors.NewNotFoundError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d not found.", cid))
+	}
+
+	return ego.patchRow(cid, cols, patch.Versions[cid])
+}
+
+/*
+Converts the columns of the patch to their positions and internal values.
+
+Parameters:
+  - patch - the patch.
+
+Returns:
+  - values by the positions of the columns,
+  - error, if any.
+*/
+func (ego *RamCollection) interpretPatch(patch PatchConf) (map[int]any, error) {
+	cols := make(map[int]any, len(patch.Cols))
+
+	for name, fc := range patch.Cols {
+		col := ego.getFieldIndex(name)
+		if col == -1 {
+			return nil, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Column %s not found.", name))
+		}
+		if col >= ego.storedCols {
+			return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Column %s is computed.", name))
+		}
+		if reflect.TypeOf(fc) != reflect.TypeOf(ego.param.Fields[col]) {
+			return nil, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Wrong type of column %s.", name))
+		}
+		val, err := ego.InterpretField(fc)
+		if err != nil {
+			return nil, err
+		}
+		cols[col] = val
+	}
+
+	return cols, nil
+}
+
+/*
+Overwrites the given columns of the existing row and modifies them in the lookup indexes.
+Only the changed columns are re-indexed. If the version is not zero, it has to match
+the current version of the row. Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - cid - CId of the row,
+  - cols - new values by the positions of the columns,
+  - version - expected version of the row, zero means any.
+
+Returns:
+  - error, if any.
+*/
+func (ego *RamCollection) patchRow(cid CId, cols map[int]any, version uint64) error {
+	p, err := ego.preparePatch(cid, cols, version)
+	if err != nil {
+		return err
+	}
+
+	var journal indexJournal
+	if err := ego.reindex(cid, p.row, p.patched, &journal); err != nil {
+		journal.rollback()
+		return err
+	}
+
+	ego.commitPatch(p)
+	return nil
+}
+
+// Patch of a row prepared to be applied.
+type rowPatch struct {
+	cid     CId
+	old     RecordConf // state before the patch passed to the hooks, empty if there are no edit hooks
+	row     []any      // current row
+	patched []any      // new row
+}
+
+/*
+Prepares the patch of the row: checks the version, runs the before-hooks,
+computes the computed columns and checks the new row. The collection is not changed.
+Has to be called under the locks taken by lockWrite.
+
+Parameters:
+  - cid - CId of the row,
+  - cols - new values by the positions of the columns,
+  - version - expected version of the row, zero means any.
+
+Returns:
+  - the prepared patch,
+  - error, if any.
+*/
+func (ego *RamCollection) preparePatch(cid CId, cols map[int]any, version uint64) (rowPatch, error) {
+	p := rowPatch{cid: cid, row: ego.rows[cid]}
+
+	if version != 0 && version != ego.versions[cid] {
+		return p, errors.NewStateError(ego, errors.LevelWarning, fmt.Sprintf("Record with id %d has been modified (version %d, expected %d).", cid, ego.versions[cid], version))
+	}
+
+	if ego.hooked(BEFORE_EDIT, AFTER_EDIT) {
+		var err error
+		if p.old, err = ego.hookRecord(cid, p.row); err != nil {
+			return p, err
+		}
+	}
+
+	if ego.hooked(BEFORE_EDIT) {
+		patched := slices.Clone(p.row)
+		for col, val := range cols {
+			patched[col] = val
+		}
+		rec, err := ego.hookRecord(cid, patched)
+		if err != nil {
+			return p, err
+		}
+		m := MutationConf{Event: BEFORE_EDIT, Old: p.old, New: rec}
+		if err := ego.runBeforeHooks(&m); err != nil {
+			return p, err
+		}
+		if cols, err = ego.hookPatch(m.New); err != nil {
+			return p, err
+		}
+	}
+
+	cols, err := ego.patchComputed(p.row, cols)
+	if err != nil {
+		return p, err
+	}
+
+	// copy on write, the original row may be still read by a running filter
+	p.patched = slices.Clone(p.row)
+	for col, val := range cols {
+		p.patched[col] = val
+	}
+
+	if len(ego.checks) > 0 || len(ego.references) > 0 {
+		if err := ego.checkRow(cid, p.patched, nil); err != nil {
+			return p, err
+		}
+	}
+
+	if len(ego.referrers) > 0 {
+		if err := ego.checkTargets(cid, p.row, p.patched); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+/*
+Stores the patched row, whose indexes have been modified already, and runs the after-hooks.
+Has to be called under the write lock.
+
+Parameters:
+  - p - the patch.
+*/
+func (ego *RamCollection) commitPatch(p rowPatch) {
+	ego.rows[p.cid] = p.patched
+	ego.versions[p.cid]++
+	ego.addRevision(p.cid, p.patched)
+	ego.sketchRow(p.row, p.patched)
+	ego.runAfterHooks(AFTER_EDIT, p.cid, p.old)
+}
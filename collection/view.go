@@ -0,0 +1,811 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/DanielSvub/gonatus"
+	"github.com/DanielSvub/gonatus/errors"
+	"github.com/DanielSvub/stream"
+)
+
+// Aggregate functions of the grouped views.
+const (
+	AGG_COUNT = iota // Number of the records of the group, an int column.
+	AGG_SUM          // Sum of the column, a float64 column.
+	AGG_AVG          // Average of the column, a float64 column.
+	AGG_MIN          // Minimum of the column, a float64 column.
+	AGG_MAX          // Maximum of the column, a float64 column.
+)
+
+type AggregateConf struct {
+	Name     string // Name of the column of the view.
+	Function int
+	Column   string // Aggregated numeric column of the source, ignored by AGG_COUNT.
+}
+
+type GroupConf struct {
+	Columns    []string // Columns of the source identifying the groups, they precede the aggregates in the view.
+	Aggregates []AggregateConf
+}
+
+type MaterializedViewConf struct {
+	Name           string
+	FilterArgument                 // Only the query is used, the view keeps all matching records, so the limit has to be NO_LIMIT.
+	Group          *GroupConf      // Nil means the view holds the matching records of the source themselves, under their CIds.
+	Indexes        [][]IndexerConf // Indexes of the view, nil means the indexes of the source for an ungrouped view.
+}
+
+// Contribution of a source record to the view.
+type viewMember struct {
+	version uint64
+	group   string    // key of the group, grouped views only
+	values  []float64 // values of the aggregated columns, grouped views only
+}
+
+// State of a group of a grouped view.
+type viewGroup struct {
+	cid    CId // CId of the record of the view, zero if not stored yet
+	key    []FielderConf
+	count  int
+	sums   []float64
+	values []orderedValues // multisets of the values, for the minimum and maximum
+}
+
+// Multiset of the values of an aggregated column, ordered for the minimum and maximum.
+type orderedValues struct {
+	values []float64 // distinct values in ascending order
+	counts []int     // multiplicities of the values
+	nans   int       // NaNs have no order, they are only counted
+}
+
+/*
+Collection holding the results of a query over the source collection, optionally grouped and aggregated.
+The view is kept up to date by the hooks of the source, it serves the reads, all writes are refused.
+If a mutation of the source cannot be applied to the view, its reads fail from then on.
+*/
+type MaterializedView struct {
+	gonatus.Gobject
+	param   MaterializedViewConf
+	view    *RamCollection // the materialized records
+	scratch *RamCollection // evaluates the query on single records
+	groupBy []int          // positions of the group columns in the source
+	aggCols []int          // positions of the aggregated columns in the source, -1 for AGG_COUNT
+	members map[CId]viewMember
+	groups  map[string]*viewGroup
+	source  Collection
+	hooks   []HookId       // hooks registered in the source
+	pending []MutationConf // mutations of the source during the initial filling
+	filling bool
+	closed  bool
+	broken  error      // failure of the maintenance, the view is out of date then
+	mutex   sync.Mutex // Serializes the maintenance.
+}
+
+/*
+Creates new MaterializedView of the source collection and fills it.
+
+Parameters:
+  - mc - MaterializedView Conf,
+  - source - the source collection.
+
+Returns:
+  - pointer to a new instance of MaterializedView, nil if the configuration does not fit the source.
+*/
+func NewMaterializedView(mc MaterializedViewConf, source Collection) *MaterializedView {
+	if mc.Skip != 0 || mc.Limit != NO_LIMIT || !mc.AsOf.IsZero() || mc.After != "" {
+		return nil
+	}
+
+	// the records of the source have been checked already
+	base := source.Schema()
+	base.Checks = nil
+	base.References = nil
+
+	ego := &MaterializedView{param: mc, members: make(map[CId]viewMember), groups: make(map[string]*viewGroup), filling: true}
+
+	// the query decides the membership, the expiry is left to the source
+	scratch := base
+	scratch.ExpiryColumn = ""
+	scratch.TTL = 0
+	if ego.scratch = NewRamCollection(RamCollectionConf{SchemaConf: scratch}); ego.scratch == nil {
+		return nil
+	}
+
+	schema, err := ego.viewSchema(base)
+	if err != nil {
+		return nil
+	}
+	if ego.view = NewRamCollection(RamCollectionConf{SchemaConf: schema}); ego.view == nil {
+		return nil
+	}
+
+	// hooks first, so no mutation is missed, those preceding the filling are skipped by their versions
+	ego.source = source
+	for _, event := range []int{AFTER_ADD, AFTER_EDIT, AFTER_DELETE} {
+		id, err := source.AddHook(event, ego.onMutation)
+		if err != nil {
+			ego.Close()
+			return nil
+		}
+		ego.hooks = append(ego.hooks, id)
+	}
+
+	s, err := source.Filter(FilterArgument{QueryConf: mc.QueryConf, Limit: NO_LIMIT})
+	if err != nil {
+		ego.Close()
+		return nil
+	}
+	records, err := s.Collect()
+	if err != nil {
+		ego.Close()
+		return nil
+	}
+
+	if err := ego.fill(records); err != nil {
+		ego.Close()
+		return nil
+	}
+
+	return ego
+}
+
+/*
+Fills the view by the records of the source and applies the mutations of the source made meanwhile.
+
+Parameters:
+  - records - the matching records of the source.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) fill(records []RecordConf) error {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	for _, rec := range records {
+		if err := ego.enter(rec.Id, rec, rec.Version); err != nil {
+			return err
+		}
+	}
+	for _, m := range ego.pending {
+		if err := ego.apply(m); err != nil {
+			return err
+		}
+	}
+	ego.pending = nil
+	ego.filling = false
+
+	return nil
+}
+
+/*
+Creates the schema of the view and resolves the group and aggregated columns.
+
+Parameters:
+  - base - schema of the source.
+
+Returns:
+  - schema of the view,
+  - error, if any.
+*/
+func (ego *MaterializedView) viewSchema(base SchemaConf) (SchemaConf, error) {
+	group := ego.param.Group
+	if group == nil {
+		base.Name = ego.param.Name
+		if ego.param.Indexes != nil {
+			base.Indexes = ego.param.Indexes
+		}
+		return base, nil
+	}
+
+	out := SchemaConf{Name: ego.param.Name, Indexes: ego.param.Indexes}
+
+	for _, name := range group.Columns {
+		col := slices.Index(base.FieldsNaming, name)
+		if col == -1 {
+			return SchemaConf{}, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Group column %s not found.", name))
+		}
+		ego.groupBy = append(ego.groupBy, col)
+		out.FieldsNaming = append(out.FieldsNaming, name)
+		out.Fields = append(out.Fields, base.Fields[col])
+	}
+
+	for _, agg := range group.Aggregates {
+		switch agg.Function {
+		case AGG_COUNT:
+			ego.aggCols = append(ego.aggCols, -1)
+			out.Fields = append(out.Fields, FieldConf[int]{})
+		case AGG_SUM, AGG_AVG, AGG_MIN, AGG_MAX:
+			col := slices.Index(base.FieldsNaming, agg.Column)
+			if col == -1 {
+				return SchemaConf{}, errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("Aggregated column %s not found.", agg.Column))
+			}
+			if zero, _ := fieldValue(base.Fields[col]); !isNumeric(zero) {
+				return SchemaConf{}, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Aggregated column %s is not numeric.", agg.Column))
+			}
+			ego.aggCols = append(ego.aggCols, col)
+			out.Fields = append(out.Fields, FieldConf[float64]{})
+		default:
+			return SchemaConf{}, errors.NewValueError(ego, errors.LevelError, fmt.Sprintf("Unknown aggregate function %d.", agg.Function))
+		}
+		out.FieldsNaming = append(out.FieldsNaming, agg.Name)
+	}
+
+	return out, nil
+}
+
+/*
+Checks if the value is numeric.
+
+Parameters:
+  - v - the value.
+
+Returns:
+  - true if it is, false otherwise.
+*/
+func isNumeric(v any) bool {
+	_, ok := numericValue(v)
+	return ok
+}
+
+/*
+Hook of the source, applies its mutations to the view.
+
+Parameters:
+  - m - the mutation.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) onMutation(m *MutationConf) error {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+
+	switch {
+	case ego.closed || ego.broken != nil:
+		return nil
+	case ego.filling:
+		ego.pending = append(ego.pending, *m)
+		return nil
+	}
+
+	if err := ego.apply(*m); err != nil {
+		// the view misses the mutation, so it cannot be read anymore
+		ego.broken = errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("Materialized view is out of date, a mutation of the source failed: %v", err))
+		return err
+	}
+	return nil
+}
+
+/*
+Applies the mutation of the source.
+Has to be called under the mutex.
+
+Parameters:
+  - m - the mutation.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) apply(m MutationConf) error {
+	var cid CId
+	var version uint64
+	if m.Event == AFTER_DELETE {
+		cid, version = m.Old.Id, m.Old.Version
+	} else {
+		cid, version = m.New.Id, m.New.Version
+	}
+
+	member, found := ego.members[cid]
+	if found && member.version > version {
+		// mutation preceding the state the view has already
+		return nil
+	}
+
+	matches := false
+	if m.Event != AFTER_DELETE {
+		var err error
+		if matches, err = ego.matches(m.New); err != nil {
+			return err
+		}
+	}
+
+	if ego.param.Group == nil {
+		switch {
+		case found && matches:
+			ego.members[cid] = viewMember{version: version}
+			return ego.view.EditRecord(RecordConf{Id: cid, Cols: m.New.Cols})
+		case found:
+			delete(ego.members, cid)
+			return ego.view.DeleteRecord(RecordConf{Id: cid})
+		case matches:
+			return ego.enter(cid, m.New, version)
+		}
+		return nil
+	}
+
+	if found {
+		if err := ego.leave(cid, member); err != nil {
+			return err
+		}
+	}
+	if matches {
+		return ego.enter(cid, m.New, version)
+	}
+	return nil
+}
+
+/*
+Checks if the source record satisfies the query of the view.
+Has to be called under the mutex.
+
+Parameters:
+  - rec - the record.
+
+Returns:
+  - true if it does, false otherwise,
+  - error, if any.
+*/
+func (ego *MaterializedView) matches(rec RecordConf) (bool, error) {
+	cid, err := ego.scratch.AddRecord(RecordConf{Id: rec.Id, Cols: rec.Cols})
+	if err != nil {
+		return false, err
+	}
+	defer ego.scratch.DeleteRecord(RecordConf{Id: cid})
+
+	n, err := ego.scratch.Count(ego.param.QueryConf)
+	return n > 0, err
+}
+
+/*
+Adds the matching source record to the view.
+Has to be called under the mutex.
+
+Parameters:
+  - cid - CId of the source record,
+  - rec - the record,
+  - version - version of the record.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) enter(cid CId, rec RecordConf, version uint64) error {
+	if ego.param.Group == nil {
+		ego.members[cid] = viewMember{version: version}
+		_, err := ego.view.AddRecord(RecordConf{Id: cid, Cols: rec.Cols})
+		return err
+	}
+
+	key := make([]FielderConf, len(ego.groupBy))
+	vals := make([]any, len(ego.groupBy))
+	for i, col := range ego.groupBy {
+		key[i] = rec.Cols[col]
+		vals[i], _ = fieldValue(rec.Cols[col])
+	}
+	encoded, err := json.Marshal(vals)
+	if err != nil {
+		return err
+	}
+
+	member := viewMember{version: version, group: string(encoded), values: make([]float64, len(ego.aggCols))}
+	for i, col := range ego.aggCols {
+		if col != -1 {
+			v, _ := fieldValue(rec.Cols[col])
+			member.values[i], _ = numericValue(v)
+		}
+	}
+
+	g, found := ego.groups[member.group]
+	if !found {
+		g = &viewGroup{key: key, sums: make([]float64, len(ego.aggCols)), values: make([]orderedValues, len(ego.aggCols))}
+		ego.groups[member.group] = g
+	}
+
+	g.count++
+	for i, v := range member.values {
+		if !math.IsNaN(v) {
+			g.sums[i] += v
+		}
+		g.values[i].add(v)
+	}
+	ego.members[cid] = member
+
+	return ego.store(g)
+}
+
+/*
+Removes the contribution of the source record from its group.
+Has to be called under the mutex.
+
+Parameters:
+  - cid - CId of the source record,
+  - member - its contribution.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) leave(cid CId, member viewMember) error {
+	delete(ego.members, cid)
+
+	g := ego.groups[member.group]
+	g.count--
+	for i, v := range member.values {
+		if !math.IsNaN(v) {
+			g.sums[i] -= v
+		}
+		g.values[i].remove(v)
+	}
+
+	if g.count == 0 {
+		delete(ego.groups, member.group)
+		return ego.view.DeleteRecord(RecordConf{Id: g.cid})
+	}
+
+	return ego.store(g)
+}
+
+/*
+Adds the value to the multiset.
+
+Parameters:
+  - v - the value.
+*/
+func (ego *orderedValues) add(v float64) {
+	if math.IsNaN(v) {
+		ego.nans++
+		return
+	}
+	i, found := slices.BinarySearch(ego.values, v)
+	if found {
+		ego.counts[i]++
+		return
+	}
+	ego.values = slices.Insert(ego.values, i, v)
+	ego.counts = slices.Insert(ego.counts, i, 1)
+}
+
+/*
+Removes one occurrence of the value from the multiset.
+
+Parameters:
+  - v - the value.
+*/
+func (ego *orderedValues) remove(v float64) {
+	if math.IsNaN(v) {
+		ego.nans--
+		return
+	}
+	i, found := slices.BinarySearch(ego.values, v)
+	if !found {
+		return
+	}
+	if ego.counts[i]--; ego.counts[i] == 0 {
+		ego.values = slices.Delete(ego.values, i, i+1)
+		ego.counts = slices.Delete(ego.counts, i, i+1)
+	}
+}
+
+/*
+Has to be called on a non-empty multiset.
+
+Returns:
+  - the minimum, NaN if there is a NaN, as math.Min does.
+*/
+func (ego *orderedValues) min() float64 {
+	if ego.nans > 0 {
+		return math.NaN()
+	}
+	return ego.values[0]
+}
+
+/*
+Has to be called on a non-empty multiset.
+
+Returns:
+  - the maximum, NaN if there is a NaN, as math.Max does.
+*/
+func (ego *orderedValues) max() float64 {
+	if ego.nans > 0 {
+		return math.NaN()
+	}
+	return ego.values[len(ego.values)-1]
+}
+
+/*
+Writes the aggregates of the group to the view.
+Has to be called under the mutex.
+
+Parameters:
+  - g - the group.
+
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) store(g *viewGroup) error {
+	cols := slices.Clone(g.key)
+
+	for i, agg := range ego.param.Group.Aggregates {
+		switch agg.Function {
+		case AGG_COUNT:
+			cols = append(cols, FieldConf[int]{Value: g.count})
+		case AGG_SUM, AGG_AVG:
+			v := g.sums[i]
+			if agg.Function == AGG_AVG {
+				v /= float64(g.count)
+			}
+			if g.values[i].nans > 0 {
+				v = math.NaN()
+			}
+			cols = append(cols, FieldConf[float64]{Value: v})
+		case AGG_MIN:
+			cols = append(cols, FieldConf[float64]{Value: g.values[i].min()})
+		case AGG_MAX:
+			cols = append(cols, FieldConf[float64]{Value: g.values[i].max()})
+		}
+	}
+
+	if g.cid == 0 {
+		var err error
+		g.cid, err = ego.view.AddRecord(RecordConf{Cols: cols})
+		return err
+	}
+
+	return ego.view.EditRecord(RecordConf{Id: g.cid, Cols: cols})
+}
+
+/*
+Stops the maintenance of the view and removes its hooks from the source, the records stay readable.
+*/
+func (ego *MaterializedView) Close() {
+	ego.mutex.Lock()
+	ego.closed = true
+	ego.pending = nil
+	hooks := ego.hooks
+	ego.hooks = nil
+	ego.mutex.Unlock()
+
+	// outside of the mutex, the hooks take it under the lock of the source
+	for _, id := range hooks {
+		if err := ego.source.RemoveHook(id); err != nil {
+			ego.Log().Error("Hook of the view cannot be removed.", "id", id, "error", err)
+		}
+	}
+}
+
+/*
+Returns:
+  - the failure of the maintenance, nil if the view is up to date.
+*/
+func (ego *MaterializedView) failure() error {
+	ego.mutex.Lock()
+	defer ego.mutex.Unlock()
+	return ego.broken
+}
+
+/*
+Returns:
+  - an error, the view is read-only.
+*/
+func (ego *MaterializedView) readOnly() error {
+	return errors.NewStateError(ego, errors.LevelError, "Materialized view is read-only.")
+}
+
+/*
+Returns:
+  - schema of the view.
+*/
+func (ego *MaterializedView) Schema() SchemaConf {
+	return ego.view.Schema()
+}
+
+/*
+Looks up the records of the view by their CIds.
+
+Parameters:
+  - ids - CIds of the records.
+
+Returns:
+  - the found records in the order of their CIds in the parameter,
+  - error, if any.
+*/
+func (ego *MaterializedView) Get(ids ...CId) ([]RecordConf, error) {
+	if err := ego.failure(); err != nil {
+		return nil, err
+	}
+	return ego.view.Get(ids...)
+}
+
+/*
+Filters the records of the view.
+
+Parameters:
+  - fa - Filter argument.
+
+Returns:
+  - the stream of the records,
+  - error, if any.
+*/
+func (ego *MaterializedView) Filter(fa FilterArgument) (stream.Producer[RecordConf], error) {
+	if err := ego.failure(); err != nil {
+		return nil, err
+	}
+	return ego.view.Filter(fa)
+}
+
+/*
+Filters one page of the records of the view.
+
+Parameters:
+  - fa - filter arguments.
+
+Returns:
+  - the page,
+  - error, if any.
+*/
+func (ego *MaterializedView) FilterPage(fa FilterArgument) (PageConf, error) {
+	if err := ego.failure(); err != nil {
+		return PageConf{}, err
+	}
+	return ego.view.FilterPage(fa)
+}
+
+/*
+Counts the records of the view satisfying the query.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - number of the records,
+  - error, if any.
+*/
+func (ego *MaterializedView) Count(q QueryConf) (int, error) {
+	if err := ego.failure(); err != nil {
+		return 0, err
+	}
+	return ego.view.Count(q)
+}
+
+/*
+Checks if any record of the view satisfies the query.
+
+Parameters:
+  - q - the query.
+
+Returns:
+  - true if there is such a record, false otherwise,
+  - error, if any.
+*/
+func (ego *MaterializedView) Exists(q QueryConf) (bool, error) {
+	if err := ego.failure(); err != nil {
+		return false, err
+	}
+	return ego.view.Exists(q)
+}
+
+/*
+Draws a uniform sample of the records of the view satisfying the query.
+
+Parameters:
+  - fa - Filter argument,
+  - n - size of the sample.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *MaterializedView) Sample(fa FilterArgument, n int) ([]RecordConf, error) {
+	if err := ego.failure(); err != nil {
+		return nil, err
+	}
+	return ego.view.Sample(fa, n)
+}
+
+/*
+Draws a uniform sample of the records of the view satisfying the query from each stratum of the column.
+
+Parameters:
+  - fa - Filter argument,
+  - column - column determining the strata,
+  - n - size of the sample of each stratum.
+
+Returns:
+  - the sampled records,
+  - error, if any.
+*/
+func (ego *MaterializedView) StratifiedSample(fa FilterArgument, column string, n int) ([]RecordConf, error) {
+	if err := ego.failure(); err != nil {
+		return nil, err
+	}
+	return ego.view.StratifiedSample(fa, column, n)
+}
+
+/*
+Finds the distinct values of the column among the records of the view satisfying the query, with their frequencies.
+
+Parameters:
+  - column - name of the column,
+  - q - the query.
+
+Returns:
+  - the values with their frequencies, the most frequent first,
+  - error, if any.
+*/
+func (ego *MaterializedView) Distinct(column string, q QueryConf) ([]DistinctConf, error) {
+	if err := ego.failure(); err != nil {
+		return nil, err
+	}
+	return ego.view.Distinct(column, q)
+}
+
+/*
+Computes the statistics of the records of the view.
+
+Returns:
+  - the statistics,
+  - error, if any.
+*/
+func (ego *MaterializedView) Stats() (StatsConf, error) {
+	if err := ego.failure(); err != nil {
+		return StatsConf{}, err
+	}
+	return ego.view.Stats()
+}
+
+func (ego *MaterializedView) AddRecord(RecordConf) (CId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *MaterializedView) DeleteRecord(RecordConf) error {
+	return ego.readOnly()
+}
+
+func (ego *MaterializedView) DeleteByFilter(FilterArgument) error {
+	return ego.readOnly()
+}
+
+func (ego *MaterializedView) EditRecord(RecordConf) error {
+	return ego.readOnly()
+}
+
+func (ego *MaterializedView) PatchRecord(CId, PatchConf) error {
+	return ego.readOnly()
+}
+
+func (ego *MaterializedView) Upsert(RecordConf, []string) (CId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *MaterializedView) UpdateWhere(FilterArgument, PatchConf) (int, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *MaterializedView) AddHook(int, MutationHook) (HookId, error) {
+	return 0, ego.readOnly()
+}
+
+func (ego *MaterializedView) RemoveHook(HookId) error {
+	return ego.readOnly()
+}
+
+/*
+Returns:
+  - error, if any.
+*/
+func (ego *MaterializedView) Commit() error {
+	return nil
+}
+
+/*
+Serializes MaterializedView.
+
+Returns:
+  - configuration of the Gobject.
+*/
+func (ego *MaterializedView) Serialize() gonatus.Conf {
+	return ego.param
+}
@@ -57,15 +57,13 @@ const (
 	fieldModifTime
 )
 
+var fieldNames = []string{"parent", "path", "flags", "location", "origTime", "modifTime"}
+
 /*
 Abstraction of the collection record. Simplifies getting of the column values.
 */
 type record collection.RecordConf
 
-func (ego *record) conf() collection.RecordConf {
-	return collection.RecordConf(*ego)
-}
-
 func (ego *record) path() fs.Path {
 	return fs.Path(ego.Cols[fieldPath].(collection.FieldConf[[]string]).Value)
 }
@@ -106,7 +104,7 @@ func NewLocalCountedStorage(conf LocalCountedStorageConf) fs.Storage {
 	ego.files = collection.NewRamCollection(collection.RamCollectionConf{
 		SchemaConf: collection.SchemaConf{
 			Name:         "FileTable",
-			FieldsNaming: []string{"parent", "path", "flags", "location", "origTime", "modifTime"},
+			FieldsNaming: fieldNames,
 			Fields: []collection.FielderConf{
 				collection.FieldConf[uint64]{},
 				collection.FieldConf[[]string]{},
@@ -184,6 +182,24 @@ func (ego *localCountedStorageDriver) findFile(path fs.Path) (*record, error) {
 
 }
 
+/*
+Checks if a file with the given path exists, without acquiring its record.
+
+Parameters:
+  - path - absolute path to the file.
+
+Returns:
+  - true if the file exists, false otherwise,
+  - error if any occurred.
+*/
+func (ego *localCountedStorageDriver) fileExists(path fs.Path) (bool, error) {
+	return ego.files.Exists(collection.QueryAtomConf{
+		Name:      "path",
+		Value:     []string(path),
+		MatchType: collection.FullmatchIndexConf[[]string]{},
+	})
+}
+
 /*
 Executes the given function over records of all files in the given path (unlimited recurse).
 
@@ -318,8 +334,9 @@ func (ego *localCountedStorageDriver) createDir(path fs.Path, origTime time.Time
 
 	if rec != nil {
 		if rec.flags()&fs.FileTopology == 0 {
-			rec.Cols[fieldFlags] = collection.FieldConf[uint8]{Value: uint8(rec.flags() | fs.FileTopology)}
-			return rec.Id, ego.files.EditRecord(rec.conf())
+			return rec.Id, ego.files.PatchRecord(rec.Id, collection.PatchConf{Cols: map[string]collection.FielderConf{
+				fieldNames[fieldFlags]: collection.FieldConf[uint8]{Value: uint8(rec.flags() | fs.FileTopology)},
+			}})
 		}
 		return rec.Id, nil
 	}
@@ -398,9 +415,9 @@ Returns:
 */
 func (ego *localCountedStorageDriver) moveFile(source fs.Path, dest fs.Path) error {
 
-	if rec, err := ego.findFile(dest); err != nil {
+	if exists, err := ego.fileExists(dest); err != nil {
 		return err
-	} else if rec != nil {
+	} else if exists {
 		return errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("file %s already exists in the destination path", dest.String()))
 	}
 
@@ -451,9 +468,9 @@ Returns:
 */
 func (ego *localCountedStorageDriver) copyFile(source fs.Path, parent collection.CId, dest fs.Path) error {
 
-	if rec, err := ego.findFile(dest); err != nil {
+	if exists, err := ego.fileExists(dest); err != nil {
 		return err
-	} else if rec != nil {
+	} else if exists {
 		return errors.NewStateError(ego, errors.LevelError, fmt.Sprintf("file %s already exists in the destination path", dest.String()))
 	}
 
@@ -614,13 +631,14 @@ func (ego *localCountedStorageDriver) closeDescriptor(descriptor *localFileDescr
 	descriptor.fd.Close()
 
 	if descriptor.mode != fs.ModeRead {
-		if rec, err := ego.findFile(path); err != nil {
+		err := ego.files.PatchRecord(descriptor.fileId, collection.PatchConf{Cols: map[string]collection.FielderConf{
+			fieldNames[fieldModifTime]: collection.FieldConf[time.Time]{Value: time.Now()},
+		}})
+		if errors.OfType(err, errors.TypeNotFound) {
 			return errors.NewNotFoundError(ego, errors.LevelError, fmt.Sprintf("missing entry in the file table for file %s", path.String()))
-		} else {
-			rec.Cols[fieldModifTime] = collection.FieldConf[time.Time]{Value: time.Now()}
-			if err := ego.files.EditRecord(rec.conf()); err != nil {
-				return err
-			}
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -701,9 +719,11 @@ func (ego *localCountedStorageDriver) Open(path fs.Path, mode fs.FileMode, given
 			if err != nil {
 				return nil, err
 			}
-			rec.Cols[fieldFlags] = collection.FieldConf[uint8]{Value: uint8(rec.flags() | fs.FileContent)}
-			rec.Cols[fieldLocation] = collection.FieldConf[string]{Value: location}
-			if err := ego.files.EditRecord(rec.conf()); err != nil {
+			err = ego.files.PatchRecord(rec.Id, collection.PatchConf{Cols: map[string]collection.FielderConf{
+				fieldNames[fieldFlags]:    collection.FieldConf[uint8]{Value: uint8(rec.flags() | fs.FileContent)},
+				fieldNames[fieldLocation]: collection.FieldConf[string]{Value: location},
+			}})
+			if err != nil {
 				return nil, err
 			}
 		}
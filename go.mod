@@ -5,4 +5,5 @@ module github.com/DanielSvub/gonatus
 require (
 	github.com/DanielSvub/stream v1.0.1
 	github.com/mitchellh/mapstructure v1.5.0
+	golang.org/x/text v0.21.0
 )
@@ -109,11 +109,14 @@ func (ego *ndjsonOutput[T]) Run(callback func(T)) error {
 	ego.file = file
 
 	for {
-		value, valid, err := ego.Consume()
+		var value T
+		var valid bool
+		value, valid, err = ego.Consume()
 		if !valid || err != nil {
 			break
 		}
-		nd, err := json.Marshal(value)
+		var nd []byte
+		nd, err = json.Marshal(value)
 		if err != nil {
 			break
 		}
@@ -130,7 +133,9 @@ func (ego *ndjsonOutput[T]) Run(callback func(T)) error {
 		}
 	}
 
-	ego.file.Close()
+	if cerr := ego.file.Close(); err == nil {
+		err = cerr
+	}
 
 	return err
 